@@ -0,0 +1,462 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"go.etcd.io/bbolt"
+)
+
+const referencesPrefix = "references."
+
+// indexBucket holds one nested bucket per collection with the keys
+// relation, referenced ID and referencing ID separated by null bytes.
+var indexBucket = []byte("\x00index")
+
+// Repository stores every collection in its own bucket of an embedded bbolt database.
+// Documents are stored as JSON under their "id" property.
+// References are indexed so that queries on "references.*" don't need to scan the collection.
+type Repository struct {
+	db *bbolt.DB
+}
+
+func Open(path string) (Repository, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return Repository{}, storage.DBError{Message: err.Error()}
+	}
+
+	return New(db), nil
+}
+
+func New(db *bbolt.DB) Repository {
+	return Repository{db: db}
+}
+
+func (s Repository) Close() error {
+	return s.db.Close()
+}
+
+// JSONEncoded marks the repository as storing the JSON encoding of documents.
+func (s Repository) JSONEncoded() {}
+
+func (s Repository) Create(collectionName string, data interface{}) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return create(tx, collectionName, data)
+	})
+}
+
+func (s Repository) CreateAll(collectionName string, data []interface{}) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		for _, v := range data {
+			err := create(tx, collectionName, v)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s Repository) Read(collectionName, id string, result interface{}) error {
+	var document []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(collectionName))
+		if bucket == nil {
+			return nil
+		}
+
+		document = copyBytes(bucket.Get([]byte(id)))
+
+		return nil
+	})
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	if document == nil {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+
+	return decode(document, result)
+}
+
+func (s Repository) Update(collectionName, id string, data interface{}) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return update(tx, collectionName, id, data)
+	})
+}
+
+func (s Repository) Delete(collectionName, id string) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return remove(tx, collectionName, id)
+	})
+}
+
+func (s Repository) ReadAll(collectionName string, query storage.Query, result interface{}) error {
+	documents := [][]byte{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		documents, err = readAll(tx, collectionName, query)
+
+		return err
+	})
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return decode(append(append([]byte("["), bytes.Join(documents, []byte(","))...), ']'), result)
+}
+
+func (s Repository) update(fn func(tx *bbolt.Tx) error) error {
+	return wrap(s.db.Update(fn))
+}
+
+func create(tx *bbolt.Tx, collectionName string, data interface{}) error {
+	id, document, err := encode(data)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(collectionName))
+	if err != nil {
+		return err
+	}
+
+	if bucket.Get([]byte(id)) != nil {
+		return storage.DBError{Message: fmt.Sprintf("duplicate id %q in %q", id, collectionName)}
+	}
+
+	err = bucket.Put([]byte(id), document)
+	if err != nil {
+		return err
+	}
+
+	return index(tx, collectionName, id, document)
+}
+
+func update(tx *bbolt.Tx, collectionName, id string, data interface{}) error {
+	_, document, err := encode(data)
+	if err != nil {
+		return err
+	}
+
+	err = remove(tx, collectionName, id)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Bucket([]byte(collectionName)).Put([]byte(id), document)
+	if err != nil {
+		return err
+	}
+
+	return index(tx, collectionName, id, document)
+}
+
+func remove(tx *bbolt.Tx, collectionName, id string) error {
+	bucket := tx.Bucket([]byte(collectionName))
+	if bucket == nil {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+
+	document := bucket.Get([]byte(id))
+	if document == nil {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+
+	err := unindex(tx, collectionName, id, document)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Delete([]byte(id))
+}
+
+func readAll(tx *bbolt.Tx, collectionName string, query storage.Query) ([][]byte, error) {
+	documents := [][]byte{}
+
+	bucket := tx.Bucket([]byte(collectionName))
+	if bucket == nil {
+		return documents, nil
+	}
+
+	ids, indexed := lookupIndex(tx, collectionName, query)
+
+	matches := []map[string]interface{}{}
+	match := func(document []byte) error {
+		decoded := map[string]interface{}{}
+		err := json.Unmarshal(document, &decoded)
+		if err != nil {
+			return err
+		}
+
+		if query.Match(decoded) {
+			documents = append(documents, copyBytes(document))
+			matches = append(matches, decoded)
+		}
+
+		return nil
+	}
+
+	if !indexed {
+		err := bucket.ForEach(func(k, v []byte) error {
+			return match(v)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return sortDocuments(query, documents, matches), nil
+	}
+
+	for _, id := range ids {
+		document := bucket.Get([]byte(id))
+		if document == nil {
+			continue
+		}
+
+		err := match(document)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sortDocuments(query, documents, matches), nil
+}
+
+// sortDocuments sorts the documents by the sort fields of the query using their decoded versions.
+func sortDocuments(query storage.Query, documents [][]byte, decoded []map[string]interface{}) [][]byte {
+	if len(query.Sort) == 0 {
+		return documents
+	}
+
+	order := make([]int, len(documents))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return query.Less(decoded[order[i]], decoded[order[j]])
+	})
+
+	sorted := make([][]byte, len(documents))
+	for i, k := range order {
+		sorted[i] = documents[k]
+	}
+
+	return sorted
+}
+
+// lookupIndex returns the sorted IDs of all candidates for the query if it restricts at least one reference.
+func lookupIndex(tx *bbolt.Tx, collectionName string, query storage.Query) ([]string, bool) {
+	var candidates map[string]bool
+
+	for k, v := range query.Q {
+		if !strings.HasPrefix(k, referencesPrefix) || v.Kind == storage.QueryOr {
+			continue
+		}
+
+		relationName := strings.TrimPrefix(k, referencesPrefix)
+
+		var matches map[string]bool
+		for i, value := range v.Values {
+			referenceID, ok := value.(string)
+			if !ok {
+				return nil, false
+			}
+
+			ids := lookupReference(tx, collectionName, relationName, referenceID)
+			if i == 0 {
+				matches = ids
+				continue
+			}
+
+			matches = combine(matches, ids, v.Kind == storage.QueryAnd)
+		}
+
+		if matches == nil {
+			continue
+		}
+
+		if candidates == nil {
+			candidates = matches
+			continue
+		}
+
+		candidates = combine(candidates, matches, true)
+	}
+
+	if candidates == nil {
+		return nil, false
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, true
+}
+
+func lookupReference(tx *bbolt.Tx, collectionName, relationName, referenceID string) map[string]bool {
+	result := map[string]bool{}
+
+	indexes := tx.Bucket(indexBucket)
+	if indexes == nil {
+		return result
+	}
+
+	bucket := indexes.Bucket([]byte(collectionName))
+	if bucket == nil {
+		return result
+	}
+
+	prefix := indexKey(relationName, referenceID, "")
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		result[string(k[len(prefix):])] = true
+	}
+
+	return result
+}
+
+func combine(a, b map[string]bool, intersect bool) map[string]bool {
+	result := map[string]bool{}
+	for id := range a {
+		if !intersect || b[id] {
+			result[id] = true
+		}
+	}
+
+	if !intersect {
+		for id := range b {
+			result[id] = true
+		}
+	}
+
+	return result
+}
+
+func index(tx *bbolt.Tx, collectionName, id string, document []byte) error {
+	keys, err := indexKeys(id, document)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	indexes, err := tx.CreateBucketIfNotExists(indexBucket)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := indexes.CreateBucketIfNotExists([]byte(collectionName))
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = bucket.Put(k, []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unindex(tx *bbolt.Tx, collectionName, id string, document []byte) error {
+	keys, err := indexKeys(id, document)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	indexes := tx.Bucket(indexBucket)
+	if indexes == nil {
+		return nil
+	}
+
+	bucket := indexes.Bucket([]byte(collectionName))
+	if bucket == nil {
+		return nil
+	}
+
+	for _, k := range keys {
+		err = bucket.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func indexKeys(id string, document []byte) ([][]byte, error) {
+	referencing := struct {
+		References map[string]interface{} `json:"references"`
+	}{}
+	err := json.Unmarshal(document, &referencing)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := [][]byte{}
+	for relationName, references := range referencing.References {
+		ids, ok := references.([]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, referenceID := range ids {
+			if referenceID, ok := referenceID.(string); ok {
+				keys = append(keys, indexKey(relationName, referenceID, id))
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+func indexKey(relationName, referenceID, id string) []byte {
+	return []byte(relationName + "\x00" + referenceID + "\x00" + id)
+}
+
+func encode(data interface{}) (string, []byte, error) {
+	document, err := json.Marshal(data)
+	if err != nil {
+		return "", nil, storage.DBError{Message: err.Error()}
+	}
+
+	identified := struct {
+		ID string `json:"id"`
+	}{}
+	err = json.Unmarshal(document, &identified)
+	if err != nil {
+		return "", nil, storage.DBError{Message: err.Error()}
+	}
+
+	return identified.ID, document, nil
+}
+
+func decode(document []byte, result interface{}) error {
+	err := json.Unmarshal(document, result)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,176 @@
+package bolt
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"github.com/DanShu93/jsonmancer/storage/storagetest"
+	"go.etcd.io/bbolt"
+)
+
+func openRepository(t *testing.T) (storage.Repository, func()) {
+	dir, err := ioutil.TempDir("", "jsonmancer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := Open(filepath.Join(dir, "jsonmancer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository, func() {
+		repository.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, openRepository)
+}
+
+func TestReferenceIndex(t *testing.T) {
+	r, release := openRepository(t)
+	defer release()
+	repository := r.(Repository)
+
+	document := func(id string, references map[string][]string) storage.CollapsedResource {
+		return storage.CollapsedResource{ID: id, Data: map[string]interface{}{}, References: references}
+	}
+
+	steps := []struct {
+		name     string
+		change   func() error
+		expected []string
+	}{
+		{
+			name: "create",
+			change: func() error {
+				err := repository.Create("article", document("1", map[string][]string{"authors": {"a", "b"}, "editor": {"c"}}))
+				if err != nil {
+					return err
+				}
+
+				return repository.CreateAll("article", []interface{}{document("2", map[string][]string{"authors": {"a"}})})
+			},
+			expected: []string{"authors a 1", "authors a 2", "authors b 1", "editor c 1"},
+		},
+		{
+			name: "update",
+			change: func() error {
+				return repository.Update("article", "1", document("1", map[string][]string{"authors": {"b", "d"}}))
+			},
+			expected: []string{"authors a 2", "authors b 1", "authors d 1"},
+		},
+		{
+			name: "delete",
+			change: func() error {
+				return repository.Delete("article", "2")
+			},
+			expected: []string{"authors b 1", "authors d 1"},
+		},
+		{
+			name: "transaction",
+			change: func() error {
+				return repository.Transaction(func(tx storage.Repository) error {
+					err := tx.Update("article", "1", document("1", map[string][]string{"editor": {"b"}}))
+					if err != nil {
+						return err
+					}
+
+					return tx.Create("article", document("3", map[string][]string{"authors": {"b"}}))
+				})
+			},
+			expected: []string{"authors b 3", "editor b 1"},
+		},
+		{
+			name: "rolled back transaction",
+			change: func() error {
+				err := repository.Transaction(func(tx storage.Repository) error {
+					err := tx.Delete("article", "3")
+					if err != nil {
+						return err
+					}
+
+					err = tx.Update("article", "1", document("1", map[string][]string{}))
+					if err != nil {
+						return err
+					}
+
+					return errors.New("rolled back")
+				})
+				if err == nil {
+					return errors.New("expected the transaction to fail")
+				}
+
+				return nil
+			},
+			expected: []string{"authors b 3", "editor b 1"},
+		},
+		{
+			name: "delete within transaction",
+			change: func() error {
+				return repository.Transaction(func(tx storage.Repository) error {
+					return tx.Delete("article", "3")
+				})
+			},
+			expected: []string{"editor b 1"},
+		},
+	}
+
+	for _, step := range steps {
+		err := step.change()
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+
+		keys := indexedKeys(t, repository, "article")
+		if !reflect.DeepEqual(keys, step.expected) {
+			t.Errorf("%s: expected the index %v, got %v", step.name, step.expected, keys)
+		}
+	}
+
+	result := []storage.CollapsedResource{}
+	query := storage.Query{Q: map[string]storage.FieldQuery{"references.editor": {Kind: storage.QueryContains, Values: []interface{}{"b"}}}}
+	err := repository.ReadAll("article", query, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].ID != "1" {
+		t.Errorf("expected the indexed article, got %+v", result)
+	}
+}
+
+// indexedKeys lists the keys of the reference index of the collection with spaces instead of null bytes.
+func indexedKeys(t *testing.T, repository Repository, collectionName string) []string {
+	keys := []string{}
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		indexes := tx.Bucket(indexBucket)
+		if indexes == nil {
+			return nil
+		}
+
+		bucket := indexes.Bucket([]byte(collectionName))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			keys = append(keys, strings.Replace(string(k), "\x00", " ", -1))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,97 @@
+package bolt
+
+import (
+	"bytes"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"go.etcd.io/bbolt"
+)
+
+// Transaction runs fn in a single read-write transaction.
+// As bbolt has a single writer, other writes wait until fn returns.
+func (s Repository) Transaction(fn func(storage.Repository) error) error {
+	var fnErr error
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		fnErr = fn(txRepository{tx: tx})
+
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+// txRepository is a repository acting within a transaction.
+type txRepository struct {
+	tx *bbolt.Tx
+}
+
+// JSONEncoded marks the repository as storing JSON like the one it belongs to.
+func (s txRepository) JSONEncoded() {}
+
+func (s txRepository) Create(collectionName string, data interface{}) error {
+	return wrap(create(s.tx, collectionName, data))
+}
+
+func (s txRepository) CreateAll(collectionName string, data []interface{}) error {
+	for _, v := range data {
+		err := create(s.tx, collectionName, v)
+		if err != nil {
+			return wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (s txRepository) Read(collectionName, id string, result interface{}) error {
+	bucket := s.tx.Bucket([]byte(collectionName))
+	if bucket == nil {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+
+	document := bucket.Get([]byte(id))
+	if document == nil {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+
+	return decode(document, result)
+}
+
+func (s txRepository) Update(collectionName, id string, data interface{}) error {
+	return wrap(update(s.tx, collectionName, id, data))
+}
+
+func (s txRepository) Delete(collectionName, id string) error {
+	return wrap(remove(s.tx, collectionName, id))
+}
+
+func (s txRepository) ReadAll(collectionName string, query storage.Query, result interface{}) error {
+	documents, err := readAll(s.tx, collectionName, query)
+	if err != nil {
+		return wrap(err)
+	}
+
+	return decode(append(append([]byte("["), bytes.Join(documents, []byte(","))...), ']'), result)
+}
+
+func (s txRepository) Transaction(fn func(storage.Repository) error) error {
+	return fn(s)
+}
+
+// wrap turns errors of bbolt into storage.DBError.
+func wrap(err error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case storage.NotFound, storage.DBError:
+		return err
+	}
+
+	return storage.DBError{Message: err.Error()}
+}
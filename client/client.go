@@ -0,0 +1,271 @@
+// Package client calls the API of a storage.Service, decoding the data of the resources into the types of their entities.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// Client calls the API of a service serving the given entities.
+type Client struct {
+	// URL is the base URL of the service, e.g. "https://example.com/api".
+	URL string
+	// HTTPClient sends the requests, http.DefaultClient if it is nil.
+	HTTPClient *http.Client
+	// Header is sent with every request, e.g. to authenticate.
+	Header http.Header
+
+	entities map[string]storage.Entity
+}
+
+// Error is an error response which is none of the errors of the storage, e.g. because of malformed JSON.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// New creates a client of the service at url which has to serve the entities.
+func New(url string, entities []storage.Entity) (*Client, error) {
+	validatedEntities, err := storage.NewEntities(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	entitiesByName := map[string]storage.Entity{}
+	for _, entity := range validatedEntities.All() {
+		entitiesByName[entity.Name] = entity
+	}
+
+	return &Client{URL: strings.TrimSuffix(url, "/"), Header: http.Header{}, entities: entitiesByName}, nil
+}
+
+// Entity returns the client of the resources of the entity.
+func (c *Client) Entity(entityName string) (EntityClient, error) {
+	entity, ok := c.entities[entityName]
+	if !ok {
+		return EntityClient{}, storage.UndefinedEntity{Entity: entityName}
+	}
+
+	return EntityClient{client: c, entity: entity}, nil
+}
+
+// EntityClient reads and writes the resources of an entity.
+// The data of the resources it returns are pointers to values of the data type of the entity.
+type EntityClient struct {
+	client *Client
+	entity storage.Entity
+}
+
+func (e EntityClient) Create(resource storage.CollapsedResource) (storage.CollapsedResource, error) {
+	created := e.entity.New().Collapse()
+	err := e.client.do(http.MethodPost, e.path(), resource, &created)
+	if err != nil {
+		return storage.CollapsedResource{}, err
+	}
+
+	return created, nil
+}
+
+func (e EntityClient) Read(id string) (storage.CollapsedResource, error) {
+	resource := e.entity.New().Collapse()
+	err := e.client.do(http.MethodGet, e.path(id), nil, &resource)
+	if err != nil {
+		return storage.CollapsedResource{}, err
+	}
+
+	return resource, nil
+}
+
+// Update replaces the resource with the ID of the given one.
+func (e EntityClient) Update(resource storage.CollapsedResource) (storage.CollapsedResource, error) {
+	updated := e.entity.New().Collapse()
+	err := e.client.do(http.MethodPut, e.path(resource.ID), resource, &updated)
+	if err != nil {
+		return storage.CollapsedResource{}, err
+	}
+
+	return updated, nil
+}
+
+// Delete purges the resource.
+func (e EntityClient) Delete(id string) error {
+	return e.client.do(http.MethodDelete, e.path(id), nil, nil)
+}
+
+func (e EntityClient) List() ([]storage.CollapsedResource, error) {
+	documents := []json.RawMessage{}
+	err := e.client.do(http.MethodGet, e.path(), nil, &documents)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]storage.CollapsedResource, len(documents))
+	for i, document := range documents {
+		resources[i] = e.entity.New().Collapse()
+		err = json.Unmarshal(document, &resources[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resources, nil
+}
+
+// Expand reads the resource together with the resources it references.
+func (e EntityClient) Expand(id string) (storage.Resource, error) {
+	document := json.RawMessage{}
+	err := e.client.do(http.MethodGet, e.path(storage.ActionExpand, id), nil, &document)
+	if err != nil {
+		return storage.Resource{}, err
+	}
+
+	return decodeResource(e.entity, document)
+}
+
+// ReferencedBy returns the IDs of the resources referencing the resource by entity and relation.
+func (e EntityClient) ReferencedBy(id string) (map[string]map[string][]string, error) {
+	referencedBy := map[string]map[string][]string{}
+	err := e.client.do(http.MethodGet, e.path(storage.ActionReferencedBy, id), nil, &referencedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	return referencedBy, nil
+}
+
+// path escapes the entity name and the segments, which may be IDs containing any character.
+func (e EntityClient) path(segments ...string) string {
+	escaped := make([]string, len(segments)+1)
+	escaped[0] = url.PathEscape(e.entity.Name)
+	for i, segment := range segments {
+		escaped[i+1] = url.PathEscape(segment)
+	}
+
+	return "/" + strings.Join(escaped, "/")
+}
+
+// decodeResource decodes an expanded resource, whose references are expanded resources of the referenced entities.
+func decodeResource(entity storage.Entity, document json.RawMessage) (storage.Resource, error) {
+	expanded := struct {
+		ID         string                       `json:"id"`
+		Data       json.RawMessage              `json:"data"`
+		References map[string][]json.RawMessage `json:"references"`
+		Meta       storage.Metadata             `json:"meta"`
+	}{}
+	err := json.Unmarshal(document, &expanded)
+	if err != nil {
+		return storage.Resource{}, err
+	}
+
+	resource := entity.New()
+	resource.ID = expanded.ID
+	resource.Meta = expanded.Meta
+
+	if len(expanded.Data) != 0 {
+		err = json.Unmarshal(expanded.Data, resource.Data)
+		if err != nil {
+			return storage.Resource{}, err
+		}
+	}
+
+	for relationName, documents := range expanded.References {
+		reference, ok := entity.References[relationName]
+		if !ok {
+			return storage.Resource{}, fmt.Errorf("%q has no relation %q", entity.Name, relationName)
+		}
+
+		references := make([]storage.Resource, len(documents))
+		for i, document := range documents {
+			references[i], err = decodeResource(reference, document)
+			if err != nil {
+				return storage.Resource{}, err
+			}
+		}
+
+		resource.References[relationName] = references
+	}
+
+	return resource, nil
+}
+
+// do sends the request and decodes the response into out unless it is nil.
+// Error responses are returned as the errors of the storage they describe.
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(content)
+	}
+
+	request, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+
+	for name, values := range c.Header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Accept", "application/json")
+	if in != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 400 {
+		return parseError(response.StatusCode, content)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(content, out)
+}
+
+func parseError(statusCode int, content []byte) error {
+	response := struct {
+		Error   string          `json:"error"`
+		Type    string          `json:"type"`
+		Details json.RawMessage `json:"details"`
+	}{}
+	if json.Unmarshal(content, &response) != nil || response.Error == "" {
+		return Error{StatusCode: statusCode, Message: http.StatusText(statusCode)}
+	}
+
+	if err, ok := storage.ParseError(response.Type, response.Details); ok {
+		return err
+	}
+
+	return Error{StatusCode: statusCode, Message: response.Error}
+}
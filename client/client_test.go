@@ -0,0 +1,178 @@
+package client_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/bolt"
+	"github.com/DanShu93/jsonmancer/client"
+	"github.com/DanShu93/jsonmancer/id"
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+type author struct {
+	Name string `json:"name"`
+}
+
+type article struct {
+	Title string `json:"title"`
+}
+
+var authorEntity = storage.Entity{Name: "author", Data: reflect.TypeOf(author{})}
+
+var articleEntity = storage.Entity{
+	Name:          "article",
+	Data:          reflect.TypeOf(article{}),
+	References:    map[string]storage.Entity{"authors": authorEntity},
+	Cardinalities: map[string]storage.Cardinality{"authors": {Min: 1}},
+}
+
+var entities = []storage.Entity{authorEntity, articleEntity}
+
+func setUp(t *testing.T) (authors, articles client.EntityClient, tearDown func()) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := bolt.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := storage.New(entities, repository, id.UUIDv4{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(storage.Service{Storage: s})
+
+	c, err := client.New(server.URL, entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authors, err = c.Entity("author")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	articles, err = c.Entity("article")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authors, articles, func() {
+		server.Close()
+		repository.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClient(t *testing.T) {
+	authors, articles, tearDown := setUp(t)
+	defer tearDown()
+
+	created, err := authors.Create(storage.CollapsedResource{Data: author{Name: "Ada"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Meta.CreatedAt.IsZero() || *created.Data.(*author) != (author{Name: "Ada"}) {
+		t.Fatalf("unexpected created resource %+v", created)
+	}
+
+	read, err := authors.Read(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *read.Data.(*author) != (author{Name: "Ada"}) {
+		t.Errorf("unexpected data %+v", read.Data)
+	}
+
+	created.Data = author{Name: "Ada Lovelace"}
+	updated, err := authors.Update(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.Data.(*author) != (author{Name: "Ada Lovelace"}) {
+		t.Errorf("unexpected updated data %+v", updated.Data)
+	}
+
+	reference, err := articles.Create(storage.CollapsedResource{
+		Data:       article{Title: "Notes"},
+		References: map[string][]string{"authors": {created.ID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := articles.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || *list[0].Data.(*article) != (article{Title: "Notes"}) {
+		t.Errorf("unexpected list %+v", list)
+	}
+
+	expanded, err := articles.Expand(reference.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expandedAuthors := expanded.References["authors"]
+	if len(expandedAuthors) != 1 || expandedAuthors[0].ID != created.ID || *expandedAuthors[0].Data.(*author) != (author{Name: "Ada Lovelace"}) {
+		t.Errorf("unexpected expanded resource %+v", expanded)
+	}
+
+	referencedBy, err := authors.ReferencedBy(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(referencedBy, map[string]map[string][]string{"article": {"authors": {reference.ID}}}) {
+		t.Errorf("unexpected references %v", referencedBy)
+	}
+
+	err = articles.Delete(reference.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = articles.Read(reference.ID)
+	if err != (storage.NotFound{Entity: "article", ID: reference.ID}) {
+		t.Errorf("expected not found, got %#v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	_, articles, tearDown := setUp(t)
+	defer tearDown()
+
+	_, err := articles.Create(storage.CollapsedResource{
+		Data:       article{Title: "Notes"},
+		References: map[string][]string{"authors": {"missing"}},
+	})
+	expected := storage.DanglingReferences{Entity: "article", Relations: map[string][]string{"authors": {"missing"}}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("expected %#v, got %#v", expected, err)
+	}
+
+	_, err = articles.Create(storage.CollapsedResource{Data: article{Title: "Notes"}})
+	if _, ok := err.(storage.CardinalityViolation); !ok {
+		t.Errorf("expected a cardinality violation, got %#v", err)
+	}
+
+	err = articles.Delete("missing")
+	if err != (storage.NotFound{Entity: "article", ID: "missing"}) {
+		t.Errorf("expected not found, got %#v", err)
+	}
+
+	for _, id := range []string{"x?y=z", "a b#c", "100%", "expand"} {
+		_, err = articles.Read(id)
+		if err != (storage.NotFound{Entity: "article", ID: id}) {
+			t.Errorf("expected %q not to be found, got %#v", id, err)
+		}
+	}
+}
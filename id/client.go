@@ -0,0 +1,54 @@
+package id
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// ClientSupplied uses the IDs supplied by clients if they match the pattern and are not taken yet.
+// The pattern should be anchored with ^ and $ to restrict whole IDs.
+// Resources without an ID get one from Fallback or are rejected if there is none.
+type ClientSupplied struct {
+	Pattern  *regexp.Regexp
+	Fallback storage.IDGenerator
+
+	repository storage.Repository
+}
+
+func (g ClientSupplied) WithRepository(repository storage.Repository) storage.IDGenerator {
+	g.repository = repository
+	if bound, ok := g.Fallback.(storage.RepositoryIDGenerator); ok {
+		g.Fallback = bound.WithRepository(repository)
+	}
+
+	return g
+}
+
+func (g ClientSupplied) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	if resource.ID == "" {
+		if g.Fallback == nil {
+			return "", storage.InvalidInput{Message: fmt.Sprintf("resources of %q need an id", entity.Name)}
+		}
+
+		return g.Fallback.Generate(entity, resource)
+	}
+
+	if g.Pattern != nil && !g.Pattern.MatchString(resource.ID) {
+		return "", storage.InvalidInput{Message: fmt.Sprintf("id %q does not match %q", resource.ID, g.Pattern)}
+	}
+
+	if g.repository != nil {
+		existing := map[string]interface{}{}
+		err := g.repository.Read(entity.Name, resource.ID, &existing)
+		if err == nil {
+			return "", storage.InvalidInput{Message: fmt.Sprintf("id %q is taken", resource.ID)}
+		}
+		if _, ok := err.(storage.NotFound); !ok {
+			return "", err
+		}
+	}
+
+	return resource.ID, nil
+}
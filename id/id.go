@@ -0,0 +1,55 @@
+// Package id provides strategies for generating the IDs of resources,
+// which can be chosen per entity with storage.Entity.IDGenerator.
+package id
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"github.com/DanShu93/jsonmancer/uuid"
+)
+
+// UUIDv4 generates random UUIDs.
+type UUIDv4 struct {
+}
+
+func (g UUIDv4) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	return uuid.V4{}.Generate()
+}
+
+// UUIDv7 generates UUIDs which sort by their creation time.
+type UUIDv7 struct {
+}
+
+func (g UUIDv7) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	return uuid.V7{}.Generate()
+}
+
+// timestamped returns the timestamp followed by random bytes.
+func timestamped(timestamp []byte, size int) ([]byte, error) {
+	b := make([]byte, size)
+	copy(b, timestamp)
+
+	_, err := rand.Read(b[len(timestamp):])
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// encode encodes the bytes as a big-endian number with the given number of digits of the alphabet.
+func encode(b []byte, alphabet string, digits int) string {
+	value := new(big.Int).SetBytes(b)
+	base := big.NewInt(int64(len(alphabet)))
+	digit := new(big.Int)
+
+	result := make([]byte, digits)
+	for i := digits - 1; i >= 0; i-- {
+		value.DivMod(value, base, digit)
+		result[i] = alphabet[digit.Int64()]
+	}
+
+	return string(result)
+}
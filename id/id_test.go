@@ -0,0 +1,183 @@
+package id
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/bolt"
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+type data struct {
+	Name string `json:"name"`
+}
+
+var entity = storage.Entity{Name: "order", Data: reflect.TypeOf(data{})}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator storage.IDGenerator
+		pattern   string
+	}{
+		{"UUIDv4", UUIDv4{}, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$"},
+		{"UUIDv7", UUIDv7{}, "^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$"},
+		{"ULID", ULID{}, "^[0-7][0-9A-HJKMNP-TV-Z]{25}$"},
+		{"KSUID", KSUID{}, "^[0-9A-Za-z]{27}$"},
+		{"Prefixed", Prefixed{Prefix: "ord_", Generator: KSUID{}}, "^ord_[0-9A-Za-z]{27}$"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pattern := regexp.MustCompile(test.pattern)
+			seen := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				id, err := test.generator.Generate(entity, storage.CollapsedResource{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !pattern.MatchString(id) {
+					t.Fatalf("%q does not match %q", id, test.pattern)
+				}
+
+				if seen[id] {
+					t.Fatalf("%q was generated twice", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestTimeOrdered(t *testing.T) {
+	for _, generator := range []storage.IDGenerator{UUIDv7{}, ULID{}} {
+		first, _ := generator.Generate(entity, storage.CollapsedResource{})
+		second, _ := generator.Generate(entity, storage.CollapsedResource{})
+
+		// IDs of the same millisecond are not ordered.
+		if first[:8] > second[:8] {
+			t.Errorf("%T: %q was generated before %q", generator, first, second)
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+
+	generator := Sequence{Width: 3}.WithRepository(repository)
+
+	ids := []string{}
+	for _, e := range []storage.Entity{entity, entity, {Name: "invoice"}, entity} {
+		id, err := generator.Generate(e, storage.CollapsedResource{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	expected := []string{"001", "002", "001", "003"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+}
+
+func TestSequenceConcurrently(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+
+	generator := Sequence{}.WithRepository(repository)
+
+	ids := make(chan string, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id, err := generator.Generate(entity, storage.CollapsedResource{})
+			if err != nil {
+				t.Error(err)
+			}
+
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[string]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("generated %q twice", id)
+		}
+		seen[id] = true
+	}
+	if !seen[strconv.Itoa(cap(ids))] {
+		t.Errorf("expected the numbers up to %d, got %v", cap(ids), seen)
+	}
+}
+
+func TestClientSupplied(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+
+	err := repository.Create(entity.Name, storage.CollapsedResource{ID: "ord-1", Data: data{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generator := ClientSupplied{Pattern: regexp.MustCompile("^ord-[0-9]+$")}.WithRepository(repository)
+
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"ord-2", true},
+		{"ord-1", false},
+		{"ord-x", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		id, err := generator.Generate(entity, storage.CollapsedResource{ID: test.id})
+		if test.valid && (err != nil || id != test.id) {
+			t.Errorf("expected %q to be used, got %q and %v", test.id, id, err)
+		}
+
+		if _, ok := err.(storage.InvalidInput); !test.valid && !ok {
+			t.Errorf("expected %q to be rejected, got %#v", test.id, err)
+		}
+	}
+
+	fallback := ClientSupplied{Fallback: Sequence{}}.WithRepository(repository)
+	id, err := fallback.Generate(entity, storage.CollapsedResource{})
+	if err != nil || id != "1" {
+		t.Errorf("expected the fallback to generate %q, got %q and %v", "1", id, err)
+	}
+}
+
+func openRepository(t *testing.T) (storage.Repository, func()) {
+	dir, err := ioutil.TempDir("", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := bolt.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository, func() {
+		repository.Close()
+		os.RemoveAll(dir)
+	}
+}
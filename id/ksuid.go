@@ -0,0 +1,30 @@
+package id
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+const (
+	base62     = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	ksuidEpoch = 1400000000
+)
+
+// KSUID generates K-sortable unique identifiers:
+// 27 characters encoding the seconds since the KSUID epoch and 128 random bits.
+type KSUID struct {
+}
+
+func (g KSUID) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	timestamp := make([]byte, 4)
+	binary.BigEndian.PutUint32(timestamp, uint32(time.Now().Unix()-ksuidEpoch))
+
+	b, err := timestamped(timestamp, 20)
+	if err != nil {
+		return "", err
+	}
+
+	return encode(b, base62, 27), nil
+}
@@ -0,0 +1,28 @@
+package id
+
+import (
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// Prefixed prepends a prefix to the IDs of another generator, e.g. "ord_" for orders.
+type Prefixed struct {
+	Prefix    string
+	Generator storage.IDGenerator
+}
+
+func (g Prefixed) WithRepository(repository storage.Repository) storage.IDGenerator {
+	if bound, ok := g.Generator.(storage.RepositoryIDGenerator); ok {
+		g.Generator = bound.WithRepository(repository)
+	}
+
+	return g
+}
+
+func (g Prefixed) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	id, err := g.Generator.Generate(entity, resource)
+	if err != nil {
+		return "", err
+	}
+
+	return g.Prefix + id, nil
+}
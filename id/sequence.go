@@ -0,0 +1,57 @@
+package id
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// SequenceCollection holds the counters of the entities using a Sequence.
+const SequenceCollection = "sequences"
+
+// sequenceMutex serializes the counter updates of all sequences within this process.
+var sequenceMutex sync.Mutex
+
+type counter struct {
+	ID    string `json:"id" bson:"_id"`
+	Value int64  `json:"value" bson:"value"`
+}
+
+// Sequence numbers the resources of every entity consecutively starting at 1.
+// The counters are kept in the repository, which storages replace by their own.
+// Numbers are unique as long as only a single process writes to the repository and the transactions
+// generating them don't overlap, as a counter updated within a transaction is only seen by others once it is
+// committed. Bolt serializes its transactions, others like SQL databases may not.
+type Sequence struct {
+	Repository storage.Repository
+	// Width pads the numbers with leading zeros so that they sort as strings.
+	Width int
+}
+
+func (g Sequence) WithRepository(repository storage.Repository) storage.IDGenerator {
+	g.Repository = repository
+
+	return g
+}
+
+func (g Sequence) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	sequenceMutex.Lock()
+	defer sequenceMutex.Unlock()
+
+	current := counter{}
+	err := g.Repository.Read(SequenceCollection, entity.Name, &current)
+	switch err.(type) {
+	case nil:
+		current.Value++
+		err = g.Repository.Update(SequenceCollection, entity.Name, current)
+	case storage.NotFound:
+		current = counter{ID: entity.Name, Value: 1}
+		err = g.Repository.Create(SequenceCollection, current)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", g.Width, current.Value), nil
+}
@@ -0,0 +1,31 @@
+package id
+
+import (
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates universally unique lexicographically sortable identifiers:
+// 26 characters encoding the Unix time in milliseconds and 80 random bits.
+// IDs created within the same millisecond are not ordered.
+type ULID struct {
+}
+
+func (g ULID) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	timestamp := make([]byte, 6)
+	for i := range timestamp {
+		timestamp[i] = byte(ms >> uint(40-8*i))
+	}
+
+	b, err := timestamped(timestamp, 16)
+	if err != nil {
+		return "", err
+	}
+
+	return encode(b, crockfordBase32, 26), nil
+}
@@ -31,6 +31,19 @@ func (s Repository) Create(collectionName string, data interface{}) error {
 	return nil
 }
 
+func (s Repository) CreateAll(collectionName string, data []interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	err := s.database.C(collectionName).Insert(data...)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
 func (s Repository) Read(collectionName, id string, result interface{}) error {
 	q := s.database.C(collectionName).Find(bson.M{"_id": id})
 
@@ -53,6 +66,9 @@ func (s Repository) Read(collectionName, id string, result interface{}) error {
 
 func (s Repository) Update(collectionName, id string, data interface{}) error {
 	err := s.database.C(collectionName).Update(bson.M{"_id": id}, data)
+	if err == mgo.ErrNotFound {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
 	if err != nil {
 		return storage.DBError{Message: err.Error()}
 	}
@@ -62,6 +78,9 @@ func (s Repository) Update(collectionName, id string, data interface{}) error {
 
 func (s Repository) Delete(collectionName, id string) error {
 	err := s.database.C(collectionName).Remove(bson.M{"_id": id})
+	if err == mgo.ErrNotFound {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
 	if err != nil {
 		return storage.DBError{Message: err.Error()}
 	}
@@ -72,6 +91,9 @@ func (s Repository) Delete(collectionName, id string) error {
 func (s Repository) ReadAll(collectionName string, query storage.Query, result interface{}) error {
 	mq := createMongoQuery(query)
 	q := s.database.C(collectionName).Find(mq)
+	if len(query.Sort) != 0 {
+		q = q.Sort(createMongoSort(query.Sort)...)
+	}
 
 	err := q.All(result)
 	if err != nil {
@@ -114,3 +136,19 @@ func createMongoQuery(q storage.Query) bson.M {
 
 	return mq
 }
+
+func createMongoSort(fields []string) []string {
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		switch field {
+		case "ID":
+			field = "_id"
+		case "-ID":
+			field = "-_id"
+		}
+
+		result[i] = field
+	}
+
+	return result
+}
@@ -0,0 +1,36 @@
+package mongo
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"github.com/DanShu93/jsonmancer/storage/storagetest"
+	"gopkg.in/mgo.v2"
+)
+
+// TestConformance runs against the mongod at JSONMANCER_MONGO_URL or localhost and is skipped if none is reachable.
+func TestConformance(t *testing.T) {
+	url := os.Getenv("JSONMANCER_MONGO_URL")
+	if url == "" {
+		url = "localhost"
+	}
+
+	session, err := mgo.DialWithTimeout(url, time.Second)
+	if err != nil {
+		t.Skipf("mongod at %q is not reachable: %s", url, err)
+	}
+	defer session.Close()
+
+	n := 0
+	storagetest.Run(t, func(t *testing.T) (storage.Repository, func()) {
+		n++
+		database := session.DB(fmt.Sprintf("jsonmancer_test_%d_%d", os.Getpid(), n))
+
+		return Repository{database: database}, func() {
+			database.DropDatabase()
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dialect hides the differences between the SQL databases supported by the repository.
+type Dialect interface {
+	// CreateTable returns the statement creating the table of a collection if it does not exist yet.
+	CreateTable(table string) string
+	// Placeholder returns the bind parameter for the n-th argument of a statement, starting at 1.
+	Placeholder(n int) string
+	// Match returns a condition which is true if the JSON value at path equals the bound value
+	// or is an array containing it.
+	Match(path []string, placeholder string) string
+	// Bind converts a query value into a statement argument for Match.
+	Bind(value interface{}) (interface{}, error)
+	// Extract returns the expression of the JSON value at path which results are ordered by.
+	Extract(path []string) string
+}
+
+// SQLite stores documents as JSON text and queries them with the JSON1 functions.
+var SQLite Dialect = sqlite{}
+
+// PostgreSQL stores documents as JSONB.
+var PostgreSQL Dialect = postgreSQL{}
+
+type sqlite struct {
+}
+
+func (d sqlite) CreateTable(table string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, document TEXT NOT NULL)", quoteIdentifier(table))
+}
+
+func (d sqlite) Placeholder(n int) string {
+	return "?"
+}
+
+func (d sqlite) Match(path []string, placeholder string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(document, '%s') WHERE json_each.value = %s)", sqliteJSONPath(path), placeholder)
+}
+
+func (d sqlite) Bind(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return value, nil
+	case time.Time:
+		// The JSON encoding of the stored timestamps.
+		return value.(time.Time).Format(time.RFC3339Nano), nil
+	}
+
+	return nil, fmt.Errorf("unsupported query value %#v", value)
+}
+
+func (d sqlite) Extract(path []string) string {
+	return fmt.Sprintf("json_extract(document, '%s')", sqliteJSONPath(path))
+}
+
+// sqliteJSONPath quotes every segment of the path, since keys like "a-b" are not valid unquoted.
+func sqliteJSONPath(path []string) string {
+	return `$."` + strings.Join(path, `"."`) + `"`
+}
+
+type postgreSQL struct {
+}
+
+func (d postgreSQL) CreateTable(table string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, document JSONB NOT NULL)", quoteIdentifier(table))
+}
+
+func (d postgreSQL) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (d postgreSQL) Match(path []string, placeholder string) string {
+	return fmt.Sprintf("document #> '{%s}' @> %s::jsonb", strings.Join(path, ","), placeholder)
+}
+
+func (d postgreSQL) Bind(value interface{}) (interface{}, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(content), nil
+}
+
+func (d postgreSQL) Extract(path []string) string {
+	return fmt.Sprintf("document #> '{%s}'", strings.Join(path, ","))
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
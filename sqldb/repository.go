@@ -0,0 +1,426 @@
+package sqldb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+var pathSegmentRegex = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// Repository stores every collection in its own table holding the JSON encoded documents.
+// Documents are identified by their "id" property.
+type Repository struct {
+	db      *sql.DB
+	dialect Dialect
+	tables  *tables
+	// tx is set for repositories acting within a transaction.
+	tx *sql.Tx
+}
+
+// executor is implemented by both sql.DB and sql.Tx.
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type tables struct {
+	sync.Mutex
+	created map[string]bool
+}
+
+func Open(driverName, dataSourceName string, dialect Dialect, entities storage.Entities) (Repository, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return Repository{}, storage.DBError{Message: err.Error()}
+	}
+
+	return New(db, dialect, entities)
+}
+
+// New creates the tables of all entities which do not exist yet.
+// Tables of other collections are created on first use.
+func New(db *sql.DB, dialect Dialect, entities storage.Entities) (Repository, error) {
+	r := Repository{
+		db:      db,
+		dialect: dialect,
+		tables:  &tables{created: map[string]bool{}},
+	}
+
+	for _, entity := range entities.All() {
+		err := r.ensureTable(entity.Name)
+		if err != nil {
+			return Repository{}, err
+		}
+	}
+
+	return r, nil
+}
+
+// JSONEncoded marks the repository as storing the JSON encoding of documents.
+func (s Repository) JSONEncoded() {}
+
+func (s Repository) Create(collectionName string, data interface{}) error {
+	err := s.ensureTable(collectionName)
+	if err != nil {
+		return err
+	}
+
+	id, document, err := encode(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.executor().Exec(s.insertStatement(collectionName), id, document)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+// CreateAll inserts all documents in a single transaction.
+func (s Repository) CreateAll(collectionName string, data []interface{}) error {
+	err := s.ensureTable(collectionName)
+	if err != nil {
+		return err
+	}
+
+	statement := s.insertStatement(collectionName)
+
+	return s.transaction(func(tx Repository) error {
+		for _, v := range data {
+			id, document, err := encode(v)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.tx.Exec(statement, id, document)
+			if err != nil {
+				return storage.DBError{Message: err.Error()}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s Repository) Read(collectionName, id string, result interface{}) error {
+	err := s.ensureTable(collectionName)
+	if err != nil {
+		return err
+	}
+
+	statement := fmt.Sprintf("SELECT document FROM %s WHERE id = %s", quoteIdentifier(collectionName), s.dialect.Placeholder(1))
+
+	var document []byte
+	err = s.executor().QueryRow(statement, id).Scan(&document)
+	if err == sql.ErrNoRows {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	err = json.Unmarshal(document, result)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s Repository) Update(collectionName, id string, data interface{}) error {
+	err := s.ensureTable(collectionName)
+	if err != nil {
+		return err
+	}
+
+	_, document, err := encode(data)
+	if err != nil {
+		return err
+	}
+
+	statement := fmt.Sprintf("UPDATE %s SET document = %s WHERE id = %s", quoteIdentifier(collectionName), s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+
+	return s.expectRow(collectionName, id, statement, document, id)
+}
+
+func (s Repository) Delete(collectionName, id string) error {
+	err := s.ensureTable(collectionName)
+	if err != nil {
+		return err
+	}
+
+	statement := fmt.Sprintf("DELETE FROM %s WHERE id = %s", quoteIdentifier(collectionName), s.dialect.Placeholder(1))
+
+	return s.expectRow(collectionName, id, statement, id)
+}
+
+func (s Repository) ReadAll(collectionName string, query storage.Query, result interface{}) error {
+	err := s.ensureTable(collectionName)
+	if err != nil {
+		return err
+	}
+
+	where, args, err := s.createWhereClause(query)
+	if err != nil {
+		return err
+	}
+
+	order, err := s.createOrderClause(query.Sort)
+	if err != nil {
+		return err
+	}
+
+	statement := fmt.Sprintf("SELECT document FROM %s%s%s", quoteIdentifier(collectionName), where, order)
+
+	rows, err := s.executor().Query(statement, args...)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+	defer rows.Close()
+
+	documents := [][]byte{}
+	for rows.Next() {
+		var document []byte
+		err = rows.Scan(&document)
+		if err != nil {
+			return storage.DBError{Message: err.Error()}
+		}
+
+		documents = append(documents, document)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	content := append(append([]byte("["), bytes.Join(documents, []byte(","))...), ']')
+	err = json.Unmarshal(content, result)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+// Transaction runs fn with a repository acting within a single database transaction.
+func (s Repository) Transaction(fn func(storage.Repository) error) error {
+	return s.transaction(func(tx Repository) error {
+		return fn(tx)
+	})
+}
+
+// transaction joins the current transaction if there is one.
+func (s Repository) transaction(fn func(tx Repository) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	r := s
+	r.tx = tx
+
+	err = fn(r)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s Repository) executor() executor {
+	if s.tx != nil {
+		return s.tx
+	}
+
+	return s.db
+}
+
+func (s Repository) insertStatement(collectionName string) string {
+	return fmt.Sprintf("INSERT INTO %s (id, document) VALUES (%s, %s)", quoteIdentifier(collectionName), s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+}
+
+func (s Repository) expectRow(collectionName, id, statement string, args ...interface{}) error {
+	res, err := s.executor().Exec(statement, args...)
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	if n == 0 {
+		return storage.NotFound{Entity: collectionName, ID: id}
+	}
+
+	return nil
+}
+
+func (s Repository) ensureTable(collectionName string) error {
+	s.tables.Lock()
+	defer s.tables.Unlock()
+
+	if s.tables.created[collectionName] {
+		return nil
+	}
+
+	_, err := s.executor().Exec(s.dialect.CreateTable(collectionName))
+	if err != nil {
+		return storage.DBError{Message: err.Error()}
+	}
+
+	// A table created within a transaction is gone if it's rolled back.
+	if s.tx == nil {
+		s.tables.created[collectionName] = true
+	}
+
+	return nil
+}
+
+func (s Repository) createWhereClause(q storage.Query) (string, []interface{}, error) {
+	args := []interface{}{}
+	or := []string{}
+	and := []string{}
+
+	for k, v := range q.Q {
+		conditions := make([]string, len(v.Values))
+		for i, currentValue := range v.Values {
+			condition, arg, err := s.createCondition(k, currentValue, len(args)+1)
+			if err != nil {
+				return "", nil, err
+			}
+
+			conditions[i] = condition
+			args = append(args, arg)
+		}
+
+		if len(conditions) == 0 {
+			continue
+		}
+
+		switch v.Kind {
+		case storage.QueryAnd:
+			and = append(and, conditions...)
+		case storage.QueryOr:
+			or = append(or, conditions...)
+		case storage.QueryContains:
+			and = append(and, "("+strings.Join(conditions, " OR ")+")")
+		}
+	}
+
+	if len(or) != 0 {
+		and = append(and, "("+strings.Join(or, " OR ")+")")
+	}
+
+	if len(and) == 0 {
+		return "", args, nil
+	}
+
+	return " WHERE " + strings.Join(and, " AND "), args, nil
+}
+
+// createOrderClause orders by the fields and then by ID, so that the order of the result is always the same.
+func (s Repository) createOrderClause(fields []string) (string, error) {
+	if len(fields) == 0 {
+		return " ORDER BY id", nil
+	}
+
+	expressions := make([]string, len(fields), len(fields)+1)
+	orderedByID := false
+	for i, field := range fields {
+		direction := " ASC"
+		if strings.HasPrefix(field, "-") {
+			direction = " DESC"
+			field = strings.TrimPrefix(field, "-")
+		}
+
+		if field == "ID" || field == "id" {
+			expressions[i] = "id" + direction
+			orderedByID = true
+			continue
+		}
+
+		path, err := splitPath(field)
+		if err != nil {
+			return "", err
+		}
+
+		expressions[i] = s.dialect.Extract(path) + direction
+	}
+
+	if !orderedByID {
+		expressions = append(expressions, "id")
+	}
+
+	return " ORDER BY " + strings.Join(expressions, ", "), nil
+}
+
+func (s Repository) createCondition(key string, value interface{}, n int) (string, interface{}, error) {
+	placeholder := s.dialect.Placeholder(n)
+
+	if key == "ID" || key == "id" {
+		return "id = " + placeholder, value, nil
+	}
+
+	path, err := splitPath(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	arg, err := s.dialect.Bind(value)
+	if err != nil {
+		return "", nil, storage.DBError{Message: err.Error()}
+	}
+
+	return s.dialect.Match(path, placeholder), arg, nil
+}
+
+func splitPath(field string) ([]string, error) {
+	path := strings.Split(field, ".")
+	for _, segment := range path {
+		if !pathSegmentRegex.MatchString(segment) {
+			return nil, storage.DBError{Message: fmt.Sprintf("unsupported query field %q", field)}
+		}
+	}
+
+	return path, nil
+}
+
+func encode(data interface{}) (string, []byte, error) {
+	document, err := json.Marshal(data)
+	if err != nil {
+		return "", nil, storage.DBError{Message: err.Error()}
+	}
+
+	identified := struct {
+		ID string `json:"id"`
+	}{}
+	err = json.Unmarshal(document, &identified)
+	if err != nil {
+		return "", nil, storage.DBError{Message: err.Error()}
+	}
+
+	return identified.ID, document, nil
+}
@@ -0,0 +1,232 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"github.com/DanShu93/jsonmancer/storage/storagetest"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type fixtureData struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+var fixtureEntities = []storage.Entity{
+	{Name: "article", Data: reflect.TypeOf(fixtureData{}), References: map[string]storage.Entity{"author": {Name: "author", Data: reflect.TypeOf(fixtureData{})}}},
+	{Name: "author", Data: reflect.TypeOf(fixtureData{})},
+}
+
+func newSQLiteRepository(t *testing.T) Repository {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	entities, err := storage.NewEntities(fixtureEntities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := New(db, SQLite, entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func TestReadAll(t *testing.T) {
+	repository := newSQLiteRepository(t)
+
+	fixtures := []storage.CollapsedResource{
+		{ID: "1", Data: fixtureData{Name: "a", Count: 1, Tags: []string{"x"}}, References: map[string][]string{"author": {"10", "11"}}},
+		{ID: "2", Data: fixtureData{Name: "b", Count: 2, Tags: []string{"y"}}, References: map[string][]string{"author": {"11"}}},
+		{ID: "3", Data: fixtureData{Name: "c", Count: 3}, References: map[string][]string{"author": {}}},
+	}
+	for _, fixture := range fixtures {
+		err := repository.Create("article", fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    storage.Query
+		expected []string
+	}{
+		{"empty", storage.Query{}, []string{"1", "2", "3"}},
+		{"id", storage.Query{Q: map[string]storage.FieldQuery{"ID": {Kind: storage.QueryAnd, Values: []interface{}{"2"}}}}, []string{"2"}},
+		{"and", storage.Query{Q: map[string]storage.FieldQuery{"data.Name": {Kind: storage.QueryAnd, Values: []interface{}{"a"}}, "data.Count": {Kind: storage.QueryAnd, Values: []interface{}{1}}}}, []string{"1"}},
+		{"or", storage.Query{Q: map[string]storage.FieldQuery{"data.Name": {Kind: storage.QueryOr, Values: []interface{}{"a", "c"}}}}, []string{"1", "3"}},
+		{"contains reference", storage.Query{Q: map[string]storage.FieldQuery{"references.author": {Kind: storage.QueryContains, Values: []interface{}{"11"}}}}, []string{"1", "2"}},
+		{"contains scalar", storage.Query{Q: map[string]storage.FieldQuery{"data.Count": {Kind: storage.QueryContains, Values: []interface{}{2, 3}}}}, []string{"2", "3"}},
+		{"equal array element", storage.Query{Q: map[string]storage.FieldQuery{"data.Tags": {Kind: storage.QueryAnd, Values: []interface{}{"y"}}}}, []string{"2"}},
+	}
+
+	for _, test := range tests {
+		result := []storage.CollapsedResource{}
+		err := repository.ReadAll("article", test.query, &result)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		ids := []string{}
+		for _, r := range result {
+			ids = append(ids, r.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ids)
+		}
+	}
+}
+
+func TestReadAllOrder(t *testing.T) {
+	repository := newSQLiteRepository(t)
+
+	for _, fixture := range []storage.CollapsedResource{
+		{ID: "2", Data: fixtureData{Name: "b", Count: 1}},
+		{ID: "3", Data: fixtureData{Name: "c", Count: 2}},
+		{ID: "1", Data: fixtureData{Name: "a", Count: 1}},
+	} {
+		err := repository.Create("article", fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		sort     []string
+		expected []string
+	}{
+		{"unsorted", nil, []string{"1", "2", "3"}},
+		{"id descending", []string{"-ID"}, []string{"3", "2", "1"}},
+		{"field", []string{"data.Name"}, []string{"1", "2", "3"}},
+		{"field descending", []string{"-data.Count"}, []string{"3", "1", "2"}},
+		{"field and id descending", []string{"data.Count", "-id"}, []string{"2", "1", "3"}},
+	}
+
+	for _, test := range tests {
+		result := []storage.CollapsedResource{}
+		err := repository.ReadAll("article", storage.Query{Sort: test.sort}, &result)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		ids := []string{}
+		for _, r := range result {
+			ids = append(ids, r.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ids)
+		}
+	}
+}
+
+func TestSQLitePath(t *testing.T) {
+	path := []string{"data", "short-label"}
+
+	expected := `json_extract(document, '$."data"."short-label"')`
+	if extract := SQLite.Extract(path); extract != expected {
+		t.Errorf("expected %s, got %s", expected, extract)
+	}
+
+	expected = `EXISTS (SELECT 1 FROM json_each(document, '$."data"."short-label"') WHERE json_each.value = ?)`
+	if match := SQLite.Match(path, "?"); match != expected {
+		t.Errorf("expected %s, got %s", expected, match)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.Repository, func()) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+
+		entities, err := storage.NewEntities(storagetest.Entities)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		repository, err := New(db, SQLite, entities)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return repository, func() {
+			db.Close()
+		}
+	})
+}
+
+// TestPostgreSQLConformance runs against the database at JSONMANCER_POSTGRES_URL and is skipped without one.
+// Every test uses its own schema, which is dropped afterwards.
+func TestPostgreSQLConformance(t *testing.T) {
+	url := os.Getenv("JSONMANCER_POSTGRES_URL")
+	if url == "" {
+		t.Skip("JSONMANCER_POSTGRES_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	err = admin.Ping()
+	if err != nil {
+		t.Skipf("PostgreSQL at %q is not reachable: %s", url, err)
+	}
+
+	n := 0
+	storagetest.Run(t, func(t *testing.T) (storage.Repository, func()) {
+		n++
+		schema := fmt.Sprintf("jsonmancer_test_%d_%d", os.Getpid(), n)
+
+		_, err := admin.Exec("CREATE SCHEMA " + quoteIdentifier(schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A single connection keeps the search path for all statements.
+		db, err := sql.Open("postgres", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+
+		_, err = db.Exec("SET search_path TO " + quoteIdentifier(schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entities, err := storage.NewEntities(storagetest.Entities)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		repository, err := New(db, PostgreSQL, entities)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return repository, func() {
+			db.Close()
+			admin.Exec("DROP SCHEMA " + quoteIdentifier(schema) + " CASCADE")
+		}
+	})
+}
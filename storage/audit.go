@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/uuid"
+)
+
+const OperationAudit Operation = "audit"
+
+// AuditCollection is the default collection of RepositoryAuditSink.
+const AuditCollection = "audit"
+
+// AuditRecord describes a single mutation of a resource.
+// Before is missing for creates and After for deletes and purges.
+type AuditRecord struct {
+	ID         string             `json:"id" bson:"_id"`
+	Time       time.Time          `json:"time" bson:"time"`
+	Principal  string             `json:"principal,omitempty" bson:"principal,omitempty"`
+	Entity     string             `json:"entity" bson:"entity"`
+	ResourceID string             `json:"resourceId" bson:"resourceId"`
+	Operation  Operation          `json:"operation" bson:"operation"`
+	Before     *CollapsedResource `json:"before,omitempty" bson:"before,omitempty"`
+	After      *CollapsedResource `json:"after,omitempty" bson:"after,omitempty"`
+	// Cause is the mutation which triggered this one, e.g. the purge removing a reference.
+	Cause *AuditCause `json:"cause,omitempty" bson:"cause,omitempty"`
+}
+
+type AuditCause struct {
+	Entity     string    `json:"entity" bson:"entity"`
+	ResourceID string    `json:"resourceId" bson:"resourceId"`
+	Operation  Operation `json:"operation" bson:"operation"`
+}
+
+// AuditSink stores audit records.
+type AuditSink interface {
+	Record(record AuditRecord) error
+	// Trail returns the records of a resource in chronological order.
+	Trail(entityName, id string) ([]AuditRecord, error)
+}
+
+// WithAuditSink records every mutation in the sink.
+// A RepositoryAuditSink storing the records in the repository of the storage writes them within the transaction
+// of the mutation. Other sinks record mutations once they are written and log their errors.
+func WithAuditSink(sink AuditSink) Option {
+	return func(s *Storage) {
+		s.auditSink = sink
+	}
+}
+
+// RepositoryAuditSink stores audit records in a collection of a repository.
+type RepositoryAuditSink struct {
+	Repository Repository
+	// Collection defaults to AuditCollection.
+	Collection string
+}
+
+func (s RepositoryAuditSink) Record(record AuditRecord) error {
+	return s.Repository.Create(s.collection(), record)
+}
+
+func (s RepositoryAuditSink) Trail(entityName, id string) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	err := s.Repository.ReadAll(s.collection(), s.trailQuery(entityName, id), &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortRecords(records), nil
+}
+
+// typedTrail is Trail with the data of the snapshots decoded into the data type of the entity.
+func (s RepositoryAuditSink) typedTrail(entity Entity, id string) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	err := readAllTyped(s.Repository, entity, s.collection(), s.trailQuery(entity.Name, id), &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortRecords(records), nil
+}
+
+func (s RepositoryAuditSink) trailQuery(entityName, id string) Query {
+	return Query{Q: map[string]FieldQuery{
+		"entity":     {Kind: QueryAnd, Values: []interface{}{entityName}},
+		"resourceId": {Kind: QueryAnd, Values: []interface{}{id}},
+	}}
+}
+
+// sortRecords orders the records chronologically.
+func sortRecords(records []AuditRecord) []AuditRecord {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	return records
+}
+
+func (s RepositoryAuditSink) collection() string {
+	if s.Collection == "" {
+		return AuditCollection
+	}
+
+	return s.Collection
+}
+
+// FileAuditSink appends audit records as JSON lines to a local file.
+type FileAuditSink struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{path: path, file: file}, nil
+}
+
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileAuditSink) Record(record AuditRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.file.Write(append(content, '\n'))
+
+	return err
+}
+
+// Trail scans the whole file.
+func (s *FileAuditSink) Trail(entityName, id string) ([]AuditRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []AuditRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		record := AuditRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, err
+		}
+
+		if record.Entity == entityName && record.ResourceID == id {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortRecords(records), nil
+}
+
+// AuditTrail returns the audit records of a resource, which may be deleted already.
+// The principal has to be allowed to audit its latest version.
+func (s *Storage) AuditTrail(entityName, id string) ([]AuditRecord, error) {
+	entity, ok := s.entities.entitiesByName[entityName]
+	if !ok {
+		return nil, UndefinedEntity{entityName}
+	}
+
+	if s.auditSink == nil {
+		return nil, NotFound{Entity: entityName, ID: id}
+	}
+
+	records, err := s.trail(entity, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, NotFound{Entity: entityName, ID: id}
+	}
+
+	latest := records[len(records)-1]
+	snapshot := latest.After
+	if snapshot == nil {
+		snapshot = latest.Before
+	}
+
+	err = s.authorize(entityName, OperationAudit, *snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		records[i].Before, err = s.hideSnapshot(entity, records[i].Before)
+		if err != nil {
+			return nil, err
+		}
+
+		records[i].After, err = s.hideSnapshot(entity, records[i].After)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// trail reads the audit records of a resource with the data of their snapshots typed like the data of the entity,
+// so that fields are authorized and hidden by their JSON names whatever encoding the sink uses.
+// Sinks other than a RepositoryAuditSink have to keep the JSON names of the data.
+func (s *Storage) trail(entity Entity, id string) ([]AuditRecord, error) {
+	if sink, ok := s.auditSink.(RepositoryAuditSink); ok {
+		return sink.typedTrail(entity, id)
+	}
+
+	records, err := s.auditSink.Trail(entity.Name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		for _, snapshot := range []*CollapsedResource{record.Before, record.After} {
+			if snapshot == nil {
+				continue
+			}
+
+			content, err := json.Marshal(snapshot.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			data := reflect.New(entity.Data).Interface()
+			err = json.Unmarshal(content, data)
+			if err != nil {
+				return nil, err
+			}
+
+			snapshot.Data = data
+			snapshot.entity = entity
+		}
+	}
+
+	return records, nil
+}
+
+func (s *Storage) hideSnapshot(entity Entity, snapshot *CollapsedResource) (*CollapsedResource, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	hidden, err := s.hideFields(entity, *snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hidden, nil
+}
+
+// audit records a mutation if there is an audit sink.
+func (s *Storage) audit(m mutation) error {
+	if s.auditSink == nil {
+		return nil
+	}
+
+	recordID, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+
+	return s.auditSink.Record(AuditRecord{
+		ID:         recordID.String(),
+		Time:       time.Now().UTC(),
+		Principal:  s.principal.ID,
+		Entity:     m.entityName,
+		ResourceID: m.id,
+		Operation:  m.operation,
+		Before:     m.before,
+		After:      m.after,
+		Cause:      m.cause,
+	})
+}
+
+// auditsInRepository tells whether the audit sink stores its records in the repository of the storage,
+// so that they can be written within its transactions.
+func (s *Storage) auditsInRepository() bool {
+	sink, ok := s.auditSink.(RepositoryAuditSink)
+	if !ok || sink.Repository == nil {
+		return false
+	}
+
+	sinkType := reflect.TypeOf(sink.Repository)
+	if sinkType != reflect.TypeOf(s.repository) || !sinkType.Comparable() {
+		return false
+	}
+
+	return sink.Repository == s.repository
+}
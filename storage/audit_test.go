@@ -0,0 +1,345 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+	"github.com/DanShu93/jsonmancer/uuid"
+)
+
+var auditedEntity = storage.Entity{
+	Name:   "item",
+	Data:   reflect.TypeOf(testData{}),
+	Fields: map[string]storage.FieldPermission{"notes": {ReadRoles: []string{"auditor"}}},
+}
+
+// auditSinks create the audit sinks to test, storing them in the repository of the storage if they need one.
+var auditSinks = map[string]func(t *testing.T, repository storage.Repository) (storage.AuditSink, func()){
+	"repository": func(t *testing.T, repository storage.Repository) (storage.AuditSink, func()) {
+		return storage.RepositoryAuditSink{Repository: repository}, func() {}
+	},
+	"file": func(t *testing.T, repository storage.Repository) (storage.AuditSink, func()) {
+		return openFileAuditSink(t)
+	},
+}
+
+// openFileAuditSink opens a file audit sink in a temporary directory, which the returned function removes.
+func openFileAuditSink(t *testing.T) (*storage.FileAuditSink, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := storage.NewFileAuditSink(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sink, func() {
+		sink.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	for name, newSink := range auditSinks {
+		repository, release := openRepository(t)
+		sink, closeSink := newSink(t, repository)
+
+		testAuditTrail(t, name, newStorageWith(t, repository, []storage.Entity{auditedEntity}, storage.WithAuditSink(sink)))
+
+		closeSink()
+		release()
+	}
+}
+
+func testAuditTrail(t *testing.T, sinkName string, s storage.Storage) {
+	s = s.As(storage.Principal{ID: "alice"})
+
+	created, err := s.CreateFromJSON("item", `{"data":{"name":"first","notes":"secret"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UpdateFromJSON("item", `{"id":"`+created.ID+`","data":{"name":"second"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := create(t, s, "item", "other")
+
+	err = s.Delete("item", created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.AuditTrail("item", created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := []storage.Operation{}
+	for _, record := range records {
+		if record.ResourceID != created.ID || record.Entity != "item" || record.Principal != "alice" {
+			t.Errorf("%s: unexpected record %+v", sinkName, record)
+		}
+		if uuid.Validate(record.ID) != nil || record.ID == created.ID {
+			t.Errorf("%s: record has no UUID of its own: %+v", sinkName, record)
+		}
+
+		operations = append(operations, record.Operation)
+	}
+
+	expected := []storage.Operation{storage.OperationCreate, storage.OperationUpdate, storage.OperationDelete}
+	if !reflect.DeepEqual(operations, expected) {
+		t.Fatalf("%s: expected operations %v, got %v", sinkName, expected, operations)
+	}
+
+	if records[0].Before != nil || records[0].After == nil || records[2].Before == nil || records[2].After != nil {
+		t.Errorf("%s: unexpected snapshots %+v", sinkName, records)
+	}
+
+	expectSnapshot(t, sinkName+": created", records[0].After, map[string]interface{}{"name": "first", "owner": ""})
+	expectSnapshot(t, sinkName+": updated", records[1].After, map[string]interface{}{"name": "second", "owner": ""})
+
+	auditor := s.As(storage.Principal{ID: "bob", Roles: []string{"auditor"}})
+	records, err = auditor.AuditTrail("item", created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSnapshot(t, sinkName+": created for auditors", records[0].After, map[string]interface{}{"name": "first", "owner": "", "notes": "secret"})
+
+	records, err = s.AuditTrail("item", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Operation != storage.OperationCreate {
+		t.Errorf("%s: expected the trail of the other item only, got %+v", sinkName, records)
+	}
+
+	_, err = s.AuditTrail("item", "missing")
+	if _, ok := err.(storage.NotFound); !ok {
+		t.Errorf("%s: expected not found, got %#v", sinkName, err)
+	}
+}
+
+// expectSnapshot compares the JSON encoding of the data of the snapshot with the expected document.
+func expectSnapshot(t *testing.T, name string, snapshot *storage.CollapsedResource, expected map[string]interface{}) {
+	if snapshot == nil {
+		t.Errorf("%s: missing snapshot", name)
+		return
+	}
+
+	content, err := json.Marshal(snapshot.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := map[string]interface{}{}
+	err = json.Unmarshal(content, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, actual)
+	}
+}
+
+func TestAuditRollback(t *testing.T) {
+	failing := auditedEntity
+	failing.Hooks.AfterUpdate = func(s *storage.Storage, stored, resource storage.CollapsedResource) error {
+		return errors.New("failed")
+	}
+
+	for name, newSink := range auditSinks {
+		repository, release := openRepository(t)
+		sink, closeSink := newSink(t, repository)
+		s := newStorageWith(t, repository, []storage.Entity{failing}, storage.WithAuditSink(sink))
+
+		id := create(t, s, "item", "first")
+		_, err := s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"second"}}`)
+		if err == nil {
+			t.Errorf("%s: expected the update to fail", name)
+		}
+
+		records, err := s.AuditTrail("item", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].Operation != storage.OperationCreate {
+			t.Errorf("%s: expected the failed update not to be recorded, got %+v", name, records)
+		}
+
+		closeSink()
+		release()
+	}
+}
+
+func TestAuditWithinTransaction(t *testing.T) {
+	entity := auditedEntity
+	var trail []storage.AuditRecord
+	entity.Hooks.AfterUpdate = func(s *storage.Storage, stored, resource storage.CollapsedResource) error {
+		var err error
+		trail, err = s.AuditTrail("item", resource.ID)
+		return err
+	}
+
+	repository, release := openRepository(t)
+	defer release()
+	s := newStorageWith(t, repository, []storage.Entity{entity}, storage.WithAuditSink(storage.RepositoryAuditSink{Repository: repository}))
+
+	id := create(t, s, "item", "first")
+	_, err := s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"second"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trail) != 2 || trail[1].Operation != storage.OperationUpdate {
+		t.Errorf("expected the update to be recorded within its transaction, got %+v", trail)
+	}
+}
+
+// failingAuditSink fails to record anything.
+type failingAuditSink struct{}
+
+func (failingAuditSink) Record(record storage.AuditRecord) error {
+	return errors.New("unavailable")
+}
+
+func (failingAuditSink) Trail(entityName, id string) ([]storage.AuditRecord, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestAuditSinkFailure(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+	s := newStorageWith(t, repository, []storage.Entity{auditedEntity}, storage.WithAuditSink(failingAuditSink{}))
+
+	id := create(t, s, "item", "first")
+	_, err := s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"second"}}`)
+	if err != nil {
+		t.Errorf("expected the update to succeed although the sink fails, got %v", err)
+	}
+
+	if names := readNames(t, s, "item"); names[id] != "second" {
+		t.Errorf("expected the update to be written, got %v", names)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := storage.NewFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	record := func(id, entityName, resourceID string) storage.AuditRecord {
+		return storage.AuditRecord{ID: id, Time: start, Entity: entityName, ResourceID: resourceID, Operation: storage.OperationCreate}
+	}
+
+	for _, r := range []storage.AuditRecord{record("1", "item", "a"), record("2", "item", "b"), record("3", "other", "a")} {
+		err = sink.Record(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := storage.NewFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	earlier := record("4", "item", "a")
+	earlier.Time = start.Add(-time.Hour)
+	err = reopened.Record(earlier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := reopened.Trail("item", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []storage.AuditRecord{earlier, record("1", "item", "a")}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected the records appended to the file in chronological order, got %+v", records)
+	}
+
+	records, err = reopened.Trail("item", "missing")
+	if err != nil || len(records) != 0 {
+		t.Errorf("expected no records, got %+v and %v", records, err)
+	}
+}
+
+func TestRepositoryAuditSink(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+
+	sink := storage.RepositoryAuditSink{Repository: repository, Collection: "log"}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, hour := range []int{2, 3, 1} {
+		err := sink.Record(storage.AuditRecord{ID: strconv.Itoa(hour), Time: start.Add(time.Duration(hour) * time.Hour), Entity: "item", ResourceID: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := sink.Record(storage.AuditRecord{ID: "4", Time: start, Entity: "item", ResourceID: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := sink.Trail("item", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2", "3"}) {
+		t.Errorf("expected the records of the resource in chronological order, got %v", ids)
+	}
+
+	stored := []storage.AuditRecord{}
+	err = repository.ReadAll("log", storage.Query{}, &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 4 {
+		t.Errorf("expected the records in the given collection, got %+v", stored)
+	}
+
+	stored = []storage.AuditRecord{}
+	err = repository.ReadAll(storage.AuditCollection, storage.Query{}, &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("expected no records in the default collection, got %+v", stored)
+	}
+}
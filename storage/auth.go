@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// Principal is the identity a request acts as. The zero value is the anonymous principal.
+type Principal struct {
+	ID     string                 `json:"id"`
+	Roles  []string               `json:"roles"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+func (p Principal) Anonymous() bool {
+	return p.ID == ""
+}
+
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticator identifies the principal of a request.
+// It returns NoCredentials if the request doesn't carry credentials it understands.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+	// Challenge is sent in the WWW-Authenticate header of 401 responses.
+	Challenge() string
+}
+
+// Authenticators tries each authenticator in turn until one finds its credentials in the request.
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(r *http.Request) (Principal, error) {
+	for _, authenticator := range a {
+		principal, err := authenticator.Authenticate(r)
+		if _, ok := err.(NoCredentials); ok {
+			continue
+		}
+
+		return principal, err
+	}
+
+	return Principal{}, NoCredentials{}
+}
+
+func (a Authenticators) Challenge() string {
+	challenges := make([]string, len(a))
+	for i, authenticator := range a {
+		challenges[i] = authenticator.Challenge()
+	}
+
+	return strings.Join(challenges, ", ")
+}
+
+// APIKeys maps static keys, sent in the X-API-Key header, to their principals.
+type APIKeys map[string]Principal
+
+func (a APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return Principal{}, NoCredentials{}
+	}
+
+	for k, principal := range a {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return principal, nil
+		}
+	}
+
+	return Principal{}, Unauthenticated{"unknown API key"}
+}
+
+func (a APIKeys) Challenge() string {
+	return `APIKey header="` + APIKeyHeader + `"`
+}
+
+type BasicUser struct {
+	Password string
+	// Principal defaults to one with the user name as ID.
+	Principal *Principal
+}
+
+// Basic authenticates users with HTTP basic authentication.
+type Basic struct {
+	Realm string
+	Users map[string]BasicUser
+}
+
+func (a Basic) Authenticate(r *http.Request) (Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return Principal{}, NoCredentials{}
+	}
+
+	user, ok := a.Users[name]
+	if !ok || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return Principal{}, Unauthenticated{"invalid user name or password"}
+	}
+
+	if user.Principal != nil {
+		return *user.Principal, nil
+	}
+
+	return Principal{ID: name}, nil
+}
+
+func (a Basic) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q", a.Realm)
+}
+
+// JWT verifies HMAC signed JSON web tokens sent as bearer tokens.
+// The "sub" claim becomes the principal's ID and the claim named by RolesClaim, "roles" by default, its roles.
+type JWT struct {
+	// Keys maps key IDs to secrets. Tokens without "kid" header are accepted if there is exactly one key.
+	Keys map[string][]byte
+	// Issuer and Audience are checked if set.
+	Issuer, Audience string
+	RolesClaim       string
+	// Leeway tolerates clock skew when checking "exp" and "nbf".
+	Leeway time.Duration
+}
+
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+func (a JWT) Authenticate(r *http.Request) (Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return Principal{}, NoCredentials{}
+	}
+
+	claims, err := a.verify(strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer ")))
+	if err != nil {
+		return Principal{}, Unauthenticated{err.Error()}
+	}
+
+	principal := Principal{Claims: claims}
+	principal.ID, _ = claims["sub"].(string)
+	if principal.ID == "" {
+		return Principal{}, Unauthenticated{"token has no subject"}
+	}
+
+	rolesClaim := a.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+
+	switch roles := claims[rolesClaim].(type) {
+	case string:
+		principal.Roles = strings.Fields(roles)
+	case []interface{}:
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, role)
+			}
+		}
+	}
+
+	return principal, nil
+}
+
+func (a JWT) Challenge() string {
+	return "Bearer"
+}
+
+func (a JWT) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	newHash, ok := jwtAlgorithms[header.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+
+	key, ok := a.Keys[header.KeyID]
+	if header.KeyID == "" && len(a.Keys) == 1 {
+		for _, key = range a.Keys {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	claims := map[string]interface{}{}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, a.validate(claims, time.Now())
+}
+
+func (a JWT) validate(claims map[string]interface{}, now time.Time) error {
+	if exp, ok := claims["exp"].(float64); ok && now.Add(-a.Leeway).After(time.Unix(int64(exp), 0)) {
+		return fmt.Errorf("token expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+
+	if a.Audience == "" {
+		return nil
+	}
+
+	switch audience := claims["aud"].(type) {
+	case string:
+		if audience == a.Audience {
+			return nil
+		}
+	case []interface{}:
+		for _, v := range audience {
+			if v == a.Audience {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("token is not meant for %q", a.Audience)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+
+	return nil
+}
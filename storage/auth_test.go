@@ -0,0 +1,357 @@
+package storage_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// signToken creates a JSON web token with the header and claims signed with the key and hash.
+func signToken(t *testing.T, header, claims map[string]interface{}, newHash func() hash.Hash, key []byte) string {
+	encode := func(v interface{}) string {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+
+	unsigned := encode(header) + "." + encode(claims)
+	if newHash == nil {
+		return unsigned + "."
+	}
+
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/item", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
+
+func TestJWT(t *testing.T) {
+	now := time.Now().Unix()
+	first, second := []byte("first secret"), []byte("second secret")
+	keys := map[string][]byte{"first": first, "second": second}
+	claims := map[string]interface{}{"sub": "alice", "roles": []string{"admin", "auditor"}}
+	with := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice"}
+		for k, v := range extra {
+			c[k] = v
+		}
+
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		jwt      storage.JWT
+		token    string
+		expected *storage.Principal
+	}{
+		{
+			name:     "valid",
+			jwt:      storage.JWT{Keys: keys},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256", "kid": "first"}, claims, sha256.New, first),
+			expected: &storage.Principal{ID: "alice", Roles: []string{"admin", "auditor"}},
+		},
+		{
+			name:     "key selected by kid",
+			jwt:      storage.JWT{Keys: keys},
+			token:    signToken(t, map[string]interface{}{"alg": "HS512", "kid": "second"}, claims, sha512.New, second),
+			expected: &storage.Principal{ID: "alice", Roles: []string{"admin", "auditor"}},
+		},
+		{
+			name:  "key of another kid",
+			jwt:   storage.JWT{Keys: keys},
+			token: signToken(t, map[string]interface{}{"alg": "HS256", "kid": "first"}, claims, sha256.New, second),
+		},
+		{
+			name:  "unknown kid",
+			jwt:   storage.JWT{Keys: keys},
+			token: signToken(t, map[string]interface{}{"alg": "HS256", "kid": "third"}, claims, sha256.New, first),
+		},
+		{
+			name:  "no kid with several keys",
+			jwt:   storage.JWT{Keys: keys},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, claims, sha256.New, first),
+		},
+		{
+			name:     "no kid with a single key",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}},
+			token:    signToken(t, map[string]interface{}{"alg": "HS384"}, claims, sha512.New384, first),
+			expected: &storage.Principal{ID: "alice", Roles: []string{"admin", "auditor"}},
+		},
+		{
+			name:  "alg none",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: signToken(t, map[string]interface{}{"alg": "none"}, claims, nil, nil),
+		},
+		{
+			name:  "mismatched algorithm",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: signToken(t, map[string]interface{}{"alg": "HS512"}, claims, sha256.New, first),
+		},
+		{
+			name:  "unsupported algorithm",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: signToken(t, map[string]interface{}{"alg": "RS256"}, claims, sha256.New, first),
+		},
+		{
+			name:  "tampered claims",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: tamper(signToken(t, map[string]interface{}{"alg": "HS256"}, claims, sha256.New, first)),
+		},
+		{
+			name:  "malformed",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: "not a token",
+		},
+		{
+			name:  "no subject",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{}, sha256.New, first),
+		},
+		{
+			name:  "expired",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"exp": now - 60}), sha256.New, first),
+		},
+		{
+			name:     "expired within the leeway",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}, Leeway: 2 * time.Minute},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"exp": now - 60}), sha256.New, first),
+			expected: &storage.Principal{ID: "alice"},
+		},
+		{
+			name:  "not valid yet",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"nbf": now + 60}), sha256.New, first),
+		},
+		{
+			name:     "not valid yet within the leeway",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}, Leeway: 2 * time.Minute},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"nbf": now + 60, "exp": now + 60}), sha256.New, first),
+			expected: &storage.Principal{ID: "alice"},
+		},
+		{
+			name:     "issuer",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}, Issuer: "issuer"},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"iss": "issuer"}), sha256.New, first),
+			expected: &storage.Principal{ID: "alice"},
+		},
+		{
+			name:  "unexpected issuer",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}, Issuer: "issuer"},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"iss": "other"}), sha256.New, first),
+		},
+		{
+			name:  "missing issuer",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}, Issuer: "issuer"},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, claims, sha256.New, first),
+		},
+		{
+			name:     "audience",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}, Audience: "api"},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"aud": "api"}), sha256.New, first),
+			expected: &storage.Principal{ID: "alice"},
+		},
+		{
+			name:     "one of several audiences",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}, Audience: "api"},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"aud": []string{"web", "api"}}), sha256.New, first),
+			expected: &storage.Principal{ID: "alice"},
+		},
+		{
+			name:  "other audience",
+			jwt:   storage.JWT{Keys: map[string][]byte{"first": first}, Audience: "api"},
+			token: signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"aud": []string{"web"}}), sha256.New, first),
+		},
+		{
+			name:     "roles claim",
+			jwt:      storage.JWT{Keys: map[string][]byte{"first": first}, RolesClaim: "scope"},
+			token:    signToken(t, map[string]interface{}{"alg": "HS256"}, with(map[string]interface{}{"scope": "read write"}), sha256.New, first),
+			expected: &storage.Principal{ID: "alice", Roles: []string{"read", "write"}},
+		},
+	}
+
+	for _, test := range tests {
+		principal, err := test.jwt.Authenticate(bearerRequest(test.token))
+		if test.expected == nil {
+			if _, ok := err.(storage.Unauthenticated); !ok {
+				t.Errorf("%s: expected the token to be rejected, got %+v and %v", test.name, principal, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		principal.Claims = nil
+		if !reflect.DeepEqual(principal, *test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, *test.expected, principal)
+		}
+	}
+
+	_, err := storage.JWT{Keys: keys}.Authenticate(httptest.NewRequest(http.MethodGet, "/item", nil))
+	if _, ok := err.(storage.NoCredentials); !ok {
+		t.Errorf("expected a request without token to carry no credentials, got %v", err)
+	}
+}
+
+// tamper replaces the claims of the token keeping its signature.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory","roles":["admin"]}`))
+
+	return strings.Join(parts, ".")
+}
+
+func TestBasic(t *testing.T) {
+	basic := storage.Basic{Realm: "test", Users: map[string]storage.BasicUser{
+		"alice": {Password: "secret"},
+		"bob":   {Password: "password", Principal: &storage.Principal{ID: "b", Roles: []string{"admin"}}},
+	}}
+
+	tests := []struct {
+		name, user, password string
+		expected             *storage.Principal
+	}{
+		{name: "user", user: "alice", password: "secret", expected: &storage.Principal{ID: "alice"}},
+		{name: "user with principal", user: "bob", password: "password", expected: &storage.Principal{ID: "b", Roles: []string{"admin"}}},
+		{name: "wrong password", user: "alice", password: "password"},
+		{name: "unknown user", user: "mallory", password: "secret"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/item", nil)
+		r.SetBasicAuth(test.user, test.password)
+
+		principal, err := basic.Authenticate(r)
+		if test.expected == nil {
+			if _, ok := err.(storage.Unauthenticated); !ok {
+				t.Errorf("%s: expected the credentials to be rejected, got %+v and %v", test.name, principal, err)
+			}
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(principal, *test.expected) {
+			t.Errorf("%s: expected %+v, got %+v and %v", test.name, *test.expected, principal, err)
+		}
+	}
+
+	_, err := basic.Authenticate(httptest.NewRequest(http.MethodGet, "/item", nil))
+	if _, ok := err.(storage.NoCredentials); !ok {
+		t.Errorf("expected a request without user to carry no credentials, got %v", err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys := storage.APIKeys{"key": {ID: "service", Roles: []string{"admin"}}}
+
+	r := httptest.NewRequest(http.MethodGet, "/item", nil)
+	r.Header.Set(storage.APIKeyHeader, "key")
+	principal, err := keys.Authenticate(r)
+	if err != nil || !reflect.DeepEqual(principal, keys["key"]) {
+		t.Errorf("expected the principal of the key, got %+v and %v", principal, err)
+	}
+
+	r.Header.Set(storage.APIKeyHeader, "other")
+	_, err = keys.Authenticate(r)
+	if _, ok := err.(storage.Unauthenticated); !ok {
+		t.Errorf("expected an unknown key to be rejected, got %v", err)
+	}
+
+	_, err = keys.Authenticate(httptest.NewRequest(http.MethodGet, "/item", nil))
+	if _, ok := err.(storage.NoCredentials); !ok {
+		t.Errorf("expected a request without key to carry no credentials, got %v", err)
+	}
+}
+
+func TestServiceAuthentication(t *testing.T) {
+	s, release := newStorage(t, []storage.Entity{{Name: "item", Data: reflect.TypeOf(testData{})}})
+	defer release()
+
+	authenticator := storage.Authenticators{
+		storage.APIKeys{"key": {ID: "service"}},
+		storage.Basic{Realm: "test", Users: map[string]storage.BasicUser{"alice": {Password: "secret"}}},
+	}
+	challenge := `APIKey header="X-API-Key", Basic realm="test"`
+
+	tests := []struct {
+		name      string
+		anonymous bool
+		prepare   func(r *http.Request)
+		status    int
+		createdBy string
+	}{
+		{name: "no credentials", prepare: func(r *http.Request) {}, status: http.StatusUnauthorized},
+		{name: "anonymous", anonymous: true, prepare: func(r *http.Request) {}, status: http.StatusOK},
+		{name: "API key", prepare: func(r *http.Request) { r.Header.Set(storage.APIKeyHeader, "key") }, status: http.StatusOK, createdBy: "service"},
+		{name: "basic", prepare: func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, status: http.StatusOK, createdBy: "alice"},
+		{name: "unknown API key", prepare: func(r *http.Request) { r.Header.Set(storage.APIKeyHeader, "other") }, status: http.StatusUnauthorized},
+		{name: "unknown API key of anonymous", anonymous: true, prepare: func(r *http.Request) { r.Header.Set(storage.APIKeyHeader, "other") }, status: http.StatusUnauthorized},
+		{name: "wrong password", prepare: func(r *http.Request) { r.SetBasicAuth("alice", "password") }, status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(storage.Service{Storage: s, Authenticator: authenticator, Anonymous: test.anonymous})
+
+		r, err := http.NewRequest(http.MethodPost, server.URL+"/item", strings.NewReader(`{"data":{"name":"`+test.name+`"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.prepare(r)
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resource := storage.CollapsedResource{}
+		if response.StatusCode == http.StatusOK {
+			err = json.NewDecoder(response.Body).Decode(&resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		response.Body.Close()
+		server.Close()
+
+		if response.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, response.StatusCode)
+			continue
+		}
+
+		authenticate := response.Header.Get("WWW-Authenticate")
+		if test.status == http.StatusUnauthorized && authenticate != challenge {
+			t.Errorf("%s: expected the challenge %q, got %q", test.name, challenge, authenticate)
+		}
+		if test.status != http.StatusUnauthorized && authenticate != "" {
+			t.Errorf("%s: unexpected challenge %q", test.name, authenticate)
+		}
+
+		if resource.Meta.CreatedBy != test.createdBy {
+			t.Errorf("%s: expected the resource to be created by %q, got %q", test.name, test.createdBy, resource.Meta.CreatedBy)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// BulkOperation is a single create, update or delete of a bulk.
+// Resource is the JSON document of creates and updates.
+type BulkOperation struct {
+	Operation string          `json:"op"`
+	ID        string          `json:"id"`
+	Resource  json.RawMessage `json:"resource"`
+}
+
+type BulkResult struct {
+	Operation string
+	ID        string
+	Resource  *CollapsedResource
+	Err       error
+}
+
+// Bulk validates the references of all operations with one query per referenced entity
+// and inserts all new resources at once. Updates and deletes, which purge, follow in their given order.
+// If atomic is set nothing is written unless every operation succeeds, for which the whole bulk runs
+// within a single transaction. Atomic bulks are invalid if the repository is not transactional.
+func (s *Storage) Bulk(entityName string, operations []BulkOperation, atomic bool) ([]BulkResult, error) {
+	entity, ok := s.entities.entitiesByName[entityName]
+	if !ok {
+		return nil, UndefinedEntity{entityName}
+	}
+
+	if _, ok := s.repository.(TransactionalRepository); atomic && !ok {
+		return nil, InvalidInput{"atomic bulks need a transactional repository"}
+	}
+
+	results := make([]BulkResult, len(operations))
+	for i, operation := range operations {
+		results[i] = s.prepareBulkOperation(entityName, operation)
+	}
+
+	err := s.validateBulkReferences(entity, results)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validateBulkTargets(entityName, results)
+	if err != nil {
+		return nil, err
+	}
+
+	if !atomic {
+		err = s.executeBulk(entity, results)
+		if err != nil {
+			return nil, err
+		}
+
+		return results, nil
+	}
+
+	if failed := countBulkFailures(results); failed != 0 {
+		abortBulk(results)
+
+		return results, BulkFailed{Failed: failed}
+	}
+
+	err = s.transaction(func(tx *Storage) error {
+		err := tx.executeBulk(entity, results)
+		if err != nil {
+			return err
+		}
+
+		if failed := countBulkFailures(results); failed != 0 {
+			return BulkFailed{Failed: failed}
+		}
+
+		return nil
+	})
+	if failed, ok := err.(BulkFailed); ok {
+		abortBulk(results)
+
+		return results, failed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// executeBulk executes the valid operations, creates first.
+func (s *Storage) executeBulk(entity Entity, results []BulkResult) error {
+	s.createBulk(entity, results)
+
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+
+		switch result.Operation {
+		case BulkUpdate:
+			results[i].Err = s.transaction(func(tx *Storage) error {
+				return tx.replace(result.Resource)
+			})
+		case BulkDelete:
+			results[i].Err = s.Purge(entity.Name, result.ID)
+		}
+
+		if results[i].Err == nil && result.Resource != nil {
+			hidden, err := s.hideFields(entity, *result.Resource)
+			if err != nil {
+				return err
+			}
+
+			results[i].Resource = &hidden
+		}
+	}
+
+	return nil
+}
+
+func countBulkFailures(results []BulkResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// abortBulk marks the operations which didn't fail as aborted, dropping what they would have written.
+func abortBulk(results []BulkResult) {
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+
+		results[i].Err = BulkAborted{}
+		results[i].Resource = nil
+		if results[i].Operation == BulkCreate {
+			results[i].ID = ""
+		}
+	}
+}
+
+func (s *Storage) prepareBulkOperation(entityName string, operation BulkOperation) BulkResult {
+	result := BulkResult{Operation: operation.Operation, ID: operation.ID}
+
+	switch operation.Operation {
+	case BulkCreate, BulkUpdate:
+		resource, err := s.createCollapsedResourceFromJSON(entityName, string(operation.Resource), operation.Operation == BulkUpdate, operation.ID)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		if operation.Operation == BulkCreate {
+			result.ID = ""
+
+			err = s.beforeCreate(resource.entity, &resource)
+			if err != nil {
+				result.Err = err
+				return result
+			}
+
+			resource.Meta = s.newMeta(resource.entity)
+
+			err = s.authorize(entityName, OperationCreate, resource)
+			if err != nil {
+				result.Err = err
+				return result
+			}
+		} else {
+			if resource.ID == "" {
+				resource.ID = operation.ID
+			}
+
+			if resource.ID == "" || operation.ID != "" && operation.ID != resource.ID {
+				result.Err = InvalidInput{fmt.Sprintf("update needs a single id, got %q and %q", operation.ID, resource.ID)}
+				return result
+			}
+
+			result.ID = resource.ID
+		}
+
+		result.Resource = &resource
+	case BulkDelete:
+		if operation.ID == "" {
+			result.Err = InvalidInput{"delete needs an id"}
+		}
+	default:
+		result.Err = InvalidInput{fmt.Sprintf("unknown bulk operation %q", operation.Operation)}
+	}
+
+	return result
+}
+
+// validateBulkReferences checks the references of all creates and updates with one query per referenced entity.
+func (s *Storage) validateBulkReferences(entity Entity, results []BulkResult) error {
+	resources := []CollapsedResource{}
+	validated := []int{}
+	for i, result := range results {
+		if result.Err != nil || result.Resource == nil {
+			continue
+		}
+
+		err := checkRelations(entity, *result.Resource)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		resources = append(resources, *result.Resource)
+		validated = append(validated, i)
+	}
+
+	dangling, err := s.findDanglingReferences(entity, resources)
+	if err != nil {
+		return err
+	}
+
+	for j, i := range validated {
+		if dangling[j] != nil {
+			results[i].Err = DanglingReferences{Entity: entity.Name, Relations: dangling[j]}
+		}
+	}
+
+	return nil
+}
+
+// validateBulkTargets checks that all updated and deleted resources exist using a single query.
+func (s *Storage) validateBulkTargets(entityName string, results []BulkResult) error {
+	ids := []string{}
+	for _, result := range results {
+		if result.Err == nil && result.Operation != BulkCreate {
+			ids = append(ids, result.ID)
+		}
+	}
+
+	existing, err := s.existingIDs(entityName, ids)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		if result.Err == nil && result.Operation != BulkCreate && !existing[result.ID] {
+			results[i].Err = NotFound{Entity: entityName, ID: result.ID}
+		}
+	}
+
+	return nil
+}
+
+// createBulk inserts the resources of the valid creates at once within a transaction.
+// If it fails and rolls back, the creates are retried one by one so that only the failing ones fail.
+// As after create hooks may have effects beyond the transaction, creates of entities having one are inserted one
+// by one right away unless the bulk is atomic, so that no hook runs twice.
+// Without transactions, creates whose hooks fail keep their ID as they stay stored.
+func (s *Storage) createBulk(entity Entity, results []BulkResult) {
+	created := []int{}
+	for i, result := range results {
+		if result.Err != nil || result.Operation != BulkCreate {
+			continue
+		}
+
+		id, err := s.generateID(result.Resource.entity, *result.Resource)
+		if err != nil {
+			results[i].Err = err
+			results[i].Resource = nil
+			continue
+		}
+
+		result.Resource.ID = id
+		results[i].ID = id
+
+		created = append(created, i)
+	}
+
+	if len(created) == 0 {
+		return
+	}
+
+	_, transactional := s.repository.(TransactionalRepository)
+	rolledBack := transactional && s.pending == nil
+
+	batches := [][]int{created}
+	if rolledBack && entity.Hooks.AfterCreate != nil {
+		batches = make([][]int, len(created))
+		for j, i := range created {
+			batches[j] = []int{i}
+		}
+	}
+
+	inserted := map[int]bool{}
+	for _, batch := range batches {
+		err := s.transaction(func(tx *Storage) error {
+			return tx.insertBulk(entity.Name, results, batch, inserted)
+		})
+		if err != nil && rolledBack && len(batch) > 1 {
+			for _, i := range batch {
+				results[i].Err = s.transaction(func(tx *Storage) error {
+					return tx.insertBulk(entity.Name, results, []int{i}, inserted)
+				})
+			}
+		}
+	}
+
+	for _, i := range created {
+		if results[i].Err != nil && (rolledBack || !inserted[i]) {
+			results[i].ID = ""
+			results[i].Resource = nil
+		}
+	}
+}
+
+// insertBulk inserts the resources of the creates and records, publishes and hooks each of them like create,
+// setting the errors of the creates which fail and marking the inserted ones. It returns the first error.
+func (s *Storage) insertBulk(entityName string, results []BulkResult, created []int, inserted map[int]bool) error {
+	data := make([]interface{}, len(created))
+	for j, i := range created {
+		data[j] = *results[i].Resource
+	}
+
+	err := s.repository.CreateAll(entityName, data)
+	if err != nil {
+		for _, i := range created {
+			results[i].Err = err
+			inserted[i] = false
+		}
+
+		return err
+	}
+
+	var first error
+	for _, i := range created {
+		inserted[i] = true
+		resource := *results[i].Resource
+
+		err = s.recordRevision(resource)
+		if err == nil {
+			err = s.mutated(OperationCreate, entityName, resource.ID, nil, &resource, nil)
+		}
+		if err == nil {
+			err = s.afterCreate(resource.entity, resource)
+		}
+
+		results[i].Err = err
+		if first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,270 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// bulkEntity logs the operations run on its resources and fails creates and updates of the name "fail"
+// after writing them.
+func bulkEntity(log *[]string) storage.Entity {
+	return storage.Entity{
+		Name: "item",
+		Data: reflect.TypeOf(testData{}),
+		Hooks: storage.Hooks{
+			AfterCreate: func(s *storage.Storage, resource storage.CollapsedResource) error {
+				if resource.Data.(*testData).Name == "fail" {
+					return storage.HookError{Status: 409, Message: "cannot fail"}
+				}
+
+				*log = append(*log, "create "+resource.Data.(*testData).Name)
+				return nil
+			},
+			AfterUpdate: func(s *storage.Storage, stored, resource storage.CollapsedResource) error {
+				if resource.Data.(*testData).Name == "fail" {
+					return storage.HookError{Status: 409, Message: "cannot fail"}
+				}
+
+				*log = append(*log, "update "+resource.Data.(*testData).Name)
+				return nil
+			},
+			AfterDelete: func(s *storage.Storage, resource storage.CollapsedResource) error {
+				*log = append(*log, "delete "+resource.Data.(*testData).Name)
+				return nil
+			},
+		},
+	}
+}
+
+func bulkCreate(name string) storage.BulkOperation {
+	return storage.BulkOperation{Operation: storage.BulkCreate, Resource: json.RawMessage(`{"data":{"name":"` + name + `"}}`)}
+}
+
+func bulkUpdate(id, name string) storage.BulkOperation {
+	return storage.BulkOperation{Operation: storage.BulkUpdate, ID: id, Resource: json.RawMessage(`{"data":{"name":"` + name + `"}}`)}
+}
+
+func bulkDelete(id string) storage.BulkOperation {
+	return storage.BulkOperation{Operation: storage.BulkDelete, ID: id}
+}
+
+func TestBulkOrder(t *testing.T) {
+	log := []string{}
+	s, release := newStorage(t, []storage.Entity{bulkEntity(&log)})
+	defer release()
+
+	first := create(t, s, "item", "first")
+	second := create(t, s, "item", "second")
+	log = nil
+
+	results, err := s.Bulk("item", []storage.BulkOperation{
+		bulkDelete(first),
+		bulkCreate("third"),
+		bulkUpdate(second, "updated"),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("operation %d failed: %s", i, result.Err)
+		}
+	}
+
+	expectedLog := []string{"create third", "delete first", "update updated"}
+	if !reflect.DeepEqual(log, expectedLog) {
+		t.Errorf("expected the operations %v, got %v", expectedLog, log)
+	}
+
+	if results[0].ID != first || results[1].ID == "" || results[2].ID != second {
+		t.Errorf("unexpected IDs %+v", results)
+	}
+
+	expected := map[string]string{second: "updated", results[1].ID: "third"}
+	if names := readNames(t, s, "item"); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestBulkItemErrors(t *testing.T) {
+	log := []string{}
+	s, release := newStorage(t, []storage.Entity{bulkEntity(&log)})
+	defer release()
+
+	existing := create(t, s, "item", "existing")
+	log = nil
+
+	results, err := s.Bulk("item", []storage.BulkOperation{
+		bulkCreate("created"),
+		bulkUpdate("missing", "updated"),
+		bulkDelete(""),
+		{Operation: "upsert"},
+		bulkUpdate(existing, "fail"),
+		bulkCreate("fail"),
+		bulkCreate("other"),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{0, 6} {
+		if results[i].Err != nil || results[i].Resource == nil {
+			t.Errorf("expected create %d to succeed, got %+v", i, results[i])
+		}
+	}
+	if results[1].Err != (storage.NotFound{Entity: "item", ID: "missing"}) {
+		t.Errorf("expected not found, got %#v", results[1].Err)
+	}
+	for _, i := range []int{2, 3} {
+		if _, ok := results[i].Err.(storage.InvalidInput); !ok {
+			t.Errorf("expected invalid input for operation %d, got %#v", i, results[i].Err)
+		}
+	}
+	for _, i := range []int{4, 5} {
+		if _, ok := results[i].Err.(storage.HookError); !ok {
+			t.Errorf("expected the hook error of operation %d, got %#v", i, results[i].Err)
+		}
+	}
+	if results[5].ID != "" || results[5].Resource != nil {
+		t.Errorf("expected the failed create to be rolled back, got %+v", results[5])
+	}
+
+	expected := map[string]string{existing: "existing", results[0].ID: "created", results[6].ID: "other"}
+	if names := readNames(t, s, "item"); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	creates := []string{}
+	for _, entry := range log {
+		if strings.HasPrefix(entry, "create ") {
+			creates = append(creates, entry)
+		}
+	}
+	if expectedCreates := []string{"create created", "create other"}; !reflect.DeepEqual(creates, expectedCreates) {
+		t.Errorf("expected the after create hooks to run once, got %v", creates)
+	}
+}
+
+func TestBulkDuplicateIDs(t *testing.T) {
+	log := []string{}
+	hooked := bulkEntity(&log)
+	hooked.IDGenerator = fixedID("hooked")
+	plain := storage.Entity{Name: "plain", Data: reflect.TypeOf(testData{}), IDGenerator: fixedID("plain")}
+
+	s, release := newStorage(t, []storage.Entity{hooked, plain})
+	defer release()
+
+	for _, entityName := range []string{"item", "plain"} {
+		results, err := s.Bulk(entityName, []storage.BulkOperation{bulkCreate("first"), bulkCreate("second")}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if results[0].Err != nil || results[0].Resource == nil {
+			t.Errorf("%s: expected the first create to succeed, got %+v", entityName, results[0])
+		}
+		if results[1].Err == nil || results[1].ID != "" || results[1].Resource != nil {
+			t.Errorf("%s: expected the second create to fail, got %+v", entityName, results[1])
+		}
+
+		expected := map[string]string{results[0].ID: "first"}
+		if names := readNames(t, s, entityName); !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected %v, got %v", entityName, expected, names)
+		}
+	}
+
+	if expectedLog := []string{"create first"}; !reflect.DeepEqual(log, expectedLog) {
+		t.Errorf("expected the after create hook to run once, got %v", log)
+	}
+}
+
+func TestBulkAtomic(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations func(existing string) []storage.BulkOperation
+		failed     int
+	}{
+		{"invalid operation", func(existing string) []storage.BulkOperation {
+			return []storage.BulkOperation{bulkCreate("created"), bulkUpdate(existing, "updated"), bulkDelete("missing")}
+		}, 1},
+		{"failing operation", func(existing string) []storage.BulkOperation {
+			return []storage.BulkOperation{bulkCreate("created"), bulkUpdate(existing, "fail"), bulkCreate("other")}
+		}, 1},
+	}
+
+	for _, test := range tests {
+		log := []string{}
+		s, release := newStorage(t, []storage.Entity{bulkEntity(&log)})
+
+		existing := create(t, s, "item", "existing")
+
+		results, err := s.Bulk("item", test.operations(existing), true)
+		if err != (storage.BulkFailed{Failed: test.failed}) {
+			t.Errorf("%s: expected the bulk to fail, got %#v", test.name, err)
+		}
+
+		aborted := 0
+		for _, result := range results {
+			if result.Err == (storage.BulkAborted{}) {
+				aborted++
+				if result.Resource != nil || result.Operation == storage.BulkCreate && result.ID != "" {
+					t.Errorf("%s: aborted operation kept its result %+v", test.name, result)
+				}
+			}
+		}
+		if aborted != len(results)-test.failed {
+			t.Errorf("%s: expected %d aborted operations, got %+v", test.name, len(results)-test.failed, results)
+		}
+
+		expected := map[string]string{existing: "existing"}
+		if names := readNames(t, s, "item"); !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, names)
+		}
+
+		release()
+	}
+}
+
+func TestBulkAtomicNeedsTransactions(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+
+	log := []string{}
+	s := newStorageWith(t, nonTransactional{repository}, []storage.Entity{bulkEntity(&log)})
+
+	_, err := s.Bulk("item", []storage.BulkOperation{bulkCreate("created")}, true)
+	if _, ok := err.(storage.InvalidInput); !ok {
+		t.Errorf("expected an atomic bulk to be invalid without transactions, got %#v", err)
+	}
+
+	if names := readNames(t, s, "item"); len(names) != 0 {
+		t.Errorf("expected nothing to be written, got %v", names)
+	}
+
+	results, err := s.Bulk("item", []storage.BulkOperation{bulkCreate("created")}, false)
+	if err != nil || results[0].Err != nil {
+		t.Errorf("expected a bulk which is not atomic to succeed, got %v %+v", err, results)
+	}
+}
+
+func TestBulkCreateMeta(t *testing.T) {
+	policy := storage.Policy{"item": {storage.OperationCreate: {{Where: map[string]string{"meta.createdBy": "id"}}}, storage.OperationList: {{}}}}
+	log := []string{}
+	s, release := newStorage(t, []storage.Entity{bulkEntity(&log)}, storage.WithPolicy(policy))
+	defer release()
+	s = s.As(storage.Principal{ID: "alice"})
+
+	results, err := s.Bulk("item", []storage.BulkOperation{bulkCreate("created")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Err != nil || results[0].Resource.Meta.CreatedBy != "alice" {
+		t.Errorf("expected the create to be authorized with its meta section, got %+v", results[0])
+	}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+var defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", APIKeyHeader}
+
+// CORS lets browsers call the service from other origins.
+type CORS struct {
+	// AllowedOrigins are origins like "https://example.com". A "*" matches any part of an origin,
+	// e.g. "https://*.example.com", and a single "*" every origin, which is never allowed to send credentials.
+	AllowedOrigins []string
+	// AllowedMethods default to GET, HEAD, POST, PUT and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders default to Accept, Authorization, Content-Type and X-API-Key. A "*" allows every header.
+	AllowedHeaders []string
+	// ExposedHeaders may be read by scripts besides the CORS-safelisted response headers.
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache the result of a preflight. Zero leaves it to the browser.
+	MaxAge time.Duration
+	// AllowCredentials lets browsers send cookies and authorization headers from the origins which aren't only
+	// allowed by a single "*", as any site could make requests on behalf of the user otherwise.
+	AllowCredentials bool
+}
+
+// handle sets the CORS headers of the response and reports whether the request is a preflight.
+// Requests of origins which aren't allowed get no CORS headers, so browsers refuse them.
+func (c *CORS) handle(rw http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	requestedMethod := r.Header.Get("Access-Control-Request-Method")
+	preflight := r.Method == http.MethodOptions && origin != "" && requestedMethod != ""
+
+	rw.Header().Add("Vary", "Origin")
+	if preflight {
+		rw.Header().Add("Vary", "Access-Control-Request-Method")
+		rw.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	allowed, anyOrigin := c.allowsOrigin(origin)
+	if origin == "" || !allowed {
+		return preflight
+	}
+
+	if preflight {
+		requestedHeaders := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+		if !c.allowsMethod(requestedMethod) || !c.allowsHeaders(requestedHeaders) {
+			return true
+		}
+
+		rw.Header().Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+		if len(requestedHeaders) != 0 {
+			rw.Header().Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+		}
+		if c.MaxAge > 0 {
+			rw.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+	} else if len(c.ExposedHeaders) != 0 {
+		rw.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+
+	if anyOrigin {
+		rw.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		if c.AllowCredentials {
+			rw.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return preflight
+}
+
+// allowsOrigin reports whether the origin is allowed and whether only a single "*" allows it.
+func (c *CORS) allowsOrigin(origin string) (allowed, anyOrigin bool) {
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" {
+			anyOrigin = true
+		} else if matchWildcard(strings.ToLower(pattern), strings.ToLower(origin)) {
+			return true, false
+		}
+	}
+
+	return anyOrigin, anyOrigin
+}
+
+func (c *CORS) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+
+	return c.AllowedMethods
+}
+
+func (c *CORS) allowsMethod(method string) bool {
+	for _, allowed := range c.methods() {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CORS) allowsHeaders(headers []string) bool {
+	allowed := c.AllowedHeaders
+	if len(allowed) == 0 {
+		allowed = defaultCORSHeaders
+	}
+
+	for _, header := range headers {
+		found := false
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(a, header) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseHeaderList(list string) []string {
+	headers := []string{}
+	for _, header := range strings.Split(list, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" {
+			headers = append(headers, http.CanonicalHeaderKey(header))
+		}
+	}
+
+	return headers
+}
+
+// matchWildcard matches s against a pattern in which every "*" stands for any, possibly empty, string.
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,156 @@
+package storage_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+func TestCORS(t *testing.T) {
+	s, release := newStorage(t, []storage.Entity{{Name: "item", Data: reflect.TypeOf(testData{})}})
+	defer release()
+
+	service := storage.Service{
+		Storage:       s,
+		Authenticator: storage.APIKeys{"key": {ID: "service"}},
+		CORS: &storage.CORS{
+			AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+			ExposedHeaders:   []string{"X-Total"},
+			MaxAge:           10 * time.Minute,
+			AllowCredentials: true,
+		},
+	}
+	custom := service
+	custom.CORS = &storage.CORS{AllowedOrigins: []string{"*"}, AllowedMethods: []string{http.MethodGet}, AllowedHeaders: []string{"*"}}
+	credentials := service
+	credentials.CORS = &storage.CORS{AllowedOrigins: []string{"*", "https://app.example.com"}, AllowCredentials: true}
+
+	preflightVary := []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}
+
+	tests := []struct {
+		name            string
+		service         storage.Service
+		method, origin  string
+		requestMethod   string
+		requestHeaders  string
+		status          int
+		expectedHeaders map[string]string
+		vary            []string
+	}{
+		{
+			name: "preflight", service: service, method: http.MethodOptions, origin: "https://app.example.com",
+			requestMethod: http.MethodPut, requestHeaders: "content-type, x-api-key", status: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, DELETE",
+				"Access-Control-Allow-Headers":     "Content-Type, X-Api-Key",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "600",
+				"Access-Control-Expose-Headers":    "",
+			},
+			vary: preflightVary,
+		},
+		{
+			name: "preflight of a wildcard origin", service: service, method: http.MethodOptions, origin: "https://a.b.example.org",
+			requestMethod: http.MethodGet, status: http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://a.b.example.org", "Access-Control-Allow-Headers": ""},
+			vary:            preflightVary,
+		},
+		{
+			name: "preflight of a rejected origin", service: service, method: http.MethodOptions, origin: "https://example.org",
+			requestMethod: http.MethodGet, status: http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+			vary:            preflightVary,
+		},
+		{
+			name: "preflight of a rejected method", service: custom, method: http.MethodOptions, origin: "https://any.com",
+			requestMethod: http.MethodDelete, status: http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+			vary:            preflightVary,
+		},
+		{
+			name: "preflight of a rejected header", service: service, method: http.MethodOptions, origin: "https://app.example.com",
+			requestMethod: http.MethodGet, requestHeaders: "X-Custom", status: http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Headers": ""},
+			vary:            preflightVary,
+		},
+		{
+			name: "preflight of any header", service: custom, method: http.MethodOptions, origin: "https://any.com",
+			requestMethod: http.MethodGet, requestHeaders: "X-Custom", status: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Methods":     "GET",
+				"Access-Control-Allow-Headers":     "X-Custom",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Max-Age":           "",
+			},
+			vary: preflightVary,
+		},
+		{
+			name: "request", service: service, method: http.MethodGet, origin: "https://app.example.com", status: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Total",
+			},
+			vary: []string{"Origin"},
+		},
+		{
+			name: "request of any origin with credentials", service: credentials, method: http.MethodGet, origin: "https://evil.com",
+			status: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": ""},
+			vary:            []string{"Origin"},
+		},
+		{
+			name: "request of a listed origin with credentials", service: credentials, method: http.MethodGet, origin: "https://app.example.com",
+			status: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com", "Access-Control-Allow-Credentials": "true"},
+			vary:            []string{"Origin"},
+		},
+		{
+			name: "request of a rejected origin", service: service, method: http.MethodGet, origin: "https://evil.com", status: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Expose-Headers": ""},
+			vary:            []string{"Origin"},
+		},
+		{
+			name: "same origin request", service: service, method: http.MethodGet, status: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			vary:            []string{"Origin"},
+		},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/item", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", test.requestMethod)
+		}
+		if test.requestHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", test.requestHeaders)
+		}
+
+		rw := httptest.NewRecorder()
+		test.service.ServeHTTP(rw, r)
+
+		if rw.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, rw.Code)
+		}
+
+		for header, expected := range test.expectedHeaders {
+			if actual := rw.Header().Get(header); actual != expected {
+				t.Errorf("%s: expected %s %q, got %q", test.name, header, expected, actual)
+			}
+		}
+
+		if vary := rw.Header()["Vary"]; !reflect.DeepEqual(vary, test.vary) {
+			t.Errorf("%s: expected Vary %v, got %v", test.name, test.vary, vary)
+		}
+	}
+}
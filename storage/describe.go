@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+)
+
+const MetaActionEntities = "entities"
+
+// EntityDescription describes an entity to generic clients discovering the entities at runtime.
+type EntityDescription struct {
+	Name string `json:"name"`
+	// Schema is the JSON schema of the data, which marks read only fields as such and hidden ones as write only.
+	Schema       interface{}            `json:"schema"`
+	Relations    []RelationDescription  `json:"relations"`
+	ReferencedBy []ReferenceDescription `json:"referencedBy"`
+	// Operations are the operations the principal may execute on at least some resources.
+	Operations []Operation `json:"operations"`
+	Versioned  bool        `json:"versioned"`
+	SoftDelete bool        `json:"softDelete"`
+}
+
+// RelationDescription describes a relation of an entity. Max is null if the relation is unbounded.
+type RelationDescription struct {
+	Name   string `json:"name"`
+	Entity string `json:"entity"`
+	Min    int    `json:"min"`
+	Max    *int   `json:"max"`
+}
+
+// ReferenceDescription describes a relation of another entity to the described one.
+type ReferenceDescription struct {
+	Entity   string `json:"entity"`
+	Relation string `json:"relation"`
+}
+
+var entityOperations = []Operation{
+	OperationRead,
+	OperationList,
+	OperationCreate,
+	OperationUpdate,
+	OperationDelete,
+	OperationPurge,
+	OperationExpand,
+	OperationReferencedBy,
+	OperationAudit,
+	OperationHistory,
+}
+
+// DescribeEntities describes all entities sorted by name.
+func (s *Storage) DescribeEntities() ([]EntityDescription, error) {
+	entities := s.entities.All()
+
+	descriptions := make([]EntityDescription, len(entities))
+	for i, entity := range entities {
+		description, err := s.describeEntity(entity)
+		if err != nil {
+			return nil, err
+		}
+
+		descriptions[i] = description
+	}
+
+	return descriptions, nil
+}
+
+func (s *Storage) DescribeEntity(entityName string) (EntityDescription, error) {
+	entity, ok := s.entities.entitiesByName[entityName]
+	if !ok {
+		return EntityDescription{}, UndefinedEntity{entityName}
+	}
+
+	return s.describeEntity(entity)
+}
+
+func (s *Storage) describeEntity(entity Entity) (EntityDescription, error) {
+	definition, err := CreateSwaggerDefinition(reflect.New(entity.Data).Interface())
+	if err != nil {
+		return EntityDescription{}, err
+	}
+	markFieldPermissions(definition, entity.Fields)
+
+	relations := []RelationDescription{}
+	for name, reference := range entity.References {
+		relation := RelationDescription{Name: name, Entity: reference.Name}
+		if cardinality, ok := entity.Cardinalities[name]; ok {
+			relation.Min = cardinality.Min
+			if cardinality.Max != 0 {
+				max := cardinality.Max
+				relation.Max = &max
+			}
+		}
+
+		relations = append(relations, relation)
+	}
+	sort.Slice(relations, func(i, j int) bool {
+		return relations[i].Name < relations[j].Name
+	})
+
+	referencedBy := []ReferenceDescription{}
+	for entityName, relationNames := range s.entities.referencedBy[entity.Name] {
+		for _, relationName := range relationNames {
+			referencedBy = append(referencedBy, ReferenceDescription{Entity: entityName, Relation: relationName})
+		}
+	}
+	sort.Slice(referencedBy, func(i, j int) bool {
+		if referencedBy[i].Entity != referencedBy[j].Entity {
+			return referencedBy[i].Entity < referencedBy[j].Entity
+		}
+
+		return referencedBy[i].Relation < referencedBy[j].Relation
+	})
+
+	operations := []Operation{}
+	for _, operation := range entityOperations {
+		if s.mayExecute(entity.Name, operation) {
+			operations = append(operations, operation)
+		}
+	}
+
+	return EntityDescription{
+		Name:         entity.Name,
+		Schema:       toOpenAPISchema(definition, "#/definitions/"),
+		Relations:    relations,
+		ReferencedBy: referencedBy,
+		Operations:   operations,
+		Versioned:    entity.Versioned,
+		SoftDelete:   entity.SoftDelete,
+	}, nil
+}
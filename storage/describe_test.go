@@ -0,0 +1,146 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// treeNode contains itself, so it is described by reference.
+type treeNode struct {
+	Name     string     `json:"name"`
+	Children []treeNode `json:"children"`
+}
+
+var describedEntities = func() []storage.Entity {
+	user := storage.Entity{Name: "user", Data: reflect.TypeOf(testData{})}
+	tree := storage.Entity{
+		Name:          "tree",
+		Data:          reflect.TypeOf(treeNode{}),
+		References:    map[string]storage.Entity{"owner": user, "watchers": user},
+		Cardinalities: map[string]storage.Cardinality{"owner": storage.ExactlyOne},
+		Versioned:     true,
+	}
+
+	return []storage.Entity{tree, user}
+}()
+
+func TestDescribeEntities(t *testing.T) {
+	policy := storage.Policy{
+		"tree": {
+			storage.OperationRead:   {{}},
+			storage.OperationList:   {{}},
+			storage.OperationUpdate: {{Roles: []string{"admin"}}},
+			storage.OperationAudit:  {{Roles: []string{"admin"}}},
+		},
+	}
+	s, release := newStorage(t, describedEntities, storage.WithPolicy(policy))
+	defer release()
+
+	keys := storage.APIKeys{"admin": {ID: "root", Roles: []string{"admin"}}}
+	server := httptest.NewServer(storage.Service{Storage: s, Authenticator: keys, Anonymous: true})
+	defer server.Close()
+
+	describe := func(path, key string, out interface{}) {
+		r, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			r.Header.Set(storage.APIKeyHeader, key)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", path, response.StatusCode)
+		}
+
+		err = json.NewDecoder(response.Body).Decode(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	descriptions := []storage.EntityDescription{}
+	describe("/meta/entities", "", &descriptions)
+	if len(descriptions) != 2 || descriptions[0].Name != "tree" || descriptions[1].Name != "user" {
+		t.Fatalf("expected the entities sorted by name, got %+v", descriptions)
+	}
+
+	tree := descriptions[0]
+	one := 1
+	expectedRelations := []storage.RelationDescription{
+		{Name: "owner", Entity: "user", Min: 1, Max: &one},
+		{Name: "watchers", Entity: "user"},
+	}
+	if !reflect.DeepEqual(tree.Relations, expectedRelations) {
+		t.Errorf("expected the relations %+v, got %+v", expectedRelations, tree.Relations)
+	}
+	if !tree.Versioned || tree.SoftDelete {
+		t.Errorf("unexpected flags %+v", tree)
+	}
+
+	expectedReferences := []storage.ReferenceDescription{{Entity: "tree", Relation: "owner"}, {Entity: "tree", Relation: "watchers"}}
+	if !reflect.DeepEqual(descriptions[1].ReferencedBy, expectedReferences) {
+		t.Errorf("expected the references %+v, got %+v", expectedReferences, descriptions[1].ReferencedBy)
+	}
+	if len(descriptions[1].Operations) != 0 {
+		t.Errorf("expected no operations on users, got %v", descriptions[1].Operations)
+	}
+
+	anonymous := []storage.Operation{storage.OperationRead, storage.OperationList}
+	if !reflect.DeepEqual(tree.Operations, anonymous) {
+		t.Errorf("expected the operations %v, got %v", anonymous, tree.Operations)
+	}
+
+	admin := storage.EntityDescription{}
+	describe("/meta/entities/tree", "admin", &admin)
+	expectedOperations := []storage.Operation{storage.OperationRead, storage.OperationList, storage.OperationUpdate, storage.OperationAudit}
+	if !reflect.DeepEqual(admin.Operations, expectedOperations) {
+		t.Errorf("expected the operations %v, got %v", expectedOperations, admin.Operations)
+	}
+
+	expectResolvedReferences(t, tree.Schema)
+}
+
+// expectResolvedReferences checks that the schema defines every type it refers to.
+func expectResolvedReferences(t *testing.T, schema interface{}) {
+	root, _ := schema.(map[string]interface{})
+	definitions, _ := root["definitions"].(map[string]interface{})
+	found := 0
+
+	var visit func(node interface{})
+	visit = func(node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if reference, ok := node["$ref"].(string); ok {
+				found++
+				if definitions[strings.TrimPrefix(reference, "#/definitions/")] == nil {
+					t.Errorf("%s is not defined in %v", reference, root)
+				}
+			}
+			for _, child := range node {
+				visit(child)
+			}
+		case []interface{}:
+			for _, child := range node {
+				visit(child)
+			}
+		}
+	}
+	visit(root)
+
+	if found == 0 {
+		t.Errorf("expected the recursive type to be referred to, got %v", root)
+	}
+}
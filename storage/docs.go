@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"net/http"
+	"strings"
+)
+
+const MetaActionDocs = "docs"
+
+// docs is the API explorer. It is self-contained, so it works offline and without any CDN.
+var docs = newSpec([]byte(strings.Replace(docsPage, "{{apiKeyHeader}}", APIKeyHeader, -1)), "text/html; charset=utf-8")
+
+// serveDocs responds with the API explorer, which loads the OpenAPI document of the service relative to its own URL.
+// It is served without authentication as it contains no data. Credentials entered in it are sent with every request it makes.
+func serveDocs(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+
+	docs.serve(rw, r)
+}
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>API explorer</title>
+<style>
+body { font-family: sans-serif; margin: 0; color: #222; background: #fafafa; }
+header { padding: 1em 2em; background: #2d3e50; color: #fff; }
+header h1 { margin: 0 0 .5em; font-size: 1.4em; }
+header label { margin-right: 1em; }
+main { padding: 1em 2em; }
+details { margin: .5em 0; background: #fff; border: 1px solid #ddd; border-radius: 4px; }
+summary { padding: .5em; cursor: pointer; font-family: monospace; font-size: 1.05em; }
+details > div { padding: .5em 1em 1em; border-top: 1px solid #ddd; }
+.method { display: inline-block; width: 5em; font-weight: bold; }
+.get { color: #1a7f37; } .post { color: #0550ae; } .put { color: #9a6700; } .delete { color: #cf222e; }
+.description { color: #666; font-family: sans-serif; margin-left: 1em; }
+label { display: block; margin: .3em 0; }
+input, select { font-family: monospace; }
+textarea { width: 100%; height: 12em; font-family: monospace; box-sizing: border-box; }
+pre { background: #f3f3f3; padding: .5em; overflow: auto; max-height: 30em; }
+.error { color: #cf222e; }
+</style>
+</head>
+<body>
+<header>
+<h1 id="title">API explorer</h1>
+<label>Credentials
+<select id="scheme">
+<option value="none">None</option>
+<option value="apikey">{{apiKeyHeader}}</option>
+<option value="bearer">Bearer token</option>
+<option value="basic">Basic (user:password)</option>
+</select>
+<input id="credentials" type="password" size="40">
+</label>
+<button id="load">Load</button>
+</header>
+<main id="operations"></main>
+<script>
+(function () {
+	"use strict";
+
+	var root = location.pathname.replace(/\/meta\/docs\/?$/, "");
+	var document_ = null;
+
+	function element(name, attributes, children) {
+		var e = document.createElement(name);
+		Object.keys(attributes || {}).forEach(function (key) {
+			e.setAttribute(key, attributes[key]);
+		});
+		(children || []).forEach(function (child) {
+			e.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+		});
+		return e;
+	}
+
+	function headers() {
+		var result = {};
+		var credentials = document.getElementById("credentials").value;
+		switch (document.getElementById("scheme").value) {
+		case "apikey":
+			result["{{apiKeyHeader}}"] = credentials;
+			break;
+		case "bearer":
+			result.Authorization = "Bearer " + credentials;
+			break;
+		case "basic":
+			result.Authorization = "Basic " + btoa(credentials);
+			break;
+		}
+		return result;
+	}
+
+	function resolve(schema) {
+		while (schema && schema.$ref) {
+			var name = schema.$ref.split("/").pop();
+			schema = document_.components.schemas[name] || document_.components.requestBodies[name];
+		}
+		return schema || {};
+	}
+
+	function example(schema, depth) {
+		schema = resolve(schema);
+		if (depth > 8) {
+			return null;
+		}
+		if (schema.content) {
+			return example(schema.content["application/json"].schema, depth + 1);
+		}
+		if (schema.oneOf) {
+			return example(schema.oneOf[0], depth + 1);
+		}
+		if ("const" in schema) {
+			return schema.const;
+		}
+		if (schema.enum) {
+			return schema.enum[0];
+		}
+		var type = Array.isArray(schema.type) ? schema.type[0] : schema.type;
+		switch (type) {
+		case "object":
+			var result = {};
+			Object.keys(schema.properties || {}).sort().forEach(function (key) {
+				if (!schema.properties[key].readOnly) {
+					result[key] = example(schema.properties[key], depth + 1);
+				}
+			});
+			return result;
+		case "array":
+			return schema.maxItems === 0 ? [] : [example(schema.items, depth + 1)];
+		case "string":
+			return schema.format === "date-time" ? new Date().toISOString() : "";
+		case "integer":
+		case "number":
+			return 0;
+		case "boolean":
+			return false;
+		}
+		return null;
+	}
+
+	function operation(path, method, description, parameters, requestBody) {
+		var inputs = {};
+		var fields = [];
+		(path.match(/\{[^}]+\}/g) || []).forEach(function (placeholder) {
+			var name = placeholder.slice(1, -1);
+			inputs[name] = element("input", {size: 40});
+			fields.push(element("label", {}, [name + " ", inputs[name]]));
+		});
+		parameters.filter(function (parameter) {
+			return parameter.in === "query";
+		}).forEach(function (parameter) {
+			inputs["?" + parameter.name] = element("input", {size: 40, title: parameter.description || ""});
+			fields.push(element("label", {}, [parameter.name + " ", inputs["?" + parameter.name]]));
+		});
+		var query = element("input", {size: 60, placeholder: "field=value&field2=value"});
+		fields.push(element("label", {}, ["query ", query]));
+		var body = null;
+		if (requestBody) {
+			body = element("textarea", {spellcheck: "false"});
+			body.value = JSON.stringify(example(requestBody, 0), null, 2);
+			fields.push(element("label", {}, ["body", body]));
+		}
+		var send = element("button", {}, ["Send"]);
+		var output = element("pre");
+		send.addEventListener("click", function () {
+			var url = root + path.replace(/\{([^}]+)\}/g, function (placeholder, name) {
+				return encodeURIComponent(inputs[name].value);
+			});
+			var search = [];
+			Object.keys(inputs).filter(function (key) {
+				return key.charAt(0) === "?" && inputs[key].value !== "";
+			}).forEach(function (key) {
+				search.push(encodeURIComponent(key.slice(1)) + "=" + encodeURIComponent(inputs[key].value));
+			});
+			if (query.value !== "") {
+				search.push(query.value);
+			}
+			if (search.length > 0) {
+				url += "?" + search.join("&");
+			}
+			var init = {method: method.toUpperCase(), headers: headers()};
+			if (body) {
+				init.headers["Content-Type"] = "application/json";
+				init.body = body.value;
+			}
+			output.textContent = init.method + " " + url + "\n…";
+			fetch(url, init).then(function (response) {
+				return response.text().then(function (text) {
+					try {
+						text = JSON.stringify(JSON.parse(text), null, 2);
+					} catch (e) {
+					}
+					output.className = response.ok ? "" : "error";
+					output.textContent = init.method + " " + url + "\n" + response.status + " " + response.statusText + "\n\n" + text;
+				});
+			}).catch(function (e) {
+				output.className = "error";
+				output.textContent = String(e);
+			});
+		});
+		fields.push(send, output);
+
+		return element("details", {}, [
+			element("summary", {}, [
+				element("span", {"class": "method " + method}, [method.toUpperCase()]),
+				path,
+				element("span", {"class": "description"}, [description])
+			]),
+			element("div", {}, fields)
+		]);
+	}
+
+	function render() {
+		document.getElementById("title").textContent = document_.info.title + " " + document_.info.version;
+		var operations = document.getElementById("operations");
+		operations.textContent = "";
+		Object.keys(document_.paths).sort().forEach(function (path) {
+			var item = document_.paths[path];
+			["get", "post", "put", "delete"].forEach(function (method) {
+				if (!item[method]) {
+					return;
+				}
+				var success = Object.keys(item[method].responses || {}).sort()[0];
+				var description = success ? item[method].responses[success].description || "" : "";
+				var parameters = (item.parameters || []).concat(item[method].parameters || []);
+				operations.appendChild(operation(path, method, description, parameters, item[method].requestBody));
+			});
+		});
+	}
+
+	function load() {
+		var operations = document.getElementById("operations");
+		fetch(root + "/meta/openapi", {headers: headers()}).then(function (response) {
+			if (!response.ok) {
+				throw new Error("loading the OpenAPI document failed with " + response.status + " " + response.statusText);
+			}
+			return response.json();
+		}).then(function (loaded) {
+			document_ = loaded;
+			render();
+		}).catch(function (e) {
+			operations.textContent = "";
+			operations.appendChild(element("p", {"class": "error"}, [String(e)]));
+		});
+	}
+
+	document.getElementById("load").addEventListener("click", load);
+	load();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,46 @@
+package storage_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+func TestDocs(t *testing.T) {
+	s, release := newStorage(t, policyEntities)
+	defer release()
+
+	// Without an authenticator which understands any credentials every request but the docs is unauthorized.
+	service := storage.Service{Storage: s, Authenticator: storage.Authenticators{}}
+
+	rw := httptest.NewRecorder()
+	service.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/meta/docs", nil))
+
+	if rw.Code != http.StatusOK || !strings.HasPrefix(rw.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected the docs as HTML, got %d %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+
+	openAPIPath := "/" + storage.Meta + "/" + storage.MetaActionOpenAPIFile
+	if !strings.Contains(rw.Body.String(), `"`+openAPIPath+`"`) {
+		t.Errorf("expected the docs to load %s", openAPIPath)
+	}
+
+	rw = httptest.NewRecorder()
+	service.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("expected the OpenAPI document to need authentication, got %d", rw.Code)
+	}
+
+	service.Anonymous = true
+
+	rw = httptest.NewRecorder()
+	service.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+
+	if rw.Code != http.StatusOK || !strings.HasPrefix(rw.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("expected the docs to point at the OpenAPI document, got %d %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+}
@@ -3,8 +3,8 @@ package storage
 type dummyUUIDGenerator struct {
 }
 
-func (g dummyUUIDGenerator) Generate() string {
-	return uuidV4Fixture
+func (g dummyUUIDGenerator) Generate(entity Entity, resource CollapsedResource) (string, error) {
+	return uuidV4Fixture, nil
 }
 
 var savedData interface{}
@@ -21,6 +21,12 @@ func (s dummyRepository) Create(collectionName string, data interface{}) error {
 	return nil
 }
 
+func (s dummyRepository) CreateAll(collectionName string, data []interface{}) error {
+	savedData = data
+
+	return nil
+}
+
 func (s dummyRepository) Read(collectionName string, id string, result interface{}) error {
 	if id == missingIDFixture {
 		return NotFound{}
@@ -1,6 +1,52 @@
 package storage
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// errorTypes are the errors whose type and details are part of error responses.
+var errorTypes = []error{
+	DBError{},
+	NotFound{},
+	Conflict{},
+	UndefinedEntity{},
+	InvalidInput{},
+	BulkAborted{},
+	BulkFailed{},
+	DanglingReferences{},
+	CardinalityViolation{},
+	Unauthenticated{},
+	NoCredentials{},
+	Forbidden{},
+	EventsExpired{},
+	HookError{},
+}
+
+// ParseError restores an error of this package from its type name and details as written in error responses.
+// It returns false if the type is unknown or the details do not match it.
+func ParseError(typeName string, details json.RawMessage) (error, bool) {
+	for _, err := range errorTypes {
+		t := reflect.TypeOf(err)
+		if t.Name() != typeName {
+			continue
+		}
+
+		value := reflect.New(t)
+		if len(details) != 0 {
+			if json.Unmarshal(details, value.Interface()) != nil {
+				return nil, false
+			}
+		}
+
+		return value.Elem().Interface().(error), true
+	}
+
+	return nil, false
+}
 
 type DBError struct {
 	Message string
@@ -18,6 +64,15 @@ func (e NotFound) Error() string {
 	return fmt.Sprintf("%q not found in %q", e.ID, e.Entity)
 }
 
+// Conflict means that a resource with the ID exists already, possibly in the trash.
+type Conflict struct {
+	Entity, ID string
+}
+
+func (e Conflict) Error() string {
+	return fmt.Sprintf("%q already exists in %q", e.ID, e.Entity)
+}
+
 type UndefinedEntity struct {
 	Entity string
 }
@@ -25,3 +80,109 @@ type UndefinedEntity struct {
 func (e UndefinedEntity) Error() string {
 	return fmt.Sprintf("entity %q is not defined", e.Entity)
 }
+
+type InvalidInput struct {
+	Message string
+}
+
+func (e InvalidInput) Error() string {
+	return fmt.Sprintf("invalid input: %s", e.Message)
+}
+
+// BulkAborted is the error of a bulk operation which was not executed because another one failed.
+type BulkAborted struct {
+}
+
+func (e BulkAborted) Error() string {
+	return "aborted because another operation of the bulk failed"
+}
+
+// BulkFailed is returned by an all-or-nothing bulk if any of its operations failed.
+type BulkFailed struct {
+	Failed int
+}
+
+func (e BulkFailed) Error() string {
+	return fmt.Sprintf("%d operations of the bulk failed, nothing was written", e.Failed)
+}
+
+// DanglingReferences lists the referenced IDs which don't exist by relation.
+type DanglingReferences struct {
+	Entity    string
+	Relations map[string][]string
+}
+
+func (e DanglingReferences) Error() string {
+	relationNames := make([]string, 0, len(e.Relations))
+	for relationName := range e.Relations {
+		relationNames = append(relationNames, relationName)
+	}
+	sort.Strings(relationNames)
+
+	descriptions := make([]string, len(relationNames))
+	for i, relationName := range relationNames {
+		descriptions[i] = fmt.Sprintf("%q references missing %q", relationName, e.Relations[relationName])
+	}
+
+	return fmt.Sprintf("dangling references in %q: %s", e.Entity, strings.Join(descriptions, ", "))
+}
+
+type CardinalityViolation struct {
+	Entity, Relation string
+	Count            int
+	Cardinality      Cardinality
+}
+
+func (e CardinalityViolation) Error() string {
+	return fmt.Sprintf("relation %q of %q has %d references but needs %s", e.Relation, e.Entity, e.Count, e.Cardinality)
+}
+
+type Unauthenticated struct {
+	Message string
+}
+
+func (e Unauthenticated) Error() string {
+	return fmt.Sprintf("unauthenticated: %s", e.Message)
+}
+
+// NoCredentials is returned by an Authenticator if a request carries none of its credentials.
+type NoCredentials struct {
+}
+
+func (e NoCredentials) Error() string {
+	return "no credentials"
+}
+
+type Forbidden struct {
+	Entity    string
+	Operation Operation
+	ID        string
+}
+
+func (e Forbidden) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("%s of %q is forbidden", e.Operation, e.Entity)
+	}
+
+	return fmt.Sprintf("%s of %q in %q is forbidden", e.Operation, e.ID, e.Entity)
+}
+
+// EventsExpired means events following Since aren't kept anymore, the oldest kept one is Oldest.
+type EventsExpired struct {
+	Since, Oldest uint64
+}
+
+func (e EventsExpired) Error() string {
+	return fmt.Sprintf("events since %d expired, the oldest is %d", e.Since, e.Oldest)
+}
+
+// HookError vetoes an operation. Status is the HTTP status of the response, 422 unless it's a 4xx one.
+type HookError struct {
+	Status  int
+	Message string
+	Details interface{}
+}
+
+func (e HookError) Error() string {
+	return e.Message
+}
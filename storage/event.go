@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event describes a mutation of a resource. Resource is the new version or, for deletes, the last one.
+type Event struct {
+	Sequence  uint64             `json:"sequence"`
+	Time      time.Time          `json:"time"`
+	Type      EventType          `json:"type"`
+	Operation Operation          `json:"operation"`
+	Entity    string             `json:"entity"`
+	ID        string             `json:"id"`
+	Principal string             `json:"principal,omitempty"`
+	Resource  *CollapsedResource `json:"resource,omitempty"`
+	// Cause is the mutation which triggered this one, e.g. the purge removing a reference.
+	Cause *AuditCause `json:"cause,omitempty"`
+}
+
+// EventBus numbers published events and passes them to its subscribers.
+// It keeps the latest events so subscribers can resume from a sequence number.
+//
+// Events only cover mutations made through a Storage publishing to the bus.
+// The mongo repository can't add changes made by other writers since mgo predates change streams.
+type EventBus struct {
+	mutex       sync.Mutex
+	sequence    uint64
+	buffer      []Event
+	capacity    int
+	subscribers map[int]func(Event)
+	nextID      int
+}
+
+// NewEventBus creates a bus keeping the given number of events for resuming subscribers.
+func NewEventBus(capacity int) *EventBus {
+	return &EventBus{capacity: capacity, subscribers: map[int]func(Event){}}
+}
+
+// WithEventBus publishes every mutation to the bus.
+func WithEventBus(bus *EventBus) Option {
+	return func(s *Storage) {
+		s.events = bus
+	}
+}
+
+// Publish assigns the next sequence number to the event and passes it to all subscribers.
+func (b *EventBus) Publish(event Event) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.sequence++
+	event.Sequence = b.sequence
+
+	b.buffer = append(b.buffer, event)
+	if len(b.buffer) > b.capacity {
+		b.buffer = b.buffer[len(b.buffer)-b.capacity:]
+	}
+
+	for _, handler := range b.subscribers {
+		handler(event)
+	}
+
+	return event
+}
+
+// Subscribe passes all events published from now on to the handler until unsubscribe is called.
+// Handlers are called in order of the events while publishing, so they must neither block nor publish.
+func (b *EventBus) Subscribe(handler func(Event)) (unsubscribe func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.subscribe(handler)
+}
+
+// SubscribeSince passes the events following the one with the given sequence number to the handler,
+// starting with the kept ones. It fails with EventsExpired if some of them aren't kept anymore.
+func (b *EventBus) SubscribeSince(sequence uint64, handler func(Event)) (unsubscribe func(), err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if sequence < b.sequence {
+		missed := b.sequence - sequence
+		if missed > uint64(len(b.buffer)) {
+			return nil, EventsExpired{Since: sequence, Oldest: b.sequence - uint64(len(b.buffer)) + 1}
+		}
+
+		for _, event := range b.buffer[len(b.buffer)-int(missed):] {
+			handler(event)
+		}
+	}
+
+	return b.subscribe(handler), nil
+}
+
+// Sequence returns the sequence number of the latest event.
+func (b *EventBus) Sequence() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.sequence
+}
+
+func (b *EventBus) subscribe(handler func(Event)) func() {
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handler
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		delete(b.subscribers, id)
+	}
+}
+
+// publish publishes a mutation if there is an event bus.
+func (s *Storage) publish(operation Operation, entityName, id string, before, after *CollapsedResource, cause *AuditCause) {
+	if s.events == nil {
+		return
+	}
+
+	event := Event{
+		Time:      time.Now().UTC(),
+		Type:      EventUpdated,
+		Operation: operation,
+		Entity:    entityName,
+		ID:        id,
+		Principal: s.principal.ID,
+		Resource:  after,
+		Cause:     cause,
+	}
+
+	switch {
+	case before == nil:
+		event.Type = EventCreated
+	case after == nil:
+		event.Type = EventDeleted
+		event.Resource = before
+	}
+
+	s.events.Publish(event)
+}
+
+// visibleEvent hides the fields of the resource of the event the principal may not read.
+// Events of resources the principal may not read aren't visible at all.
+func (s *Storage) visibleEvent(event Event) (Event, bool, error) {
+	if event.Resource == nil {
+		return event, true, nil
+	}
+
+	err := s.authorize(event.Entity, OperationRead, *event.Resource)
+	if _, ok := err.(Forbidden); ok {
+		return Event{}, false, nil
+	}
+	if err != nil {
+		return Event{}, false, err
+	}
+
+	entity := s.entities.entitiesByName[event.Entity]
+	resource, err := s.hideFields(entity, *event.Resource)
+	if err != nil {
+		return Event{}, false, err
+	}
+	event.Resource = &resource
+
+	return event, true, nil
+}
@@ -0,0 +1,187 @@
+package storage_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+var eventEntities = []storage.Entity{
+	{Name: "item", Data: reflect.TypeOf(testData{})},
+	{Name: "other", Data: reflect.TypeOf(testData{})},
+}
+
+// eventStream reads the events of a server sent event stream.
+type eventStream struct {
+	response *http.Response
+	reader   *bufio.Reader
+}
+
+func openEventStream(t *testing.T, url string, header http.Header) *eventStream {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header = header
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		response.Body.Close()
+		t.Fatalf("expected an event stream, got %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	return &eventStream{response: response, reader: bufio.NewReader(response.Body)}
+}
+
+func (e *eventStream) close() {
+	e.response.Body.Close()
+}
+
+// next reads the next event, checking that its ID and type agree with the event itself.
+func (e *eventStream) next(t *testing.T) storage.Event {
+	fields := map[string]string{}
+	for {
+		line, err := e.reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(fields) != 0 {
+			break
+		}
+		if line == "" || strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ": ", 2)
+		fields[parts[0]] = parts[1]
+	}
+
+	event := storage.Event{}
+	err := json.Unmarshal([]byte(fields["data"]), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fields["id"] != strconv.FormatUint(event.Sequence, 10) || fields["event"] != string(event.Type) {
+		t.Errorf("unexpected frame %v", fields)
+	}
+
+	return event
+}
+
+func newEventServer(t *testing.T, capacity int) (storage.Storage, *httptest.Server, func()) {
+	s, release := newStorage(t, eventEntities, storage.WithEventBus(storage.NewEventBus(capacity)))
+	server := httptest.NewServer(storage.Service{Storage: s})
+
+	return s, server, func() {
+		server.Close()
+		release()
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s, server, tearDown := newEventServer(t, 10)
+	defer tearDown()
+
+	stream := openEventStream(t, server.URL+"/meta/events?entity=item", http.Header{})
+	defer stream.close()
+
+	create(t, s, "other", "ignored")
+	id := create(t, s, "item", "first")
+	_, err := s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"second"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []struct {
+		sequence  uint64
+		eventType storage.EventType
+		name      string
+	}{{2, storage.EventCreated, "first"}, {3, storage.EventUpdated, "second"}} {
+		event := stream.next(t)
+		if event.Sequence != expected.sequence || event.Type != expected.eventType || event.Entity != "item" || event.ID != id {
+			t.Errorf("unexpected event %+v", event)
+		}
+
+		data, _ := event.Resource.Data.(map[string]interface{})
+		if data["name"] != expected.name {
+			t.Errorf("expected the resource named %q, got %+v", expected.name, event.Resource)
+		}
+	}
+}
+
+func TestEventStreamResume(t *testing.T) {
+	s, server, tearDown := newEventServer(t, 1000)
+	defer tearDown()
+
+	// More events than are buffered for live ones.
+	for i := 0; i < 300; i++ {
+		create(t, s, "item", strconv.Itoa(i))
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		first  uint64
+	}{
+		{"since", server.URL + "/meta/events?since=0", http.Header{}, 1},
+		{"Last-Event-ID", server.URL + "/meta/events", http.Header{"Last-Event-Id": {"290"}}, 291},
+	}
+
+	for i, test := range tests {
+		stream := openEventStream(t, test.url, test.header)
+
+		create(t, s, "item", "live")
+
+		for sequence := test.first; sequence <= uint64(301+i); sequence++ {
+			event := stream.next(t)
+			if event.Sequence != sequence {
+				t.Fatalf("%s: expected event %d, got %+v", test.name, sequence, event)
+			}
+		}
+
+		stream.close()
+	}
+}
+
+func TestEventStreamExpired(t *testing.T) {
+	s, server, tearDown := newEventServer(t, 10)
+	defer tearDown()
+
+	for i := 0; i < 20; i++ {
+		create(t, s, "item", strconv.Itoa(i))
+	}
+
+	for position, expected := range map[string]int{"0": http.StatusGone, "10": http.StatusOK, "x": http.StatusBadRequest} {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/meta/events?since="+position, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := http.DefaultTransport.RoundTrip(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != expected {
+			t.Errorf("since %s: expected status %d, got %d", position, expected, response.StatusCode)
+		}
+	}
+}
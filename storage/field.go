@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// FieldPermission restricts access to a field of the data of an entity.
+type FieldPermission struct {
+	// ReadRoles may read the field, everyone if there are none.
+	ReadRoles []string
+	// WriteRoles may write the field, everyone if there are none.
+	WriteRoles []string
+	// Hidden fields are never returned, ReadOnly fields are never written by clients.
+	Hidden, ReadOnly bool
+}
+
+func (p FieldPermission) readableBy(principal Principal) bool {
+	return !p.Hidden && hasAnyRole(principal, p.ReadRoles)
+}
+
+func (p FieldPermission) writableBy(principal Principal) bool {
+	return !p.ReadOnly && hasAnyRole(principal, p.WriteRoles)
+}
+
+func hasAnyRole(principal Principal, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	for _, role := range roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hideFields removes the fields the principal may not read from the data of the resource.
+// The data keeps the type of the entity, leaving hidden fields at their zero value, which data types omit
+// from their JSON by marking the fields omitempty.
+func (s *Storage) hideFields(entity Entity, resource CollapsedResource) (CollapsedResource, error) {
+	hidden := []string{}
+	for path, permission := range entity.Fields {
+		if !permission.readableBy(s.principal) {
+			hidden = append(hidden, path)
+		}
+	}
+
+	if len(hidden) == 0 {
+		return resource, nil
+	}
+
+	data, err := toDocument(resource.Data)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	for _, path := range hidden {
+		deletePath(data, path)
+	}
+
+	resource.Data, err = typedData(entity, data)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	return resource, nil
+}
+
+// typedData decodes a JSON document into the data type of the entity.
+func typedData(entity Entity, document map[string]interface{}) (interface{}, error) {
+	content, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	data := entity.New().Data
+	err = json.Unmarshal(content, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// protectFields replaces the fields of the data the principal may not write by their stored values.
+// Fields the principal may not read are kept as stored unless given, since clients never see them.
+// Without stored data protected fields are removed.
+func (s *Storage) protectFields(entity Entity, data, stored map[string]interface{}) {
+	for path, permission := range entity.Fields {
+		writable := permission.writableBy(s.principal)
+		if writable {
+			if _, ok := lookup(data, path); ok || permission.readableBy(s.principal) {
+				continue
+			}
+		}
+
+		value, ok := lookup(stored, path)
+		if !ok {
+			deletePath(data, path)
+			continue
+		}
+
+		setPath(data, path, value)
+	}
+}
+
+// protectResource applies protectFields to a resource about to replace the stored one, if any.
+func (s *Storage) protectResource(entity Entity, resource CollapsedResource, stored *CollapsedResource) (CollapsedResource, error) {
+	if len(entity.Fields) == 0 {
+		return resource, nil
+	}
+
+	data, err := toDocument(resource.Data)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	storedData := map[string]interface{}{}
+	if stored != nil {
+		storedData, err = toDocument(stored.Data)
+		if err != nil {
+			return CollapsedResource{}, err
+		}
+	}
+
+	s.protectFields(entity, data, storedData)
+
+	resource.Data, err = typedData(entity, data)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	return resource, nil
+}
+
+// protectJSON applies protectFields to the data of a JSON document.
+// Updates are authorized for the entity before the stored resource is read, so that forbidden callers cannot tell
+// which IDs exist.
+func (s *Storage) protectJSON(entity Entity, content []byte, update bool, id string) ([]byte, error) {
+	document := map[string]interface{}{}
+	err := json.Unmarshal(content, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := document["data"].(map[string]interface{})
+	if !ok {
+		data = map[string]interface{}{}
+	}
+
+	stored := map[string]interface{}{}
+	if update {
+		if id == "" {
+			id, _ = document["id"].(string)
+		}
+
+		if !s.mayExecute(entity.Name, OperationUpdate) {
+			return nil, Forbidden{Entity: entity.Name, Operation: OperationUpdate, ID: id}
+		}
+
+		resource, err := s.read(entity.Name, id)
+		if err != nil {
+			return nil, err
+		}
+
+		stored, err = toDocument(resource.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.protectFields(entity, data, stored)
+	document["data"] = data
+
+	return json.Marshal(document)
+}
+
+func setPath(document map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := document[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			document[segment] = next
+		}
+
+		document = next
+	}
+
+	document[segments[len(segments)-1]] = value
+}
+
+func deletePath(document map[string]interface{}, path string) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := document[segment].(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		document = next
+	}
+
+	delete(document, segments[len(segments)-1])
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const OperationHistory Operation = "history"
+
+// HistorySuffix is appended to the name of a versioned entity to get the collection of its revisions.
+const HistorySuffix = ".history"
+
+// Revision is a version of a resource. Versions start at 1, the latest one is the current resource.
+type Revision struct {
+	ID         string            `json:"id" bson:"_id"`
+	ResourceID string            `json:"resourceId" bson:"resourceId"`
+	Version    int               `json:"version" bson:"version"`
+	Time       time.Time         `json:"time" bson:"time"`
+	Principal  string            `json:"principal,omitempty" bson:"principal,omitempty"`
+	Resource   CollapsedResource `json:"resource" bson:"resource"`
+}
+
+func revisionID(id string, version int) string {
+	return id + "." + strconv.Itoa(version)
+}
+
+// History returns all revisions of a resource ordered by version.
+func (s *Storage) History(entityName, id string) ([]Revision, error) {
+	entity, err := s.versionedEntity(entityName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.authorizeStored(entityName, id, OperationHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.revisions(entity, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, NotFound{Entity: entityName, ID: id}
+	}
+
+	for i := range revisions {
+		revisions[i].Resource, err = s.hideFields(entity, revisions[i].Resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return revisions, nil
+}
+
+// ReadVersion reads a former version of a resource. The principal has to be allowed to read its current version.
+func (s *Storage) ReadVersion(entityName, id string, version int) (CollapsedResource, error) {
+	entity, err := s.versionedEntity(entityName)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	_, err = s.Read(entityName, id)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	revision, err := s.readRevision(entity, id, version)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	return s.hideFields(entity, revision.Resource)
+}
+
+// Restore replaces a resource by a former version of it, which becomes its latest version.
+// References to resources purged in the meantime make it fail.
+func (s *Storage) Restore(entityName, id string, version int) (CollapsedResource, error) {
+	entity, err := s.versionedEntity(entityName)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	revision, err := s.readRevision(entity, id, version)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	resource := revision.Resource
+	err = s.transaction(func(tx *Storage) error {
+		err := tx.ValidateReferences(resource)
+		if err != nil {
+			return err
+		}
+
+		return tx.replace(&resource)
+	})
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	return s.hideFields(entity, resource)
+}
+
+func (s *Storage) versionedEntity(entityName string) (Entity, error) {
+	entity, ok := s.entities.entitiesByName[entityName]
+	if !ok {
+		return Entity{}, UndefinedEntity{entityName}
+	}
+
+	if !entity.Versioned {
+		return Entity{}, InvalidInput{fmt.Sprintf("entity %q is not versioned", entityName)}
+	}
+
+	return entity, nil
+}
+
+// readRevision reads a revision with its data typed like the data of the entity.
+func (s *Storage) readRevision(entity Entity, id string, version int) (Revision, error) {
+	revision := Revision{Resource: entity.New().Collapse()}
+	err := s.repository.Read(entity.Name+HistorySuffix, revisionID(id, version), &revision)
+	if _, ok := err.(NotFound); ok {
+		return Revision{}, NotFound{Entity: entity.Name, ID: revisionID(id, version)}
+	}
+	if err != nil {
+		return Revision{}, err
+	}
+
+	revision.Resource.entity = entity
+
+	return revision, nil
+}
+
+func (s *Storage) revisions(entity Entity, id string) ([]Revision, error) {
+	query := Query{Q: map[string]FieldQuery{"resourceId": {Kind: QueryAnd, Values: []interface{}{id}}}}
+
+	revisions := []Revision{}
+	err := s.readAll(entity, entity.Name+HistorySuffix, query, &revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Version < revisions[j].Version
+	})
+
+	return revisions, nil
+}
+
+// recordRevision stores the resource as the version given by its meta section if its entity is versioned.
+// Concurrent changes of the resource fail to store the same version twice.
+func (s *Storage) recordRevision(resource CollapsedResource) error {
+	entity := resource.entity
+	if !entity.Versioned {
+		return nil
+	}
+
+	version := resource.Meta.Version
+
+	return s.repository.Create(entity.Name+HistorySuffix, Revision{
+		ID:         revisionID(resource.ID, version),
+		ResourceID: resource.ID,
+		Version:    version,
+		Time:       time.Now().UTC(),
+		Principal:  s.principal.ID,
+		Resource:   resource,
+	})
+}
+
+// deleteHistory deletes all revisions of a resource which is gone.
+func (s *Storage) deleteHistory(entityName, id string) error {
+	entity := s.entities.entitiesByName[entityName]
+	if !entity.Versioned {
+		return nil
+	}
+
+	revisions, err := s.revisions(entity, id)
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		err = s.repository.Delete(entity.Name+HistorySuffix, revision.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+var versionedEntity = storage.Entity{Name: "item", Data: reflect.TypeOf(testData{}), Versioned: true, SoftDelete: true}
+
+// createVersions creates an item named by the first name and updates it to the other ones in turn.
+func createVersions(t *testing.T, s storage.Storage, names ...string) string {
+	id := create(t, s, "item", names[0])
+	for _, name := range names[1:] {
+		_, err := s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"`+name+`"}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return id
+}
+
+func expectHistory(t *testing.T, s storage.Storage, id string, names ...string) {
+	revisions, err := s.History("item", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := []string{}
+	for i, revision := range revisions {
+		if revision.Version != i+1 || revision.Resource.Meta.Version != i+1 || revision.ResourceID != id {
+			t.Errorf("unexpected revision %d %+v", i, revision)
+		}
+
+		data, _ := revision.Resource.Data.(*testData)
+		if data == nil {
+			t.Fatalf("unexpected data %#v", revision.Resource.Data)
+		}
+		actual = append(actual, data.Name)
+	}
+
+	if !reflect.DeepEqual(actual, names) {
+		t.Errorf("expected the versions %v, got %v", names, actual)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s, release := newStorage(t, []storage.Entity{versionedEntity})
+	defer release()
+
+	id := createVersions(t, s, "first", "second", "third")
+	expectHistory(t, s, id, "first", "second", "third")
+
+	current, err := s.Read("item", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Meta.Version != 3 {
+		t.Errorf("expected the third version, got %+v", current.Meta)
+	}
+
+	for version, name := range map[int]string{1: "first", 2: "second", 3: "third"} {
+		resource, err := s.ReadVersion("item", id, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resource.Data.(*testData).Name != name || resource.Meta.Version != version {
+			t.Errorf("expected %q as version %d, got %+v", name, version, resource)
+		}
+	}
+
+	_, err = s.ReadVersion("item", id, 4)
+	if _, ok := err.(storage.NotFound); !ok {
+		t.Errorf("expected the fourth version not to be found, got %#v", err)
+	}
+
+	restored, err := s.Restore("item", id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Data.(*testData).Name != "first" || restored.Meta.Version != 4 {
+		t.Errorf("expected the first version to be restored as the fourth, got %+v", restored)
+	}
+	expectHistory(t, s, id, "first", "second", "third", "first")
+
+	err = s.Delete("item", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err = s.RestoreTrashed("item", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Meta.Version != 5 {
+		t.Errorf("expected the resource restored from the trash as the fifth version, got %+v", restored.Meta)
+	}
+	expectHistory(t, s, id, "first", "second", "third", "first", "first")
+}
+
+func TestHistoryRoutes(t *testing.T) {
+	s, release := newStorage(t, []storage.Entity{versionedEntity})
+	defer release()
+
+	server := httptest.NewServer(storage.Service{Storage: s})
+	defer server.Close()
+
+	id := createVersions(t, s, "first", "second", "third")
+
+	request := func(method, path string, out interface{}) int {
+		r, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if out != nil && response.StatusCode == http.StatusOK {
+			err = json.NewDecoder(response.Body).Decode(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return response.StatusCode
+	}
+
+	revisions := []storage.Revision{}
+	status := request(http.MethodGet, "/item/"+id+"/history", &revisions)
+	if status != http.StatusOK || len(revisions) != 3 || revisions[2].Version != 3 {
+		t.Errorf("expected the history, got %d %+v", status, revisions)
+	}
+
+	if status := request(http.MethodGet, "/item/history/"+id, nil); status != http.StatusNotFound {
+		t.Errorf("expected the history not to be found by the action route, got %d", status)
+	}
+
+	if status := request(http.MethodGet, "/item/missing/history", nil); status != http.StatusNotFound {
+		t.Errorf("expected the history of a missing resource not to be found, got %d", status)
+	}
+
+	version := storage.CollapsedResource{}
+	status = request(http.MethodGet, "/item/"+id+"?version=2", &version)
+	if status != http.StatusOK || version.Data.(map[string]interface{})["name"] != "second" {
+		t.Errorf("expected the second version, got %d %+v", status, version)
+	}
+
+	restored := storage.CollapsedResource{}
+	status = request(http.MethodPost, "/item/"+id+"/history?version=2", &restored)
+	if status != http.StatusOK || restored.Meta.Version != 4 || restored.Data.(map[string]interface{})["name"] != "second" {
+		t.Errorf("expected the second version to be restored, got %d %+v", status, restored)
+	}
+
+	if status := request(http.MethodPost, "/item/"+id+"/history?version=0", nil); status != http.StatusBadRequest {
+		t.Errorf("expected an invalid version to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Hooks add custom logic to the operations on the resources of an entity.
+// They get the storage acting on behalf of the principal, which runs within the transaction of the operation
+// if the repository is a TransactionalRepository, so that their own changes are committed along with it.
+//
+// Before hooks may change the resource and any hook may veto by returning an error, preferably a HookError.
+// Errors of after hooks roll the transaction back, if there is one.
+// Reference removals cascading from purges and soft deletes don't run hooks.
+type Hooks struct {
+	// BeforeCreate runs before the ID is assigned and the resource is authorized and validated.
+	BeforeCreate func(s *Storage, resource *CollapsedResource) error
+	AfterCreate  func(s *Storage, resource CollapsedResource) error
+	// BeforeUpdate runs before the new version is authorized.
+	BeforeUpdate func(s *Storage, stored CollapsedResource, resource *CollapsedResource) error
+	AfterUpdate  func(s *Storage, stored, resource CollapsedResource) error
+	// BeforeDelete and AfterDelete run on deletes, purges and soft deletes.
+	BeforeDelete func(s *Storage, resource CollapsedResource) error
+	AfterDelete  func(s *Storage, resource CollapsedResource) error
+	// BeforeRead runs before ReadAll and Read, which passes a query for its ID. Both return only the resources
+	// matching the query the hook may change.
+	BeforeRead func(s *Storage, query *Query) error
+	// AfterRead runs for every resource read before fields are hidden.
+	AfterRead func(s *Storage, resource *CollapsedResource) error
+}
+
+type mutation struct {
+	operation     Operation
+	entityName    string
+	id            string
+	before, after *CollapsedResource
+	cause         *AuditCause
+}
+
+// transaction runs fn with a storage whose changes are committed together if the repository is transactional.
+// Audit records stored in the repository are written within the transaction, other sinks record mutations
+// and events are published after the commit. Nested transactions join the outer one.
+func (s *Storage) transaction(fn func(tx *Storage) error) error {
+	transactional, ok := s.repository.(TransactionalRepository)
+	if !ok || s.pending != nil {
+		return fn(s)
+	}
+
+	tx := *s
+	pending := []mutation{}
+	tx.pending = &pending
+	auditsInRepository := s.auditsInRepository()
+
+	err := transactional.Transaction(func(repository Repository) error {
+		tx.repository = repository
+		if auditsInRepository {
+			tx.auditSink = RepositoryAuditSink{Repository: repository, Collection: s.auditSink.(RepositoryAuditSink).Collection}
+		}
+
+		return fn(&tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		s.committed(m)
+	}
+
+	return nil
+}
+
+func (s *Storage) beforeCreate(entity Entity, resource *CollapsedResource) error {
+	if entity.Hooks.BeforeCreate == nil {
+		return nil
+	}
+
+	return entity.Hooks.BeforeCreate(s, resource)
+}
+
+func (s *Storage) afterCreate(entity Entity, resource CollapsedResource) error {
+	if entity.Hooks.AfterCreate == nil {
+		return nil
+	}
+
+	return entity.Hooks.AfterCreate(s, resource)
+}
+
+func (s *Storage) beforeRead(entity Entity, query *Query) error {
+	if entity.Hooks.BeforeRead == nil {
+		return nil
+	}
+
+	return entity.Hooks.BeforeRead(s, query)
+}
+
+// afterRead runs the after read hook, passing the data with the type of the entity.
+func (s *Storage) afterRead(entity Entity, resource *CollapsedResource) error {
+	if entity.Hooks.AfterRead == nil {
+		return nil
+	}
+
+	if resource.entity.Name == "" {
+		content, err := json.Marshal(resource.Data)
+		if err != nil {
+			return err
+		}
+
+		typed := reflect.New(entity.Data).Interface()
+		err = json.Unmarshal(content, typed)
+		if err != nil {
+			return err
+		}
+
+		resource.Data = typed
+		resource.entity = entity
+	}
+
+	return entity.Hooks.AfterRead(s, resource)
+}
+
+// beforeDelete runs the before delete hook and returns the snapshot of the resource
+// if it is needed by the after delete hook, the audit log or events.
+func (s *Storage) beforeDelete(entity Entity, id string) (*CollapsedResource, error) {
+	if entity.Hooks.BeforeDelete == nil && entity.Hooks.AfterDelete == nil {
+		return s.snapshot(entity.Name, id)
+	}
+
+	resource, err := s.read(entity.Name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entity.Hooks.BeforeDelete != nil {
+		err = entity.Hooks.BeforeDelete(s, resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resource, nil
+}
+
+func (s *Storage) afterDelete(entity Entity, resource *CollapsedResource) error {
+	if entity.Hooks.AfterDelete == nil {
+		return nil
+	}
+
+	return entity.Hooks.AfterDelete(s, *resource)
+}
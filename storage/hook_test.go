@@ -0,0 +1,186 @@
+package storage_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// hookProbe is installed as every hook of the items. Once armed the hooks log their call as a resource,
+// note the name of the item they can read through their storage and veto the operation if veto is set.
+type hookProbe struct {
+	hook   string
+	armed  bool
+	veto   bool
+	itemID string
+	seen   string
+}
+
+func (p *hookProbe) run(t *testing.T, s *storage.Storage, hook string) error {
+	if !p.armed || p.hook != hook {
+		return nil
+	}
+
+	_, err := s.CreateFromJSON("log", `{"data":{"name":"`+hook+`"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.seen = "not found"
+	if p.itemID != "" {
+		item, err := s.Read("item", p.itemID)
+		if err == nil {
+			p.seen = item.Data.(*testData).Name
+		}
+	}
+
+	if p.veto {
+		return storage.HookError{Message: "vetoed by " + hook}
+	}
+
+	return nil
+}
+
+func (p *hookProbe) hooks(t *testing.T) storage.Hooks {
+	return storage.Hooks{
+		BeforeCreate: func(s *storage.Storage, resource *storage.CollapsedResource) error {
+			return p.run(t, s, "BeforeCreate")
+		},
+		AfterCreate: func(s *storage.Storage, resource storage.CollapsedResource) error {
+			p.itemID = resource.ID
+			return p.run(t, s, "AfterCreate")
+		},
+		BeforeUpdate: func(s *storage.Storage, stored storage.CollapsedResource, resource *storage.CollapsedResource) error {
+			return p.run(t, s, "BeforeUpdate")
+		},
+		AfterUpdate: func(s *storage.Storage, stored, resource storage.CollapsedResource) error {
+			return p.run(t, s, "AfterUpdate")
+		},
+		BeforeDelete: func(s *storage.Storage, resource storage.CollapsedResource) error {
+			return p.run(t, s, "BeforeDelete")
+		},
+		AfterDelete: func(s *storage.Storage, resource storage.CollapsedResource) error {
+			return p.run(t, s, "AfterDelete")
+		},
+	}
+}
+
+func TestHookTransactions(t *testing.T) {
+	tests := []struct {
+		hook string
+		veto bool
+		// seen is the name of the item the hook reads through its storage.
+		seen string
+	}{
+		{"BeforeCreate", true, "not found"},
+		{"AfterCreate", true, "created"},
+		{"BeforeUpdate", true, "first"},
+		{"AfterUpdate", true, "updated"},
+		{"BeforeDelete", true, "first"},
+		{"AfterDelete", true, "not found"},
+		{"AfterCreate", false, "created"},
+		{"AfterUpdate", false, "updated"},
+		{"AfterDelete", false, "not found"},
+	}
+
+	for _, test := range tests {
+		probe := &hookProbe{hook: test.hook, veto: test.veto}
+		entities := []storage.Entity{
+			{Name: "item", Data: reflect.TypeOf(testData{}), Hooks: probe.hooks(t)},
+			{Name: "log", Data: reflect.TypeOf(testData{})},
+		}
+
+		repository, release := openRepository(t)
+		s := newStorageWith(t, repository, entities, storage.WithAuditSink(storage.RepositoryAuditSink{Repository: repository}))
+
+		id := create(t, s, "item", "first")
+		probe.armed = true
+		probe.itemID = id
+
+		expected := map[string]string{id: "first"}
+		var err error
+		switch test.hook {
+		case "BeforeCreate", "AfterCreate":
+			probe.itemID = ""
+			var created storage.CollapsedResource
+			created, err = s.CreateFromJSON("item", `{"data":{"name":"created"}}`)
+			if err == nil {
+				expected[created.ID] = "created"
+			}
+		case "BeforeUpdate", "AfterUpdate":
+			_, err = s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"updated"}}`)
+			if err == nil {
+				expected[id] = "updated"
+			}
+		case "BeforeDelete", "AfterDelete":
+			err = s.Delete("item", id)
+			if err == nil {
+				delete(expected, id)
+			}
+		}
+
+		if _, ok := err.(storage.HookError); test.veto && !ok {
+			t.Errorf("%s: expected the veto of the hook, got %#v", test.hook, err)
+		}
+		if !test.veto && err != nil {
+			t.Errorf("%s: unexpected error %v", test.hook, err)
+		}
+
+		if probe.seen != test.seen {
+			t.Errorf("%s: expected the hook to read %q through the transaction, got %q", test.hook, test.seen, probe.seen)
+		}
+
+		if names := readNames(t, s, "item"); !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected the items %v, got %v", test.hook, expected, names)
+		}
+
+		logs := readNames(t, s, "log")
+		records := []storage.AuditRecord{}
+		err = repository.ReadAll(storage.AuditCollection, storage.Query{}, &records)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.veto && (len(logs) != 0 || len(records) != 1) {
+			t.Errorf("%s: expected the changes of the vetoed operation to be rolled back, got the logs %v and %d audit records", test.hook, logs, len(records))
+		}
+		if !test.veto && (len(logs) != 1 || len(records) != 3) {
+			t.Errorf("%s: expected the changes of the hook to be committed, got the logs %v and %d audit records", test.hook, logs, len(records))
+		}
+
+		release()
+	}
+}
+
+func TestBeforeReadQuery(t *testing.T) {
+	hooks := storage.Hooks{
+		BeforeRead: func(s *storage.Storage, query *storage.Query) error {
+			if query.Q == nil {
+				query.Q = map[string]storage.FieldQuery{}
+			}
+			query.Q["data.name"] = storage.FieldQuery{Kind: storage.QueryAnd, Values: []interface{}{"public"}}
+
+			return nil
+		},
+	}
+	s, release := newStorage(t, []storage.Entity{{Name: "item", Data: reflect.TypeOf(testData{}), Hooks: hooks}})
+	defer release()
+
+	public := create(t, s, "item", "public")
+	private := create(t, s, "item", "private")
+
+	if names := readNames(t, s, "item"); !reflect.DeepEqual(names, map[string]string{public: "public"}) {
+		t.Errorf("expected ReadAll to apply the query of the hook, got %v", names)
+	}
+
+	resource, err := s.Read("item", public)
+	if err != nil || resource.ID != public {
+		t.Errorf("expected to read the public item, got %+v %v", resource, err)
+	}
+
+	_, err = s.Read("item", private)
+	if err != (storage.NotFound{Entity: "item", ID: private}) {
+		t.Errorf("expected Read to apply the query of the hook, got %#v", err)
+	}
+}
@@ -2,12 +2,39 @@ package storage
 
 type Repository interface {
 	Create(collectionName string, data interface{}) error
+	CreateAll(collectionName string, data []interface{}) error
 	Read(collectionName string, id string, result interface{}) error
 	Update(collectionName string, id string, data interface{}) error
 	Delete(collectionName string, id string) error
 	ReadAll(collectionName string, query Query, result interface{}) error
 }
 
+// TransactionalRepository is a repository which can group changes.
+type TransactionalRepository interface {
+	Repository
+	// Transaction runs fn with a repository whose changes are committed if fn returns nil and rolled back otherwise.
+	// The error of fn is returned as is.
+	Transaction(fn func(Repository) error) error
+}
+
+// JSONRepository is a repository storing the JSON encoding of documents,
+// whose queries hence refer to fields by their JSON names like the conditions of policies do.
+// Policies restrict the queries of other repositories by filtering the resources they read.
+type JSONRepository interface {
+	Repository
+	JSONEncoded()
+}
+
+// IDGenerator generates the ID of a new resource of the entity.
+// The resource carries the ID supplied by the client, if any, which most generators ignore.
 type IDGenerator interface {
-	Generate() string
+	Generate(entity Entity, resource CollapsedResource) (string, error)
+}
+
+// RepositoryIDGenerator is an IDGenerator keeping its state in a repository.
+// Storages pass their repository, which is the transaction of the operation if there is one,
+// so that the state changes along with the resources.
+type RepositoryIDGenerator interface {
+	IDGenerator
+	WithRepository(repository Repository) IDGenerator
 }
@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+func TestMetadata(t *testing.T) {
+	s, release := newStorage(t, []storage.Entity{{Name: "item", Data: reflect.TypeOf(testData{})}})
+	defer release()
+
+	forged := `"meta":{"createdAt":"2000-01-01T00:00:00Z","updatedAt":"2000-01-01T00:00:00Z","createdBy":"mallory","updatedBy":"mallory","version":7}`
+	start := time.Now().UTC().Truncate(time.Second)
+
+	alice := s.As(storage.Principal{ID: "alice"})
+	created, err := alice.CreateFromJSON("item", `{"data":{"name":"first"},`+forged+`}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := created.Meta
+	if meta.CreatedBy != "alice" || meta.UpdatedBy != "alice" || meta.CreatedAt.Before(start) || !meta.UpdatedAt.Equal(meta.CreatedAt) || meta.Version != 0 {
+		t.Errorf("expected the meta section of a resource created by alice, got %+v", meta)
+	}
+
+	expectMeta := func(name string, principal storage.Principal, update func(s storage.Storage) error) {
+		err := update(s.As(principal))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		stored, err := s.Read("item", created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := stored.Meta
+		if !actual.CreatedAt.Equal(meta.CreatedAt) || actual.CreatedBy != "alice" {
+			t.Errorf("%s: expected the resource to stay created by alice at %v, got %+v", name, meta.CreatedAt, actual)
+		}
+		if actual.UpdatedBy != principal.ID || actual.UpdatedAt.Before(meta.CreatedAt) {
+			t.Errorf("%s: expected the resource to be updated by %q, got %+v", name, principal.ID, actual)
+		}
+	}
+
+	expectMeta("update from JSON", storage.Principal{ID: "bob"}, func(s storage.Storage) error {
+		_, err := s.UpdateFromJSON("item", `{"id":"`+created.ID+`","data":{"name":"second"},`+forged+`}`)
+		return err
+	})
+
+	expectMeta("update", storage.Principal{ID: "carol"}, func(s storage.Storage) error {
+		resource, err := s.Read("item", created.ID)
+		if err != nil {
+			return err
+		}
+
+		resource.Meta = storage.Metadata{CreatedBy: "mallory", UpdatedBy: "mallory"}
+
+		return s.Update(resource)
+	})
+
+	expectMeta("anonymous update", storage.Principal{}, func(s storage.Storage) error {
+		_, err := s.UpdateFromJSON("item", `{"id":"`+created.ID+`","data":{"name":"third"}}`)
+		return err
+	})
+
+	create(t, s.As(storage.Principal{ID: "bob"}), "item", "other")
+
+	query := storage.Query{Q: map[string]storage.FieldQuery{"meta.createdBy": {Kind: storage.QueryAnd, Values: []interface{}{"alice"}}}}
+	resources, err := s.ReadAll("item", query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].ID != created.ID {
+		t.Errorf("expected the resources created by alice, got %+v", resources)
+	}
+}
@@ -0,0 +1,591 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// errorResponses describes the error responses by status code.
+var errorResponses = map[int]string{
+	http.StatusBadRequest:          "The input is invalid",
+	http.StatusUnauthorized:        "The request is not authenticated",
+	http.StatusForbidden:           "The principal may not perform the operation",
+	http.StatusNotFound:            "The entity or resource does not exist",
+	http.StatusConflict:            "The resource conflicts with a stored one or a hook vetoed",
+	http.StatusGone:                "The events to resume from are not kept anymore",
+	http.StatusUnprocessableEntity: "The references are invalid or a hook vetoed",
+}
+
+const (
+	bulkDescription         = "Creates, updates and deletes many resources. All creates are inserted at once before the updates and deletes run in their given order."
+	bulkAtomicDescription   = "Writes nothing unless every operation succeeds. Needs a transactional repository."
+	bulkStatusDescription   = "The status of the operation like that of a single request, 424 if it was not executed because another operation of an atomic bulk failed"
+	eventsDescription       = "Streams the mutations of the resources as server-sent events. Not found unless the storage publishes events."
+	eventsSinceDescription  = "The sequence number of the last received event to resume after, which the Last-Event-ID header may give instead"
+	eventsEntityDescription = "The entities whose events are streamed, all if there are none"
+
+	versionDescription        = "The version to read instead of the current one if the entity is versioned"
+	restoreVersionDescription = "Restores the given version as the newest one."
+	auditDescription          = "The audit trail of a resource, which may be deleted already. Not found unless the storage audits its mutations."
+	restoreTrashedDescription = "Restores a deleted resource together with the references to it of the resources still existing."
+	purgeTrashedDescription   = "Deletes a deleted resource for good."
+
+	openAPIFormatDescription = "The format of the document, which the Accept header may give instead"
+	docsDescription          = "The API explorer, served without authentication."
+	webhooksDescription      = "Not found unless the service manages webhooks. Webhooks deliver the events their creator or last updater may read."
+	webhookUpdateDescription = "Replaces the webhook, keeping its secret unless a new one is given."
+	redeliverDescription     = "Delivers the event of the dead letter again with fresh attempts."
+)
+
+// CreateOpenAPIDocument describes the API in OpenAPI 3.1 using the schemas of the swagger file.
+// Pointers are nullable and hidden fields are write only. The server is omitted if it is empty.
+func CreateOpenAPIDocument(entities Entities, info Info, server string) (map[string]interface{}, error) {
+	paths := openAPIMetaPaths()
+
+	errorSchema, err := createErrorSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := map[string]interface{}{"Error": errorSchema}
+	requestBodies := map[string]interface{}{
+		"Webhook": map[string]interface{}{
+			"description": "The webhook",
+			"required":    true,
+			"content":     jsonContent(openAPIReference("schemas", "Webhook")),
+		},
+	}
+
+	serviceDefinitions := map[string]interface{}{}
+	err = addServiceDefinitions(serviceDefinitions)
+	if err != nil {
+		return nil, err
+	}
+	for name, definition := range serviceDefinitions {
+		schemas[name] = toOpenAPISchema(definition, "#/components/schemas/")
+	}
+
+	for _, entity := range entities.All() {
+		entityName := entity.Name
+
+		definitions := map[string]interface{}{}
+		err := addEntityDefinitions(definitions, entities, entity)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, definition := range definitions {
+			schemas[name] = toOpenAPISchema(definition, "#/components/schemas/")
+		}
+
+		schemaReference := openAPIReference("schemas", entityName)
+		requestBodies[entityName] = map[string]interface{}{
+			"description": "The " + entityName,
+			"required":    true,
+			"content":     jsonContent(schemaReference),
+		}
+		requestBody := openAPIReference("requestBodies", entityName)
+
+		pathParameterName := entityName + "Id"
+		pathParameters := []interface{}{
+			map[string]interface{}{
+				"name":        pathParameterName,
+				"in":          "path",
+				"description": "ID of the " + entityName,
+				"required":    true,
+				"schema":      map[string]interface{}{"type": "string"},
+			},
+		}
+
+		paths["/"+entityName] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "All "+entityName, map[string]interface{}{
+					"type":  "array",
+					"items": schemaReference,
+				}),
+			},
+			"post": map[string]interface{}{
+				"requestBody": requestBody,
+				"responses":   openAPIResponses("200", "The created "+entityName, schemaReference, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity),
+			},
+		}
+
+		paths["/"+entityName+"/{"+pathParameterName+"}"] = map[string]interface{}{
+			"parameters": pathParameters,
+			"get": map[string]interface{}{
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "version",
+						"in":          "query",
+						"description": versionDescription,
+						"schema":      map[string]interface{}{"type": "integer", "minimum": 1},
+					},
+				},
+				"responses": openAPIResponses("200", "A single "+entityName, schemaReference, http.StatusBadRequest, http.StatusNotFound),
+			},
+			"put": map[string]interface{}{
+				"requestBody": requestBody,
+				"responses":   openAPIResponses("200", "The updated "+entityName, schemaReference, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
+			},
+			"delete": map[string]interface{}{
+				"responses": openAPIResponses("204", "No content", nil, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
+			},
+		}
+
+		paths[fmt.Sprintf("/%s/%s/{%s}", entityName, ActionExpand, pathParameterName)] = map[string]interface{}{
+			"parameters": pathParameters,
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "The expanded "+entityName, openAPIReference("schemas", entityName+"Expanded"), http.StatusNotFound),
+			},
+		}
+
+		paths[fmt.Sprintf("/%s/%s/{%s}", entityName, ActionReferencedBy, pathParameterName)] = map[string]interface{}{
+			"parameters": pathParameters,
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "The references to "+entityName, openAPIReference("schemas", entityName+"ReferencedBy"), http.StatusNotFound),
+			},
+		}
+
+		paths[fmt.Sprintf("/%s/%s/{%s}", entityName, ActionAudit, pathParameterName)] = map[string]interface{}{
+			"parameters": pathParameters,
+			"get": map[string]interface{}{
+				"description": auditDescription,
+				"responses": openAPIResponses("200", "The audit trail of the "+entityName, map[string]interface{}{
+					"type":  "array",
+					"items": openAPIReference("schemas", entityName+"AuditRecord"),
+				}, http.StatusNotFound),
+			},
+		}
+
+		if entity.Versioned {
+			paths[fmt.Sprintf("/%s/{%s}/%s", entityName, pathParameterName, ActionHistory)] = map[string]interface{}{
+				"parameters": pathParameters,
+				"get": map[string]interface{}{
+					"responses": openAPIResponses("200", "The versions of the "+entityName, map[string]interface{}{
+						"type":  "array",
+						"items": openAPIReference("schemas", entityName+"Revision"),
+					}, http.StatusNotFound),
+				},
+				"post": map[string]interface{}{
+					"description": restoreVersionDescription,
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "version",
+							"in":       "query",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "integer", "minimum": 1},
+						},
+					},
+					"responses": openAPIResponses("200", "The restored "+entityName, schemaReference, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
+				},
+			}
+		}
+
+		if entity.SoftDelete {
+			trashedReference := openAPIReference("schemas", entityName+"Trashed")
+
+			paths[fmt.Sprintf("/%s/%s", entityName, ActionTrash)] = map[string]interface{}{
+				"get": map[string]interface{}{
+					"responses": openAPIResponses("200", "The deleted "+entityName, map[string]interface{}{
+						"type":  "array",
+						"items": trashedReference,
+					}),
+				},
+			}
+
+			paths[fmt.Sprintf("/%s/%s/{%s}", entityName, ActionTrash, pathParameterName)] = map[string]interface{}{
+				"parameters": pathParameters,
+				"get": map[string]interface{}{
+					"responses": openAPIResponses("200", "A deleted "+entityName, trashedReference, http.StatusNotFound),
+				},
+				"post": map[string]interface{}{
+					"description": restoreTrashedDescription,
+					"responses":   openAPIResponses("200", "The restored "+entityName, schemaReference, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
+				},
+				"delete": map[string]interface{}{
+					"description": purgeTrashedDescription,
+					"responses":   openAPIResponses("204", "No content", nil, http.StatusNotFound),
+				},
+			}
+		}
+
+		paths[fmt.Sprintf("/%s/%s", entityName, ActionBulk)] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"description": bulkDescription,
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "atomic",
+						"in":          "query",
+						"description": bulkAtomicDescription,
+						"schema":      map[string]interface{}{"type": "boolean"},
+					},
+				},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": jsonContent(map[string]interface{}{
+						"type":  "array",
+						"items": bulkOperationSchema(schemaReference),
+					}),
+				},
+				"responses": openAPIResponses("200", "The results of the operations", map[string]interface{}{
+					"type":  "array",
+					"items": bulkResultSchema(schemaReference),
+				}, http.StatusBadRequest, http.StatusUnprocessableEntity),
+			},
+		}
+	}
+
+	responses := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"description": "Unexpected error",
+			"content":     jsonContent(openAPIReference("schemas", "Error")),
+		},
+	}
+	for status, description := range errorResponses {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": description,
+			"content":     jsonContent(openAPIReference("schemas", "Error")),
+		}
+	}
+
+	document := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"version": info.Version,
+			"title":   info.Title,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":       schemas,
+			"requestBodies": requestBodies,
+			"responses":     responses,
+		},
+	}
+
+	if server != "" {
+		document["servers"] = []interface{}{map[string]interface{}{"url": server}}
+	}
+
+	return document, nil
+}
+
+// openAPIMetaPaths describes the paths below Meta.
+func openAPIMetaPaths() map[string]interface{} {
+	webhookReference := openAPIReference("schemas", "Webhook")
+	webhookBody := openAPIReference("requestBodies", "Webhook")
+	webhookIDParameters := []interface{}{
+		map[string]interface{}{
+			"name":        "webhookId",
+			"in":          "path",
+			"description": "ID of the webhook",
+			"required":    true,
+			"schema":      map[string]interface{}{"type": "string"},
+		},
+	}
+
+	return map[string]interface{}{
+		fmt.Sprintf("/%s/%s", Meta, MetaActionSwaggerFile): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The swagger file",
+						"content":     map[string]interface{}{"application/json": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionOpenAPIFile): map[string]interface{}{
+			"get": map[string]interface{}{
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "format",
+						"in":          "query",
+						"description": openAPIFormatDescription,
+						"schema":      map[string]interface{}{"enum": []interface{}{"json", "yaml"}},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "This OpenAPI document as JSON or, if accepted, as YAML",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{},
+							"application/yaml": map[string]interface{}{},
+						},
+					},
+					"406": map[string]interface{}{"description": "Neither JSON nor YAML is accepted"},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionTypeScript): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The TypeScript client",
+						"content":     map[string]interface{}{"application/typescript": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionDocs): map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": docsDescription,
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The API explorer",
+						"content":     map[string]interface{}{"text/html": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionEntities): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "The descriptions of all entities", map[string]interface{}{
+					"type":  "array",
+					"items": openAPIReference("schemas", "EntityDescription"),
+				}),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{entityName}", Meta, MetaActionEntities): map[string]interface{}{
+			"parameters": []interface{}{
+				map[string]interface{}{
+					"name":     "entityName",
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				},
+			},
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "The description of the entity", openAPIReference("schemas", "EntityDescription"), http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionEvents): map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": eventsDescription,
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "since",
+						"in":          "query",
+						"description": eventsSinceDescription,
+						"schema":      map[string]interface{}{"type": "integer"},
+					},
+					map[string]interface{}{
+						"name":        "entity",
+						"in":          "query",
+						"description": eventsEntityDescription,
+						"schema":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+					},
+				},
+				"responses": openAPIResponses("200", "The events", nil, http.StatusBadRequest, http.StatusNotFound, http.StatusGone),
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionWebhooks): map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": webhooksDescription,
+				"responses": openAPIResponses("200", "All webhooks", map[string]interface{}{
+					"type":  "array",
+					"items": webhookReference,
+				}, http.StatusNotFound),
+			},
+			"post": map[string]interface{}{
+				"description": webhooksDescription,
+				"requestBody": webhookBody,
+				"responses":   openAPIResponses("200", "The created webhook", webhookReference, http.StatusBadRequest, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{webhookId}", Meta, MetaActionWebhooks): map[string]interface{}{
+			"parameters": webhookIDParameters,
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "A single webhook", webhookReference, http.StatusNotFound),
+			},
+			"put": map[string]interface{}{
+				"description": webhookUpdateDescription,
+				"requestBody": webhookBody,
+				"responses":   openAPIResponses("200", "The updated webhook", webhookReference, http.StatusBadRequest, http.StatusNotFound),
+			},
+			"delete": map[string]interface{}{
+				"responses": openAPIResponses("204", "No content", nil, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{webhookId}", Meta, MetaActionWebhookDeliveries): map[string]interface{}{
+			"parameters": webhookIDParameters,
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "The delivery attempts of the webhook in chronological order", map[string]interface{}{
+					"type":  "array",
+					"items": openAPIReference("schemas", "WebhookDelivery"),
+				}, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionWebhookDeadLetters): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": openAPIResponses("200", "The events no attempt delivered", map[string]interface{}{
+					"type":  "array",
+					"items": openAPIReference("schemas", "WebhookDeadLetter"),
+				}, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{deadLetterId}", Meta, MetaActionWebhookDeadLetters): map[string]interface{}{
+			"parameters": []interface{}{
+				map[string]interface{}{
+					"name":        "deadLetterId",
+					"in":          "path",
+					"description": "ID of the dead letter",
+					"required":    true,
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
+			"post": map[string]interface{}{
+				"description": redeliverDescription,
+				"responses":   openAPIResponses("202", "Accepted", nil, http.StatusNotFound),
+			},
+		},
+	}
+}
+
+// toOpenAPISchema converts a swagger definition into a JSON schema of OpenAPI 3.1,
+// which expresses nullable values by their types and has write only fields.
+// References to swagger definitions are prefixed by definitionsPrefix instead.
+func toOpenAPISchema(definition interface{}, definitionsPrefix string) interface{} {
+	switch definition := definition.(type) {
+	case map[string]interface{}:
+		schema := make(map[string]interface{}, len(definition))
+		for k, v := range definition {
+			schema[k] = toOpenAPISchema(v, definitionsPrefix)
+		}
+
+		if reference, ok := schema["$ref"].(string); ok && strings.HasPrefix(reference, "#/definitions/") {
+			schema["$ref"] = definitionsPrefix + strings.TrimPrefix(reference, "#/definitions/")
+		}
+
+		if nullable, _ := schema["x-nullable"].(bool); nullable {
+			delete(schema, "x-nullable")
+			if t, ok := schema["type"].(string); ok {
+				schema["type"] = []interface{}{t, "null"}
+			} else if reference, ok := schema["$ref"]; ok {
+				delete(schema, "$ref")
+				schema["anyOf"] = []interface{}{map[string]interface{}{"$ref": reference}, map[string]interface{}{"type": "null"}}
+			}
+		}
+
+		if writeOnly, ok := schema["x-writeOnly"]; ok {
+			delete(schema, "x-writeOnly")
+			schema["writeOnly"] = writeOnly
+		}
+
+		return schema
+	case []interface{}:
+		items := make([]interface{}, len(definition))
+		for i, v := range definition {
+			items[i] = toOpenAPISchema(v, definitionsPrefix)
+		}
+
+		return items
+	}
+
+	return definition
+}
+
+// createErrorSchema describes error responses, one of which exists for every error type.
+func createErrorSchema() (interface{}, error) {
+	variants := []interface{}{}
+	for _, err := range errorTypes {
+		name := reflect.TypeOf(err).Name()
+
+		definition, createErr := CreateSwaggerDefinition(err)
+		if createErr != nil {
+			return nil, createErr
+		}
+
+		variants = append(variants, map[string]interface{}{
+			"type":     "object",
+			"required": []interface{}{"error", "type"},
+			"properties": map[string]interface{}{
+				"error":   map[string]interface{}{"type": "string"},
+				"type":    map[string]interface{}{"const": name},
+				"details": toOpenAPISchema(definition, "#/components/schemas/"),
+			},
+		})
+	}
+
+	variants = append(variants, map[string]interface{}{
+		"type":                 "object",
+		"required":             []interface{}{"error"},
+		"properties":           map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+		"additionalProperties": false,
+	})
+
+	return map[string]interface{}{
+		"description": "An error, with its type and details if it is one of the storage",
+		"oneOf":       variants,
+	}, nil
+}
+
+func bulkOperationSchema(resource interface{}) interface{} {
+	operation := func(op string, properties map[string]interface{}, required ...interface{}) interface{} {
+		properties["op"] = map[string]interface{}{"const": op}
+
+		return map[string]interface{}{
+			"type":       "object",
+			"required":   append([]interface{}{"op"}, required...),
+			"properties": properties,
+		}
+	}
+
+	id := map[string]interface{}{"type": "string"}
+
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			operation(BulkCreate, map[string]interface{}{"resource": resource}, "resource"),
+			operation(BulkUpdate, map[string]interface{}{"id": id, "resource": resource}, "resource"),
+			operation(BulkDelete, map[string]interface{}{"id": id}, "id"),
+		},
+		"discriminator": map[string]interface{}{"propertyName": "op"},
+	}
+}
+
+func bulkResultSchema(resource interface{}) interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"index", "op", "status"},
+		"properties": map[string]interface{}{
+			"index":    map[string]interface{}{"type": "integer"},
+			"op":       map[string]interface{}{"enum": []interface{}{BulkCreate, BulkUpdate, BulkDelete}},
+			"id":       map[string]interface{}{"type": "string"},
+			"status":   map[string]interface{}{"type": "integer", "description": bulkStatusDescription},
+			"error":    map[string]interface{}{"type": "string"},
+			"resource": resource,
+		},
+	}
+}
+
+// openAPIResponses describes a successful response with an optional schema and the given error responses.
+func openAPIResponses(status, description string, schema interface{}, errorStatuses ...int) map[string]interface{} {
+	success := map[string]interface{}{"description": description}
+	if schema != nil {
+		success["content"] = jsonContent(schema)
+	}
+
+	responses := map[string]interface{}{
+		status:    success,
+		"401":     openAPIReference("responses", "401"),
+		"403":     openAPIReference("responses", "403"),
+		"default": openAPIReference("responses", "Error"),
+	}
+	for _, errorStatus := range errorStatuses {
+		responses[strconv.Itoa(errorStatus)] = openAPIReference("responses", strconv.Itoa(errorStatus))
+	}
+
+	return responses
+}
+
+func openAPIReference(component, name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/" + component + "/" + name}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
@@ -0,0 +1,200 @@
+package storage
+
+import "encoding/json"
+
+type Operation string
+
+const (
+	OperationRead         Operation = "read"
+	OperationList         Operation = "list"
+	OperationCreate       Operation = "create"
+	OperationUpdate       Operation = "update"
+	OperationDelete       Operation = "delete"
+	OperationPurge        Operation = "purge"
+	OperationExpand       Operation = "expand"
+	OperationReferencedBy Operation = "referenced-by"
+)
+
+// AnyEntity is the entity name of rules which apply to all entities.
+const AnyEntity = "*"
+
+// Policy maps entity names and operations to the rules granting them.
+// Operations without a matching rule are denied.
+type Policy map[string]map[Operation][]Rule
+
+// Rule grants an operation to principals having any of its roles or, without roles, to everyone.
+// Where restricts it to resources whose field at the given path equals an attribute of the principal,
+// e.g. {"data.ownerId": "id"}. Attributes are "id" and the names of the principal's claims.
+type Rule struct {
+	Roles []string
+	Where map[string]string
+}
+
+// rules returns the rules granting the operation to the principal regardless of the resource.
+func (p Policy) rules(entityName string, operation Operation, principal Principal) []Rule {
+	rules := []Rule{}
+	for _, name := range []string{entityName, AnyEntity} {
+		for _, rule := range p[name][operation] {
+			if rule.appliesTo(principal) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules
+}
+
+func (r Rule) appliesTo(principal Principal) bool {
+	return hasAnyRole(principal, r.Roles)
+}
+
+// query returns the conditions of Where as query or false if an attribute is missing.
+func (r Rule) query(principal Principal) (Query, bool) {
+	query := Query{Q: make(map[string]FieldQuery, len(r.Where))}
+	for path, attribute := range r.Where {
+		value, ok := principal.attribute(attribute)
+		if !ok {
+			return Query{}, false
+		}
+
+		query.Q[path] = FieldQuery{Kind: QueryAnd, Values: []interface{}{value}}
+	}
+
+	return query, true
+}
+
+func (r Rule) allows(principal Principal, document map[string]interface{}) bool {
+	query, ok := r.query(principal)
+
+	return ok && query.Match(document)
+}
+
+func (p Principal) attribute(name string) (interface{}, bool) {
+	if name == "id" {
+		return p.ID, !p.Anonymous()
+	}
+
+	value, ok := p.Claims[name]
+
+	return value, ok
+}
+
+// mayExecute reports whether the principal may execute the operation on some resources of the entity.
+func (s *Storage) mayExecute(entityName string, operation Operation) bool {
+	return s.policy == nil || len(s.policy.rules(entityName, operation, s.principal)) != 0
+}
+
+// authorize checks whether the principal may execute the operation on the resource.
+func (s *Storage) authorize(entityName string, operation Operation, resource CollapsedResource) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	forbidden := Forbidden{Entity: entityName, Operation: operation, ID: resource.ID}
+
+	rules := s.policy.rules(entityName, operation, s.principal)
+	if len(rules) == 0 {
+		return forbidden
+	}
+
+	var document map[string]interface{}
+	for _, rule := range rules {
+		if len(rule.Where) == 0 {
+			return nil
+		}
+
+		if document == nil {
+			var err error
+			document, err = toDocument(resource)
+			if err != nil {
+				return err
+			}
+		}
+
+		if rule.allows(s.principal, document) {
+			return nil
+		}
+	}
+
+	return forbidden
+}
+
+// authorizeList restricts the query to the resources the principal may list.
+// If the restriction cannot be expressed as query of the repository the returned filter has to be applied to the result.
+func (s *Storage) authorizeList(entityName string, query Query) (Query, func(CollapsedResource) (bool, error), error) {
+	if s.policy == nil {
+		return query, nil, nil
+	}
+
+	rules := s.policy.rules(entityName, OperationList, s.principal)
+	if len(rules) == 0 {
+		return Query{}, nil, Forbidden{Entity: entityName, Operation: OperationList}
+	}
+
+	for _, rule := range rules {
+		if len(rule.Where) == 0 {
+			return query, nil, nil
+		}
+	}
+
+	if _, ok := s.repository.(JSONRepository); ok && len(rules) == 1 {
+		if restricted, ok := restrictQuery(query, rules[0], s.principal); ok {
+			return restricted, nil, nil
+		}
+	}
+
+	filter := func(resource CollapsedResource) (bool, error) {
+		document, err := toDocument(resource)
+		if err != nil {
+			return false, err
+		}
+
+		for _, rule := range rules {
+			if rule.allows(s.principal, document) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	return query, filter, nil
+}
+
+// restrictQuery adds the conditions of the rule to the query if they don't collide with its fields.
+func restrictQuery(query Query, rule Rule, principal Principal) (Query, bool) {
+	conditions, ok := rule.query(principal)
+	if !ok {
+		return Query{}, false
+	}
+
+	restricted := Query{Q: make(map[string]FieldQuery, len(query.Q)+len(conditions.Q))}
+	for k, v := range query.Q {
+		restricted.Q[k] = v
+	}
+
+	for k, v := range conditions.Q {
+		if _, ok := restricted.Q[k]; ok {
+			return Query{}, false
+		}
+
+		restricted.Q[k] = v
+	}
+
+	return restricted, true
+}
+
+func toDocument(v interface{}) (map[string]interface{}, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	document := map[string]interface{}{}
+	err = json.Unmarshal(content, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
@@ -0,0 +1,186 @@
+package storage_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// plainRepository hides the optional interfaces of a repository,
+// like those of repositories whose queries don't refer to fields by their JSON names.
+type plainRepository struct {
+	storage.Repository
+}
+
+var policyEntities = []storage.Entity{
+	{Name: "item", Data: reflect.TypeOf(testData{})},
+	{Name: "secret", Data: reflect.TypeOf(testData{}), Fields: map[string]storage.FieldPermission{"notes": {Hidden: true}}},
+}
+
+// itemPolicy lets principals read and list their own items, and update those of their team.
+var itemPolicy = storage.Policy{
+	"item": {
+		storage.OperationCreate: {{}},
+		storage.OperationRead:   {{Where: map[string]string{"data.owner": "id"}}, {Roles: []string{"admin"}}},
+		storage.OperationList:   {{Where: map[string]string{"data.owner": "id"}}, {Roles: []string{"admin"}}},
+		storage.OperationUpdate: {{Where: map[string]string{"data.notes": "team"}}},
+		storage.OperationDelete: {{Roles: []string{"admin"}}},
+	},
+	"secret": {
+		storage.OperationCreate: {{}},
+	},
+	storage.AnyEntity: {
+		storage.OperationRead: {{Roles: []string{"auditor"}}},
+	},
+}
+
+// createOwned creates an item of the owner in the team and returns its ID.
+func createOwned(t *testing.T, s storage.Storage, owner, team string) string {
+	resource, err := s.CreateFromJSON("item", `{"data":{"name":"`+owner+`","owner":"`+owner+`","notes":"`+team+`"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resource.ID
+}
+
+func TestPolicy(t *testing.T) {
+	s, release := newStorage(t, policyEntities, storage.WithPolicy(itemPolicy))
+	defer release()
+
+	alice := storage.Principal{ID: "alice", Claims: map[string]interface{}{"team": "red"}}
+	bob := storage.Principal{ID: "bob", Claims: map[string]interface{}{"team": "blue"}}
+	admin := storage.Principal{ID: "root", Roles: []string{"admin"}}
+	auditor := storage.Principal{ID: "audit", Roles: []string{"auditor"}}
+
+	aliceItem := createOwned(t, s, "alice", "red")
+	unowned := createOwned(t, s, "", "")
+	secret := create(t, s, "secret", "secret")
+
+	tests := []struct {
+		name      string
+		principal storage.Principal
+		execute   func(s storage.Storage) error
+		expected  error
+	}{
+		{"owner reads", alice, readItem(aliceItem), nil},
+		{"other principal reads", bob, readItem(aliceItem), storage.Forbidden{}},
+		{"role reads", admin, readItem(aliceItem), nil},
+		{"role of any entity reads", auditor, readItem(aliceItem), nil},
+		{"anonymous reads unowned", storage.Principal{}, readItem(unowned), storage.Forbidden{}},
+		{"reads missing", bob, readItem("missing"), storage.NotFound{}},
+		{"team updates", storage.Principal{ID: "carol", Claims: map[string]interface{}{"team": "red"}}, updateItem(aliceItem), nil},
+		{"other team updates", bob, updateItem(aliceItem), storage.Forbidden{}},
+		{"principal without claim updates", storage.Principal{ID: "alice"}, updateItem(aliceItem), storage.Forbidden{}},
+		{"updates missing", alice, updateItem("missing"), storage.NotFound{}},
+		{"owner without role deletes", alice, deleteItem(aliceItem), storage.Forbidden{}},
+		{"role deletes missing", admin, deleteItem("missing"), storage.NotFound{}},
+		{"lists entity without rules", admin, listEntity("secret"), storage.Forbidden{}},
+		{"role of any entity lists", auditor, listEntity("secret"), storage.Forbidden{}},
+		{"updates entity without rules", admin, updateSecret(secret), storage.Forbidden{}},
+		{"updates missing of entity without rules", admin, updateSecret("missing"), storage.Forbidden{}},
+		{"role deletes", admin, deleteItem(aliceItem), nil},
+	}
+
+	for _, test := range tests {
+		err := test.execute(s.As(test.principal))
+		expectErrorType(t, test.name, test.expected, err)
+	}
+}
+
+func readItem(id string) func(s storage.Storage) error {
+	return func(s storage.Storage) error {
+		_, err := s.Read("item", id)
+		return err
+	}
+}
+
+func updateItem(id string) func(s storage.Storage) error {
+	return func(s storage.Storage) error {
+		_, err := s.UpdateFromJSON("item", `{"id":"`+id+`","data":{"name":"updated","owner":"alice","notes":"red"}}`)
+		return err
+	}
+}
+
+// updateSecret updates a secret, whose hidden fields are kept as stored.
+func updateSecret(id string) func(s storage.Storage) error {
+	return func(s storage.Storage) error {
+		_, err := s.UpdateFromJSON("secret", `{"id":"`+id+`","data":{"name":"updated"}}`)
+		return err
+	}
+}
+
+func deleteItem(id string) func(s storage.Storage) error {
+	return func(s storage.Storage) error {
+		return s.Delete("item", id)
+	}
+}
+
+func listEntity(entityName string) func(s storage.Storage) error {
+	return func(s storage.Storage) error {
+		_, err := s.ReadAll(entityName, storage.Query{})
+		return err
+	}
+}
+
+func TestListPolicy(t *testing.T) {
+	jsonRepository, releaseJSON := openRepository(t)
+	defer releaseJSON()
+	repository, release := openRepository(t)
+	defer release()
+
+	repositories := map[string]storage.Repository{
+		"JSON repository":  jsonRepository,
+		"plain repository": plainRepository{repository},
+	}
+
+	for repositoryName, r := range repositories {
+		s := newStorageWith(t, r, policyEntities, storage.WithPolicy(itemPolicy))
+		aliceItems := []string{createOwned(t, s, "alice", "red"), createOwned(t, s, "alice", "red")}
+		bobItem := createOwned(t, s, "bob", "blue")
+		unowned := createOwned(t, s, "", "")
+
+		byOwner := func(owner string) storage.Query {
+			return storage.Query{Q: map[string]storage.FieldQuery{"data.owner": {Kind: storage.QueryAnd, Values: []interface{}{owner}}}}
+		}
+
+		tests := []struct {
+			name      string
+			principal storage.Principal
+			query     storage.Query
+			expected  []string
+		}{
+			{"owner", storage.Principal{ID: "alice"}, storage.Query{}, aliceItems},
+			{"other owner", storage.Principal{ID: "bob"}, storage.Query{}, []string{bobItem}},
+			{"without items", storage.Principal{ID: "carol"}, storage.Query{}, []string{}},
+			{"anonymous", storage.Principal{}, storage.Query{}, []string{}},
+			{"own query", storage.Principal{ID: "alice"}, byOwner("alice"), aliceItems},
+			{"colliding query", storage.Principal{ID: "alice"}, byOwner("bob"), []string{}},
+			{"role", storage.Principal{ID: "root", Roles: []string{"admin"}}, storage.Query{}, append([]string{bobItem, unowned}, aliceItems...)},
+			{"role with query", storage.Principal{ID: "root", Roles: []string{"admin"}}, byOwner("bob"), []string{bobItem}},
+		}
+
+		for _, test := range tests {
+			principal := s.As(test.principal)
+			resources, err := principal.ReadAll("item", test.query)
+			if err != nil {
+				t.Fatalf("%s: %s: %s", repositoryName, test.name, err)
+			}
+
+			ids := []string{}
+			for _, resource := range resources {
+				ids = append(ids, resource.ID)
+			}
+			sort.Strings(ids)
+
+			expected := append([]string{}, test.expected...)
+			sort.Strings(expected)
+
+			if !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%s: %s: expected %v, got %v", repositoryName, test.name, expected, ids)
+			}
+		}
+	}
+}
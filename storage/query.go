@@ -1,5 +1,11 @@
 package storage
 
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
 const (
 	QueryAnd      kind = iota
 	QueryOr
@@ -8,6 +14,9 @@ const (
 
 type Query struct {
 	Q map[string]FieldQuery
+	// Sort orders the result by the given fields, descending if they are prefixed by "-", e.g. "-meta.updatedAt".
+	// Without it the order is up to the repository.
+	Sort []string
 }
 
 type FieldQuery struct {
@@ -16,3 +25,171 @@ type FieldQuery struct {
 }
 
 type kind uint
+
+// Match evaluates the query against a JSON decoded document the way the mongo repository does:
+// a field matches a value if it is equal to it or if it is an array containing it.
+// It allows repositories without a query language to filter in memory.
+func (q Query) Match(document map[string]interface{}) bool {
+	hasOr := false
+	matchesOr := false
+
+	for k, v := range q.Q {
+		if k == "ID" {
+			k = "id"
+		}
+
+		field, _ := lookup(document, k)
+
+		switch v.Kind {
+		case QueryAnd:
+			for _, value := range v.Values {
+				if !matchValue(field, value) {
+					return false
+				}
+			}
+		case QueryOr:
+			for _, value := range v.Values {
+				hasOr = true
+				if matchValue(field, value) {
+					matchesOr = true
+				}
+			}
+		case QueryContains:
+			matches := false
+			for _, value := range v.Values {
+				if matchValue(field, value) {
+					matches = true
+					break
+				}
+			}
+
+			if !matches {
+				return false
+			}
+		}
+	}
+
+	return !hasOr || matchesOr
+}
+
+// Less orders JSON decoded documents by the sort fields of the query the way the mongo repository does
+// for values of the same type: numbers and strings by value, false before true and missing values first.
+func (q Query) Less(a, b map[string]interface{}) bool {
+	for _, field := range q.Sort {
+		descending := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+		if field == "ID" {
+			field = "id"
+		}
+
+		x, _ := lookup(a, field)
+		y, _ := lookup(b, field)
+
+		c := compare(x, y)
+		if c == 0 {
+			continue
+		}
+
+		return c < 0 != descending
+	}
+
+	return false
+}
+
+func lookup(document map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = document
+	for _, segment := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = m[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func matchValue(field, value interface{}) bool {
+	value = normalize(value)
+
+	if reflect.DeepEqual(field, value) {
+		return true
+	}
+
+	elements, ok := field.([]interface{})
+	if !ok {
+		return false
+	}
+
+	for _, element := range elements {
+		if reflect.DeepEqual(element, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compare(x, y interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case float64:
+			return 1
+		case string:
+			return 2
+		case bool:
+			return 4
+		}
+
+		return 3
+	}
+
+	if rank(x) != rank(y) {
+		return rank(x) - rank(y)
+	}
+
+	switch x := x.(type) {
+	case float64:
+		y := y.(float64)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	case string:
+		return strings.Compare(x, y.(string))
+	case bool:
+		if x != y.(bool) {
+			if x {
+				return 1
+			}
+
+			return -1
+		}
+	}
+
+	return 0
+}
+
+// normalize converts a value into the types encoding/json decodes into.
+func normalize(value interface{}) interface{} {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var result interface{}
+	err = json.Unmarshal(content, &result)
+	if err != nil {
+		return value
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ValidateReferences checks the direct references of a resource without expanding them.
+// Every relation has to be defined, satisfy its cardinality and point to existing resources.
+// Each referenced entity is queried once.
+func (s *Storage) ValidateReferences(resource CollapsedResource) error {
+	err := checkRelations(resource.entity, resource)
+	if err != nil {
+		return err
+	}
+
+	dangling, err := s.findDanglingReferences(resource.entity, []CollapsedResource{resource})
+	if err != nil {
+		return err
+	}
+
+	if dangling[0] != nil {
+		return DanglingReferences{Entity: resource.entity.Name, Relations: dangling[0]}
+	}
+
+	return nil
+}
+
+func checkRelations(entity Entity, resource CollapsedResource) error {
+	for relationName := range resource.References {
+		if _, ok := entity.References[relationName]; !ok {
+			return InvalidInput{fmt.Sprintf("%q has no relation %q", entity.Name, relationName)}
+		}
+	}
+
+	relationNames := make([]string, 0, len(entity.Cardinalities))
+	for relationName := range entity.Cardinalities {
+		relationNames = append(relationNames, relationName)
+	}
+	sort.Strings(relationNames)
+
+	for _, relationName := range relationNames {
+		cardinality := entity.Cardinalities[relationName]
+		count := len(resource.References[relationName])
+		if !cardinality.Allows(count) {
+			return CardinalityViolation{Entity: entity.Name, Relation: relationName, Count: count, Cardinality: cardinality}
+		}
+	}
+
+	return nil
+}
+
+// findDanglingReferences returns the missing referenced IDs by relation for every resource, nil if there are none.
+// The references of all resources are looked up with one query per referenced entity.
+func (s *Storage) findDanglingReferences(entity Entity, resources []CollapsedResource) ([]map[string][]string, error) {
+	referencedIDs := map[string][]string{}
+	for _, resource := range resources {
+		for relationName, ids := range resource.References {
+			referenceEntityName := entity.References[relationName].Name
+			referencedIDs[referenceEntityName] = append(referencedIDs[referenceEntityName], ids...)
+		}
+	}
+
+	existing := make(map[string]map[string]bool, len(referencedIDs))
+	for entityName, ids := range referencedIDs {
+		var err error
+		existing[entityName], err = s.existingIDs(entityName, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dangling := make([]map[string][]string, len(resources))
+	for i, resource := range resources {
+		for relationName, ids := range resource.References {
+			referenceEntityName := entity.References[relationName].Name
+			for _, id := range ids {
+				if existing[referenceEntityName][id] {
+					continue
+				}
+
+				if dangling[i] == nil {
+					dangling[i] = map[string][]string{}
+				}
+
+				dangling[i][relationName] = append(dangling[i][relationName], id)
+			}
+		}
+	}
+
+	return dangling, nil
+}
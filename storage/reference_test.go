@@ -0,0 +1,154 @@
+package storage_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// referenceEntities returns authors and articles needing at least one author and having at most one reviewer.
+func referenceEntities() []storage.Entity {
+	author := storage.Entity{Name: "author", Data: reflect.TypeOf(testData{})}
+	article := storage.Entity{
+		Name:          "article",
+		Data:          reflect.TypeOf(testData{}),
+		References:    map[string]storage.Entity{"authors": author, "reviewer": author},
+		Cardinalities: map[string]storage.Cardinality{"authors": {Min: 1}, "reviewer": {Max: 1}},
+	}
+
+	return []storage.Entity{author, article}
+}
+
+func TestValidateReferences(t *testing.T) {
+	s, release := newStorage(t, referenceEntities())
+	defer release()
+
+	first := create(t, s, "author", "first")
+	second := create(t, s, "author", "second")
+
+	tests := []struct {
+		name       string
+		references string
+		expected   error
+	}{
+		{"valid", `{"authors":["` + first + `","` + second + `"],"reviewer":["` + second + `"]}`, nil},
+		{"empty optional relation", `{"authors":["` + first + `"],"reviewer":[]}`, nil},
+		{
+			"missing reference",
+			`{"authors":["` + first + `","missing"]}`,
+			storage.DanglingReferences{Entity: "article", Relations: map[string][]string{"authors": {"missing"}}},
+		},
+		{
+			"missing references of several relations",
+			`{"authors":["missing","` + first + `","other"],"reviewer":["gone"]}`,
+			storage.DanglingReferences{Entity: "article", Relations: map[string][]string{"authors": {"missing", "other"}, "reviewer": {"gone"}}},
+		},
+		{
+			"empty relation",
+			`{"authors":[]}`,
+			storage.CardinalityViolation{Entity: "article", Relation: "authors", Count: 0, Cardinality: storage.Cardinality{Min: 1}},
+		},
+		{
+			"absent relation",
+			`{}`,
+			storage.CardinalityViolation{Entity: "article", Relation: "authors", Count: 0, Cardinality: storage.Cardinality{Min: 1}},
+		},
+		{
+			"too many references",
+			`{"authors":["` + first + `"],"reviewer":["` + first + `","` + second + `"]}`,
+			storage.CardinalityViolation{Entity: "article", Relation: "reviewer", Count: 2, Cardinality: storage.Cardinality{Max: 1}},
+		},
+		{"undefined relation", `{"authors":["` + first + `"],"editors":["` + first + `"]}`, storage.InvalidInput{}},
+	}
+
+	article, err := s.CreateFromJSON("article", `{"data":{"name":"article"},"references":{"authors":["`+first+`"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		_, err := s.CreateFromJSON("article", `{"data":{"name":"`+test.name+`"},"references":`+test.references+`}`)
+		expectReferenceError(t, test.name+": create", test.expected, err)
+
+		_, err = s.UpdateFromJSON("article", `{"id":"`+article.ID+`","data":{"name":"`+test.name+`"},"references":`+test.references+`}`)
+		expectReferenceError(t, test.name+": update", test.expected, err)
+
+		if test.expected == nil {
+			continue
+		}
+
+		stored, err := s.Read("article", article.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.ValidateReferences(stored)
+		if err != nil {
+			t.Errorf("%s: expected the stored article to stay valid, got %v", test.name, err)
+		}
+	}
+}
+
+func expectReferenceError(t *testing.T, name string, expected, actual error) {
+	if _, ok := expected.(storage.InvalidInput); ok {
+		expectErrorType(t, name, expected, actual)
+		return
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s: expected %#v, got %#v", name, expected, actual)
+	}
+}
+
+func TestReferenceToUndefinedEntity(t *testing.T) {
+	repository, release := openRepository(t)
+	defer release()
+
+	ghost := storage.Entity{Name: "ghost", Data: reflect.TypeOf(testData{})}
+	article := storage.Entity{Name: "article", Data: reflect.TypeOf(testData{}), References: map[string]storage.Entity{"authors": ghost}}
+
+	_, err := storage.New([]storage.Entity{article}, repository, nil)
+	if err == nil {
+		t.Error("expected a reference to an undefined entity to be rejected")
+	}
+}
+
+func expectErrorType(t *testing.T, name string, expected, actual error) {
+	if reflect.TypeOf(actual) != reflect.TypeOf(expected) {
+		t.Errorf("%s: expected %T, got %#v", name, expected, actual)
+	}
+}
+
+func TestValidateReferencesSetByHooks(t *testing.T) {
+	entities := referenceEntities()
+	entities[1].Hooks.BeforeUpdate = func(s *storage.Storage, stored storage.CollapsedResource, resource *storage.CollapsedResource) error {
+		resource.References = map[string][]string{"authors": {"missing"}}
+		return nil
+	}
+
+	s, release := newStorage(t, entities)
+	defer release()
+
+	author := create(t, s, "author", "first")
+	article, err := s.CreateFromJSON("article", `{"data":{"name":"article"},"references":{"authors":["`+author+`"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := storage.DanglingReferences{Entity: "article", Relations: map[string][]string{"authors": {"missing"}}}
+
+	_, err = s.UpdateFromJSON("article", `{"id":"`+article.ID+`","data":{"name":"updated"},"references":{"authors":["`+author+`"]}}`)
+	expectReferenceError(t, "update from JSON", expected, err)
+
+	err = s.Update(article)
+	expectReferenceError(t, "update", expected, err)
+
+	stored, err := s.Read("article", article.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored.References["authors"], []string{author}) {
+		t.Errorf("expected the article to keep its author, got %v", stored.References)
+	}
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"reflect"
 	"fmt"
+	"sort"
+	"time"
 )
 
 type Entities struct {
@@ -51,7 +53,7 @@ func getReferencedBy(entities map[string]Entity) (map[string]map[string][]string
 				return nil, fmt.Errorf("entitiy %q is referenced but unknown", reference.Name)
 			}
 
-			if _, ok := referenceBy[entityName][reference.Name]; !ok {
+			if _, ok := referenceBy[reference.Name][entityName]; !ok {
 				referenceBy[reference.Name][entityName] = []string{}
 			}
 
@@ -62,6 +64,22 @@ func getReferencedBy(entities map[string]Entity) (map[string]map[string][]string
 	return referenceBy, nil
 }
 
+// All returns every entity ordered by name.
+func (e *Entities) All() []Entity {
+	names := make([]string, 0, len(e.entitiesByName))
+	for name := range e.entitiesByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]Entity, len(names))
+	for i, name := range names {
+		result[i] = e.entitiesByName[name]
+	}
+
+	return result
+}
+
 func (e *Entities) CreateReferencedByMap(entityName string) (map[string]map[string][]string, error) {
 	references, ok := e.referencedBy[entityName]
 	if !ok {
@@ -84,6 +102,43 @@ type Entity struct {
 	Name       string
 	Data       reflect.Type
 	References map[string]Entity
+	// Cardinalities constrains the number of references per relation. Relations without one are unconstrained.
+	Cardinalities map[string]Cardinality
+	// Fields restricts access to fields of the data by their JSON path, e.g. "internal.notes".
+	Fields map[string]FieldPermission
+	// Versioned entities keep every version of their resources in the collection named by HistorySuffix.
+	Versioned bool
+	// SoftDelete moves deleted resources to the collection named by TrashSuffix, from where they can be restored.
+	// Deleting a resource whose ID is still taken by a resource in the trash conflicts until that one is purged.
+	SoftDelete bool
+	// Retention is how long deleted resources are kept if the entity soft deletes. Zero keeps them forever.
+	Retention time.Duration
+	// Hooks add custom logic to the operations on the resources.
+	Hooks Hooks
+	// IDGenerator generates the IDs of new resources instead of the one of the storage.
+	IDGenerator IDGenerator
+}
+
+// Cardinality limits the number of references of a relation. A Max of 0 means unbounded.
+type Cardinality struct {
+	Min, Max int
+}
+
+var ExactlyOne = Cardinality{Min: 1, Max: 1}
+
+func (c Cardinality) Allows(count int) bool {
+	return count >= c.Min && (c.Max == 0 || count <= c.Max)
+}
+
+func (c Cardinality) String() string {
+	switch {
+	case c.Max == 0:
+		return fmt.Sprintf("at least %d", c.Min)
+	case c.Min == c.Max:
+		return fmt.Sprintf("exactly %d", c.Min)
+	}
+
+	return fmt.Sprintf("between %d and %d", c.Min, c.Max)
 }
 
 func (e Entity) New() Resource {
@@ -101,6 +156,7 @@ type Resource struct {
 	ID         string                `json:"id"`
 	Data       interface{}           `json:"data"`
 	References map[string][]Resource `json:"references"`
+	Meta       Metadata              `json:"meta"`
 	entity     Entity
 }
 
@@ -108,13 +164,28 @@ type CollapsedResource struct {
 	ID         string              `bson:"_id" json:"id"`
 	Data       interface{}         `json:"data"`
 	References map[string][]string `json:"references"`
+	Meta       Metadata            `bson:"meta" json:"meta"`
 	entity     Entity
 }
 
+// Metadata is maintained by the storage and can't be written by clients.
+// Timestamps are UTC and truncated to seconds so that they sort as strings in every repository,
+// e.g. when sorting by "-meta.updatedAt".
+type Metadata struct {
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	// CreatedBy and UpdatedBy are the IDs of the principals, empty if they are anonymous.
+	CreatedBy string `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	UpdatedBy string `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+	// Version is the latest version of resources of versioned entities, under which it is kept in their history.
+	Version int `bson:"version,omitempty" json:"version,omitempty"`
+}
+
 func (r Resource) Collapse() CollapsedResource {
 	result := CollapsedResource{}
 	result.ID = r.ID
 	result.Data = r.Data
+	result.Meta = r.Meta
 
 	references := make(map[string][]string, len(r.References))
 
@@ -6,12 +6,30 @@ import (
 	"fmt"
 	"encoding/json"
 	"io/ioutil"
+	"strings"
+	"bytes"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
 )
 
 const ActionExpand = "expand"
 const ActionReferencedBy = "referenced-by"
+const ActionBulk = "_bulk"
+const ActionAudit = "audit"
+const ActionHistory = "history"
+const ActionTrash = "trash"
 const Meta = "meta"
 const MetaActionSwaggerFile = "swagger"
+const MetaActionOpenAPIFile = "openapi"
+const MetaActionEvents = "events"
+const MetaActionWebhooks = "webhooks"
+const MetaActionWebhookDeliveries = "webhook-deliveries"
+const MetaActionWebhookDeadLetters = "webhook-dead-letters"
+
+// eventKeepAlive is the interval of comments keeping idle event streams open.
+var eventKeepAlive = 30 * time.Second
 
 var entityNameRegex = regexp.MustCompile("^/([^/]+)/.*$")
 var indexRegex = regexp.MustCompile("^.*/([^/]+)$")
@@ -19,11 +37,35 @@ var actionRegex = regexp.MustCompile("^/[^/]+/([^/]+)$")
 var indexedActionRegex = regexp.MustCompile("^/[^/]+/([^/]+)/[^/]+$")
 var indexedEntityNameRegex = regexp.MustCompile("^/([^/]+)$")
 
+var historyRegex = regexp.MustCompile("^/[^/]+/([^/]+)/" + ActionHistory + "$")
 var pathRegex = regexp.MustCompilePOSIX("^/[^/]+/?[^/]*/?[^/]*$")
 
 type Service struct {
 	Storage Storage
 	Info    Info
+	// Authenticator identifies the principal of every request but preflights. Without one all requests are anonymous.
+	Authenticator Authenticator
+	// Anonymous lets requests without credentials proceed as the anonymous principal.
+	Anonymous bool
+	// CORS allows cross-origin requests. Without it browsers only allow same-origin ones.
+	CORS *CORS
+	// Webhooks are managed below /meta/webhooks. Policies grant access to them by the entity name "webhooks".
+	// A webhook delivers the events its creator or last updater may read.
+	Webhooks *Webhooks
+
+	// specs are built by NewService. Services created as literals rebuild them on every request.
+	specs *specs
+}
+
+// NewService creates a service serving the API descriptions it builds once from the entities of the storage.
+// Services created otherwise build them on every request.
+func NewService(storage Storage, info Info) (Service, error) {
+	specs, err := createSpecs(storage.entities, info)
+	if err != nil {
+		return Service{}, err
+	}
+
+	return Service{Storage: storage, Info: info, specs: specs}, nil
 }
 
 type Info struct {
@@ -31,16 +73,32 @@ type Info struct {
 }
 
 func (s Service) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-	rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	rw.Header().Set("Access-Control-Allow-Credentials", "true")
-	rw.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Authorization, User-Agent")
+	if s.CORS != nil && s.CORS.handle(rw, r) {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// The docs are deliberately served before authentication, so that anyone can enter their credentials in them.
+	if r.Method == http.MethodGet && r.URL.Path == "/"+Meta+"/"+MetaActionDocs {
+		serveDocs(rw, r)
+		return
+	}
+
 	rw.Header().Set("Content-Type", "application/json")
 
 	if r.Method == http.MethodOptions {
 		return
 	}
 
+	principal, err := s.authenticate(r)
+	if err != nil {
+		rw.Header().Set("WWW-Authenticate", s.Authenticator.Challenge())
+		writeError(rw, err)
+		return
+	}
+
+	s.Storage = s.Storage.As(principal)
+
 	entityName := s.getEntityName(r)
 
 	if !pathRegex.Match([]byte(r.URL.Path)) {
@@ -51,10 +109,38 @@ func (s Service) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	index := s.getIndex(r)
 	action := s.getAction(r)
 
+	// The history is below the resource, e.g. /article/1/history, unlike other actions.
+	switch {
+	case historyRegex.MatchString(r.URL.Path):
+		action = ActionHistory
+		index = historyRegex.ReplaceAllString(r.URL.Path, "$1")
+	case action == ActionHistory:
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
 	if entityName == Meta {
 		switch action {
 		case MetaActionSwaggerFile:
 			s.GetSwaggerFile(rw, r)
+		case MetaActionOpenAPIFile:
+			s.GetOpenAPIFile(rw, r)
+		case MetaActionTypeScript:
+			s.GetTypeScript(rw, r)
+		case MetaActionEntities:
+			if index == action {
+				index = ""
+			}
+
+			s.describeEntities(rw, r, index)
+		case MetaActionEvents:
+			s.streamEvents(rw, r)
+		case MetaActionWebhooks, MetaActionWebhookDeliveries, MetaActionWebhookDeadLetters:
+			if index == action {
+				index = ""
+			}
+
+			s.manageWebhooks(rw, r, action, index)
 		}
 	} else {
 		switch r.Method {
@@ -64,6 +150,16 @@ func (s Service) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 				s.expand(rw, r, entityName, index)
 			case ActionReferencedBy:
 				s.getReferencedBy(rw, r, entityName, index)
+			case ActionAudit:
+				s.getAuditTrail(rw, r, entityName, index)
+			case ActionHistory:
+				s.getHistory(rw, r, entityName, index)
+			case ActionTrash:
+				if index == ActionTrash {
+					s.getTrash(rw, r, entityName)
+				} else {
+					s.getTrashed(rw, r, entityName, index)
+				}
 			default:
 				if index == "" || index == entityName {
 					s.getAll(rw, r, entityName)
@@ -72,40 +168,400 @@ func (s Service) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 				}
 			}
 		case http.MethodPost:
-			s.post(rw, r, entityName)
+			if action == ActionBulk {
+				s.bulk(rw, r, entityName)
+			} else if action == ActionHistory {
+				s.restore(rw, r, entityName, index)
+			} else if action == ActionTrash {
+				s.restoreTrashed(rw, r, entityName, index)
+			} else {
+				s.post(rw, r, entityName)
+			}
 		case http.MethodPut:
 			s.put(rw, r, entityName, index)
 		case http.MethodDelete:
-			s.delete(rw, r, entityName, index)
+			if action == ActionTrash && index != ActionTrash {
+				s.purgeTrashed(rw, r, entityName, index)
+			} else {
+				s.delete(rw, r, entityName, index)
+			}
 		default:
 			rw.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	}
 }
 
+func (s Service) authenticate(r *http.Request) (Principal, error) {
+	if s.Authenticator == nil {
+		return Principal{}, nil
+	}
+
+	principal, err := s.Authenticator.Authenticate(r)
+	if _, ok := err.(NoCredentials); ok && s.Anonymous {
+		return Principal{}, nil
+	}
+
+	return principal, err
+}
+
 func (s Service) GetSwaggerFile(rw http.ResponseWriter, r *http.Request) {
-	response, err := CreateSwaggerFile(s.Storage.entities, s.Info, r.Host)
+	specs, err := s.apiSpecs()
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
+		return
+	}
+
+	specs.swagger.serve(rw, r)
+}
+
+// GetOpenAPIFile responds with the OpenAPI document as YAML if the format parameter or the Accept header prefer it
+// and as JSON otherwise. Requests accepting neither get 406 Not Acceptable.
+func (s Service) GetOpenAPIFile(rw http.ResponseWriter, r *http.Request) {
+	specs, err := s.apiSpecs()
+	if err != nil {
+		writeError(rw, err)
 		return
 	}
 
-	rw.Write([]byte(response))
+	rw.Header().Set("Vary", "Accept")
+
+	switch openAPIFormat(r) {
+	case "json":
+		specs.openAPIJSON.serve(rw, r)
+	case "yaml":
+		specs.openAPIYAML.serve(rw, r)
+	default:
+		rw.WriteHeader(http.StatusNotAcceptable)
+	}
+}
+
+// openAPIFormat returns the format of the OpenAPI document the request asks for, "json" or "yaml",
+// and an empty string if it accepts neither. Of equally preferred media ranges the first one wins.
+func openAPIFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case "json", "yaml":
+		return format
+	case "":
+	default:
+		return ""
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return "json"
+	}
+
+	format, quality := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parameters := strings.Split(mediaRange, ";")
+
+		q := 1.0
+		for _, parameter := range parameters[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				q, _ = strconv.ParseFloat(strings.TrimPrefix(parameter, "q="), 64)
+			}
+		}
+
+		f := ""
+		switch strings.ToLower(strings.TrimSpace(parameters[0])) {
+		case "application/json", "application/*", "*/*":
+			f = "json"
+		case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml", "text/*":
+			f = "yaml"
+		}
+
+		if f != "" && q > quality {
+			format, quality = f, q
+		}
+	}
+
+	return format
+}
+
+// GetTypeScript responds with the TypeScript interfaces of the entities and a client of the service.
+func (s Service) GetTypeScript(rw http.ResponseWriter, r *http.Request) {
+	specs, err := s.apiSpecs()
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	specs.typeScript.serve(rw, r)
+}
+
+func (s Service) apiSpecs() (*specs, error) {
+	if s.specs != nil {
+		return s.specs, nil
+	}
+
+	return createSpecs(s.Storage.entities, s.Info)
+}
+
+// streamEvents sends the events of the entities given by the entity parameters, or of all entities,
+// as server-sent events. Clients resume after the sequence number given by the since parameter or the
+// Last-Event-ID header. Slow clients are disconnected and have to resume.
+func (s Service) streamEvents(rw http.ResponseWriter, r *http.Request) {
+	bus := s.Storage.events
+	if bus == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		writeError(rw, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	position := r.URL.Query().Get("since")
+	if position == "" {
+		position = r.Header.Get("Last-Event-ID")
+	}
+
+	entityNames := map[string]bool{}
+	for _, entityName := range r.URL.Query()["entity"] {
+		if _, ok := s.Storage.entities.entitiesByName[entityName]; !ok {
+			writeError(rw, UndefinedEntity{entityName})
+			return
+		}
+
+		entityNames[entityName] = true
+	}
+
+	// Events passed while subscribing, e.g. the ones replayed when resuming, are collected before streaming
+	// as there may be more of them than fit into the channel of live events.
+	var mutex sync.Mutex
+	subscribed := false
+	replayed := []Event{}
+	events := make(chan Event, 256)
+	overflow := make(chan struct{})
+	overflown := false
+	handler := func(event Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !subscribed {
+			replayed = append(replayed, event)
+			return
+		}
+
+		if overflown {
+			return
+		}
+
+		select {
+		case events <- event:
+		default:
+			overflown = true
+			close(overflow)
+		}
+	}
+
+	var unsubscribe func()
+	if position == "" {
+		unsubscribe = bus.Subscribe(handler)
+	} else {
+		since, err := strconv.ParseUint(position, 10, 64)
+		if err != nil {
+			writeError(rw, InvalidInput{"since has to be a sequence number"})
+			return
+		}
+
+		unsubscribe, err = bus.SubscribeSince(since, handler)
+		if err != nil {
+			writeError(rw, err)
+			return
+		}
+	}
+	defer unsubscribe()
+
+	mutex.Lock()
+	subscribed = true
+	mutex.Unlock()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	for _, event := range replayed {
+		err := s.writeEvent(rw, event, entityNames)
+		if err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-overflow:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(rw, ": keep-alive\n\n")
+		case event := <-events:
+			err := s.writeEvent(rw, event, entityNames)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes the event if it belongs to one of the entities, if any are given, and is visible to the principal.
+func (s Service) writeEvent(rw http.ResponseWriter, event Event, entityNames map[string]bool) error {
+	if len(entityNames) != 0 && !entityNames[event.Entity] {
+		return nil
+	}
+
+	event, ok, err := s.Storage.visibleEvent(event)
+	if err != nil || !ok {
+		return err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+
+	return err
+}
+
+func (s Service) manageWebhooks(rw http.ResponseWriter, r *http.Request, action string, index string) {
+	if s.Webhooks == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response interface{}
+	var err error
+	switch {
+	case action == MetaActionWebhookDeliveries && r.Method == http.MethodGet && index != "":
+		err = s.Storage.authorize(WebhookCollection, OperationRead, CollapsedResource{ID: index})
+		if err == nil {
+			response, err = s.Webhooks.Deliveries(index)
+		}
+	case action == MetaActionWebhookDeadLetters && r.Method == http.MethodGet && index == "":
+		err = s.Storage.authorize(WebhookCollection, OperationList, CollapsedResource{})
+		if err == nil {
+			response, err = s.Webhooks.DeadLetters()
+		}
+	case action == MetaActionWebhookDeadLetters && r.Method == http.MethodPost && index != "":
+		err = s.Storage.authorize(WebhookCollection, OperationUpdate, CollapsedResource{ID: index})
+		if err == nil {
+			err = s.Webhooks.Redeliver(index)
+		}
+		if err == nil {
+			rw.WriteHeader(http.StatusAccepted)
+			return
+		}
+	case action == MetaActionWebhooks && r.Method == http.MethodGet && index == "":
+		err = s.Storage.authorize(WebhookCollection, OperationList, CollapsedResource{})
+		if err == nil {
+			var webhooks []Webhook
+			webhooks, err = s.Webhooks.List()
+			for i := range webhooks {
+				webhooks[i].Secret = ""
+			}
+			response = webhooks
+		}
+	case action == MetaActionWebhooks && r.Method == http.MethodGet:
+		err = s.Storage.authorize(WebhookCollection, OperationRead, CollapsedResource{ID: index})
+		if err == nil {
+			var webhook Webhook
+			webhook, err = s.Webhooks.Get(index)
+			webhook.Secret = ""
+			response = webhook
+		}
+	case action == MetaActionWebhooks && r.Method == http.MethodPost && index == "":
+		var webhook Webhook
+		err = decodeJSON(r, &webhook)
+		if err == nil {
+			err = s.Storage.authorize(WebhookCollection, OperationCreate, CollapsedResource{})
+		}
+		if err == nil {
+			webhook.Principal = s.Storage.Principal()
+			webhook, err = s.Webhooks.Create(webhook)
+			webhook.Secret = ""
+			response = webhook
+		}
+	case action == MetaActionWebhooks && r.Method == http.MethodPut && index != "":
+		var webhook Webhook
+		err = decodeJSON(r, &webhook)
+		if err == nil {
+			err = s.Storage.authorize(WebhookCollection, OperationUpdate, CollapsedResource{ID: index})
+		}
+		if err == nil {
+			webhook.Principal = s.Storage.Principal()
+			webhook.ID = index
+			err = s.Webhooks.Update(webhook)
+			webhook.Secret = ""
+			response = webhook
+		}
+	case action == MetaActionWebhooks && r.Method == http.MethodDelete && index != "":
+		err = s.Storage.authorize(WebhookCollection, OperationDelete, CollapsedResource{ID: index})
+		if err == nil {
+			err = s.Webhooks.Delete(index)
+		}
+		if err == nil {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	content, err := json.Marshal(response)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(content)
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
 }
 
 func (s Service) get(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
-	resource, err := s.Storage.Read(entityName, index)
+	var resource CollapsedResource
+	var err error
+	if r.URL.Query().Get("version") == "" {
+		resource, err = s.Storage.Read(entityName, index)
+	} else {
+		var version int
+		version, err = getVersion(r)
+		if err == nil {
+			resource, err = s.Storage.ReadVersion(entityName, index, version)
+		}
+	}
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	response, err := json.Marshal(resource)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
@@ -115,15 +571,13 @@ func (s Service) get(rw http.ResponseWriter, r *http.Request, entityName string,
 func (s Service) getAll(rw http.ResponseWriter, r *http.Request, entityName string) {
 	resource, err := s.Storage.ReadAll(entityName, Query{})
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	response, err := json.Marshal(resource)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
@@ -133,15 +587,13 @@ func (s Service) getAll(rw http.ResponseWriter, r *http.Request, entityName stri
 func (s Service) expand(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
 	resource, err := s.Storage.ReadAndExpand(entityName, index)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	response, err := json.Marshal(resource)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
@@ -151,65 +603,294 @@ func (s Service) expand(rw http.ResponseWriter, r *http.Request, entityName stri
 func (s Service) getReferencedBy(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
 	resource, err := s.Storage.GetReferencedBy(entityName, index)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	response, err := json.Marshal(resource)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	rw.Write(response)
 }
 
+// describeEntities responds with the description of the entity given by index or, without one, of all entities.
+func (s Service) describeEntities(rw http.ResponseWriter, r *http.Request, index string) {
+	if r.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var description interface{}
+	var err error
+	if index == "" {
+		description, err = s.Storage.DescribeEntities()
+	} else {
+		description, err = s.Storage.DescribeEntity(index)
+	}
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(description)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) getAuditTrail(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
+	records, err := s.Storage.AuditTrail(entityName, index)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(records)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) getHistory(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
+	revisions, err := s.Storage.History(entityName, index)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(revisions)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) restore(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
+	version, err := getVersion(r)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	resource, err := s.Storage.Restore(entityName, index, version)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(resource)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) getTrash(rw http.ResponseWriter, r *http.Request, entityName string) {
+	trashed, err := s.Storage.Trash(entityName)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(trashed)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) getTrashed(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
+	trashed, err := s.Storage.ReadTrashed(entityName, index)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(trashed)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) restoreTrashed(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
+	resource, err := s.Storage.RestoreTrashed(entityName, index)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	response, err := json.Marshal(resource)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Write(response)
+}
+
+func (s Service) purgeTrashed(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
+	err := s.Storage.PurgeTrashed(entityName, index)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func getVersion(r *http.Request) (int, error) {
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		return 0, InvalidInput{"version has to be a positive integer"}
+	}
+
+	return version, nil
+}
+
 func (s Service) post(rw http.ResponseWriter, r *http.Request, entityName string) {
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	resource, err := s.Storage.CreateFromJSON(entityName, string(content))
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	response, err := json.Marshal(resource)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	rw.Write(response)
 }
 
+type bulkResponseItem struct {
+	Index     int                `json:"index"`
+	Operation string             `json:"op"`
+	ID        string             `json:"id,omitempty"`
+	Status    int                `json:"status"`
+	Error     string             `json:"error,omitempty"`
+	Resource  *CollapsedResource `json:"resource,omitempty"`
+}
+
+// bulk accepts a JSON array or, with the content type application/x-ndjson, one operation per line.
+// The query parameter atomic=true writes nothing unless every operation succeeds.
+func (s Service) bulk(rw http.ResponseWriter, r *http.Request, entityName string) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	operations, err := parseBulkOperations(content, r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(rw, InvalidInput{err.Error()})
+		return
+	}
+
+	results, err := s.Storage.Bulk(entityName, operations, r.URL.Query().Get("atomic") == "true")
+	_, failed := err.(BulkFailed)
+	if err != nil && !failed {
+		writeError(rw, err)
+		return
+	}
+
+	items := make([]bulkResponseItem, len(results))
+	for i, result := range results {
+		items[i] = bulkResponseItem{Index: i, Operation: result.Operation, ID: result.ID, Resource: result.Resource}
+
+		switch {
+		case result.Err != nil:
+			items[i].Status = statusCode(result.Err)
+			items[i].Error = result.Err.Error()
+			items[i].Resource = nil
+		case result.Operation == BulkCreate:
+			items[i].Status = http.StatusCreated
+		case result.Operation == BulkDelete:
+			items[i].Status = http.StatusNoContent
+		default:
+			items[i].Status = http.StatusOK
+		}
+	}
+
+	response, err := json.Marshal(items)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	if failed {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	rw.Write(response)
+}
+
+func parseBulkOperations(content []byte, contentType string) ([]BulkOperation, error) {
+	operations := []BulkOperation{}
+
+	if !strings.Contains(contentType, "ndjson") {
+		err := json.Unmarshal(content, &operations)
+
+		return operations, err
+	}
+
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		operation := BulkOperation{}
+		err := json.Unmarshal(line, &operation)
+		if err != nil {
+			return nil, err
+		}
+
+		operations = append(operations, operation)
+	}
+
+	return operations, nil
+}
+
 func (s Service) put(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	resource, err := s.Storage.UpdateFromJSON(entityName, string(content))
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
 	response, err := json.Marshal(resource)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, err)
 		return
 	}
 
@@ -219,14 +900,73 @@ func (s Service) put(rw http.ResponseWriter, r *http.Request, entityName string,
 func (s Service) delete(rw http.ResponseWriter, r *http.Request, entityName string, index string) {
 	err := s.Storage.Purge(entityName, index)
 	if err != nil {
-		fmt.Println(err)
-		rw.WriteHeader(http.StatusNotFound)
+		writeError(rw, err)
 		return
 	}
 
 	rw.WriteHeader(http.StatusNoContent)
 }
 
+type errorResponse struct {
+	Error   string      `json:"error"`
+	Type    string      `json:"type,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// writeError responds with the status of the error and describes it.
+// Errors of this package are identified by their type name and include their fields as details.
+func writeError(rw http.ResponseWriter, err error) {
+	fmt.Println(err)
+
+	response := errorResponse{Error: err.Error()}
+
+	t := reflect.TypeOf(err)
+	if t.PkgPath() == reflect.TypeOf(errorResponse{}).PkgPath() {
+		response.Type = t.Name()
+		response.Details = err
+	}
+
+	content, marshalErr := json.Marshal(response)
+	if marshalErr != nil {
+		fmt.Println(marshalErr)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rw.WriteHeader(statusCode(err))
+	rw.Write(content)
+}
+
+func statusCode(err error) int {
+	switch err.(type) {
+	case NotFound, UndefinedEntity:
+		return http.StatusNotFound
+	case Unauthenticated, NoCredentials:
+		return http.StatusUnauthorized
+	case Forbidden:
+		return http.StatusForbidden
+	case Conflict:
+		return http.StatusConflict
+	case InvalidInput, *json.SyntaxError, *json.UnmarshalTypeError:
+		return http.StatusBadRequest
+	case DanglingReferences, CardinalityViolation:
+		return http.StatusUnprocessableEntity
+	case BulkAborted:
+		return http.StatusFailedDependency
+	case EventsExpired:
+		return http.StatusGone
+	case HookError:
+		status := err.(HookError).Status
+		if status >= 400 && status < 500 {
+			return status
+		}
+
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusInternalServerError
+}
+
 func (s Service) getAction(r *http.Request) string {
 	regex := actionRegex
 
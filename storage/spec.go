@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// specMaxAge is how long clients may use the API descriptions without revalidating them.
+var specMaxAge = 5 * time.Minute
+
+// spec is a rendered API description identified by the hash of its content.
+type spec struct {
+	content     []byte
+	contentType string
+	etag        string
+}
+
+func newSpec(content []byte, contentType string) spec {
+	hash := sha256.Sum256(content)
+
+	return spec{content: content, contentType: contentType, etag: `"` + hex.EncodeToString(hash[:16]) + `"`}
+}
+
+// specs are the API descriptions of a service. They only depend on its entities and info.
+// Servers are omitted so that clients use the host serving them.
+type specs struct {
+	swagger     spec
+	openAPIJSON spec
+	openAPIYAML spec
+	typeScript  spec
+}
+
+func createSpecs(entities Entities, info Info) (*specs, error) {
+	swagger, err := CreateSwaggerFile(entities, info, "")
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := CreateOpenAPIDocument(entities, info, "")
+	if err != nil {
+		return nil, err
+	}
+
+	openAPIJSON, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	openAPIYAML, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	typeScript, err := CreateTypeScript(entities, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &specs{
+		swagger:     newSpec([]byte(swagger), "application/json"),
+		openAPIJSON: newSpec(openAPIJSON, "application/json"),
+		openAPIYAML: newSpec(openAPIYAML, "application/yaml"),
+		typeScript:  newSpec([]byte(typeScript), "application/typescript; charset=utf-8"),
+	}, nil
+}
+
+// serve responds with the spec unless the client's copy is still the current one.
+func (s spec) serve(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", s.contentType)
+	rw.Header().Set("ETag", s.etag)
+	rw.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(specMaxAge.Seconds())))
+
+	if matchesETag(r.Header.Get("If-None-Match"), s.etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rw.Write(s.content)
+}
+
+// matchesETag reports whether the If-None-Match header contains the entity tag, comparing weakly.
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
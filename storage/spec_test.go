@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeSpecs(t *testing.T) {
+	s, err := New(FixtureEntities, dummyRepository{}, dummyUUIDGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := NewService(s, FixtureInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/meta/swagger", "/meta/openapi", "/meta/openapi?format=yaml", "/meta/typescript"} {
+		rw := httptest.NewRecorder()
+		service.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusOK, rw.Code)
+		}
+
+		hash := sha256.Sum256(rw.Body.Bytes())
+		etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+		if rw.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected the ETag %s, got %s", path, etag, rw.Header().Get("ETag"))
+		}
+		if rw.Header().Get("Cache-Control") != "public, max-age=300" {
+			t.Errorf("%s: unexpected Cache-Control %q", path, rw.Header().Get("Cache-Control"))
+		}
+
+		for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.Header.Set("If-None-Match", header)
+
+			rw := httptest.NewRecorder()
+			service.ServeHTTP(rw, r)
+
+			if rw.Code != http.StatusNotModified || rw.Body.Len() != 0 {
+				t.Errorf("%s: expected %s to be not modified, got %d", path, header, rw.Code)
+			}
+		}
+
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("If-None-Match", `"other"`)
+
+		rw = httptest.NewRecorder()
+		service.ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusOK || rw.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected a stale copy to be replaced, got %d", path, rw.Code)
+		}
+	}
+}
+
+func TestNewServiceBuildsSpecsOnce(t *testing.T) {
+	s, err := New(FixtureEntities, dummyRepository{}, dummyUUIDGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := NewService(s, FixtureInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := service.apiSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.apiSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != service.specs || second != service.specs {
+		t.Error("expected the specs built by NewService to be served")
+	}
+
+	literal := Service{Storage: s, Info: FixtureInfo}
+	rebuilt, err := literal.apiSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt == first || rebuilt.openAPIJSON.etag != first.openAPIJSON.etag {
+		t.Error("expected a service created otherwise to rebuild equal specs")
+	}
+}
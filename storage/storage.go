@@ -2,69 +2,213 @@ package storage
 
 import (
 	"encoding/json"
+	"log"
+	"reflect"
+	"time"
 )
 
 type Storage struct {
 	entities     Entities
 	repository   Repository
 	idGenerator  IDGenerator
+	principal    Principal
+	policy       Policy
+	auditSink    AuditSink
+	events       *EventBus
+	// pending collects the mutations of a transaction until it is committed.
+	pending      *[]mutation
 }
 
-func New(entities []Entity, repository Repository, idGenerator IDGenerator) (Storage, error) {
+// Option configures optional features of a Storage.
+type Option func(*Storage)
+
+// WithPolicy enforces the policy on all operations. Without a policy everything is allowed.
+func WithPolicy(policy Policy) Option {
+	return func(s *Storage) {
+		s.policy = policy
+	}
+}
+
+func New(entities []Entity, repository Repository, idGenerator IDGenerator, options ...Option) (Storage, error) {
 	validatedEntities, err := NewEntities(entities)
 	if err != nil {
 		return Storage{}, err
 	}
 
-	return Storage{
+	s := Storage{
 		entities:     validatedEntities,
 		repository:   repository,
 		idGenerator:  idGenerator,
-	}, nil
+	}
+
+	for _, option := range options {
+		option(&s)
+	}
+
+	return s, nil
+}
+
+// As returns a copy of the storage acting on behalf of the principal.
+func (s Storage) As(principal Principal) Storage {
+	s.principal = principal
+
+	return s
+}
+
+func (s *Storage) Principal() Principal {
+	return s.principal
 }
 
 func (s *Storage) CreateFromJSON(entityName, jsonDocument string) (CollapsedResource, error) {
-	resource, err := s.createCollapsedResourceFromJSON(entityName, jsonDocument)
+	resource, err := s.createCollapsedResourceFromJSON(entityName, jsonDocument, false, "")
 	if err != nil {
 		return CollapsedResource{}, err
 	}
 
-	_, err = s.Expand(resource)
+	err = s.transaction(func(tx *Storage) error {
+		return tx.create(&resource)
+	})
 	if err != nil {
 		return CollapsedResource{}, err
 	}
 
-	resource.ID = s.idGenerator.Generate()
+	return s.hideFields(resource.entity, resource)
+}
 
-	err = s.repository.Create(entityName, resource)
+func (s *Storage) create(resource *CollapsedResource) error {
+	entity := resource.entity
+	err := s.beforeCreate(entity, resource)
 	if err != nil {
-		return CollapsedResource{}, err
+		return err
 	}
 
-	return resource, nil
+	resource.Meta = s.newMeta(entity)
+
+	err = s.authorize(entity.Name, OperationCreate, *resource)
+	if err != nil {
+		return err
+	}
+
+	err = s.ValidateReferences(*resource)
+	if err != nil {
+		return err
+	}
+
+	resource.ID, err = s.generateID(entity, *resource)
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.Create(entity.Name, *resource)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordRevision(*resource)
+	if err != nil {
+		return err
+	}
+
+	err = s.mutated(OperationCreate, entity.Name, resource.ID, nil, resource, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.afterCreate(entity, *resource)
 }
 
 func (s *Storage) UpdateFromJSON(entityName, jsonDocument string) (CollapsedResource, error) {
-	resource, err := s.createCollapsedResourceFromJSON(entityName, jsonDocument)
+	resource, err := s.createCollapsedResourceFromJSON(entityName, jsonDocument, true, "")
 	if err != nil {
 		return CollapsedResource{}, err
 	}
 
-	_, err = s.Expand(resource)
+	err = s.transaction(func(tx *Storage) error {
+		return tx.replace(&resource)
+	})
 	if err != nil {
 		return CollapsedResource{}, err
 	}
 
-	err = s.Update(resource)
+	return s.hideFields(resource.entity, resource)
+}
+
+// Update replaces the resource if the principal may update both its stored and its new version.
+// Fields the principal may not write and the meta section keep their stored values.
+func (s *Storage) Update(collapsedResource CollapsedResource) error {
+	return s.transaction(func(tx *Storage) error {
+		return tx.replace(&collapsedResource)
+	})
+}
+
+// replace implements Update and sets the resource to the stored version.
+func (s *Storage) replace(collapsedResource *CollapsedResource) error {
+	entity := collapsedResource.entity
+	stored, err := s.read(entity.Name, collapsedResource.ID)
 	if err != nil {
-		return CollapsedResource{}, err
+		return err
 	}
 
-	return resource, nil
+	collapsedResource.Meta = stored.Meta
+
+	err = s.authorize(entity.Name, OperationUpdate, stored)
+	if err != nil {
+		return err
+	}
+
+	if entity.Hooks.BeforeUpdate != nil {
+		err = entity.Hooks.BeforeUpdate(s, stored, collapsedResource)
+		if err != nil {
+			return err
+		}
+	}
+
+	*collapsedResource, err = s.protectResource(entity, *collapsedResource, &stored)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorize(entity.Name, OperationUpdate, *collapsedResource)
+	if err != nil {
+		return err
+	}
+
+	err = s.ValidateReferences(*collapsedResource)
+	if err != nil {
+		return err
+	}
+
+	err = s.update(collapsedResource)
+	if err != nil {
+		return err
+	}
+
+	err = s.mutated(OperationUpdate, entity.Name, collapsedResource.ID, &stored, collapsedResource, nil)
+	if err != nil {
+		return err
+	}
+
+	if entity.Hooks.AfterUpdate == nil {
+		return nil
+	}
+
+	return entity.Hooks.AfterUpdate(s, stored, *collapsedResource)
 }
 
-func (s *Storage) Update(collapsedResource CollapsedResource) error {
-	return s.repository.Update(collapsedResource.entity.Name, collapsedResource.ID, collapsedResource)
+// update stores the resource as updated by the principal. Its meta section has to be the stored one.
+func (s *Storage) update(collapsedResource *CollapsedResource) error {
+	collapsedResource.Meta.UpdatedAt = now()
+	collapsedResource.Meta.UpdatedBy = s.principal.ID
+	if collapsedResource.entity.Versioned {
+		collapsedResource.Meta.Version++
+	}
+
+	err := s.repository.Update(collapsedResource.entity.Name, collapsedResource.ID, *collapsedResource)
+	if err != nil {
+		return err
+	}
+
+	return s.recordRevision(*collapsedResource)
 }
 
 func (s *Storage) ReadAndExpand(entityName, id string) (Resource, error) {
@@ -82,6 +226,47 @@ func (s *Storage) Read(entityName, id string) (CollapsedResource, error) {
 		return CollapsedResource{}, UndefinedEntity{entityName}
 	}
 
+	query := Query{Q: map[string]FieldQuery{"ID": {Kind: QueryAnd, Values: []interface{}{id}}}}
+	err := s.beforeRead(entity, &query)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	result, err := s.read(entityName, id)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	if entity.Hooks.BeforeRead != nil {
+		document, err := toDocument(result)
+		if err != nil {
+			return CollapsedResource{}, err
+		}
+
+		if !query.Match(document) {
+			return CollapsedResource{}, NotFound{Entity: entityName, ID: id}
+		}
+	}
+
+	err = s.authorize(entityName, OperationRead, result)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	err = s.afterRead(entity, &result)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	return s.hideFields(result.entity, result)
+}
+
+func (s *Storage) read(entityName, id string) (CollapsedResource, error) {
+	entity, ok := s.entities.entitiesByName[entityName]
+	if !ok {
+		return CollapsedResource{}, UndefinedEntity{entityName}
+	}
+
 	result := entity.New().Collapse()
 
 	err := s.repository.Read(entity.Name, id, &result)
@@ -100,19 +285,154 @@ func (s *Storage) ReadAll(entityName string, query Query) ([]CollapsedResource,
 		return nil, UndefinedEntity{entityName}
 	}
 
+	err := s.beforeRead(entity, &query)
+	if err != nil {
+		return nil, err
+	}
+
+	query, filter, err := s.authorizeList(entity.Name, query)
+	if err != nil {
+		return nil, err
+	}
+
 	result := []CollapsedResource{}
-	err := s.repository.ReadAll(entity.Name, query, &result)
+	err = s.readAll(entity, entity.Name, query, &result)
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	allowed := []CollapsedResource{}
+	for _, resource := range result {
+		if filter != nil {
+			ok, err := filter(resource)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				continue
+			}
+		}
+
+		err = s.afterRead(entity, &resource)
+		if err != nil {
+			return nil, err
+		}
+
+		resource, err = s.hideFields(entity, resource)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed = append(allowed, resource)
+	}
+
+	return allowed, nil
+}
+
+var collapsedResourceType = reflect.TypeOf(CollapsedResource{})
+
+// readAll reads the documents of the collection into result, which points to a slice of CollapsedResource
+// or of structs containing one, decoding the data of the resources into the data type of the entity.
+// Repositories decode documents by the keys they encoded them with, e.g. lowercased field names in BSON,
+// so that the data has to be typed before it is decoded rather than converted afterwards.
+func (s *Storage) readAll(entity Entity, collectionName string, query Query, result interface{}) error {
+	return readAllTyped(s.repository, entity, collectionName, query, result)
 }
 
+// readAllTyped is readAll for any repository.
+func readAllTyped(repository Repository, entity Entity, collectionName string, query Query, result interface{}) error {
+	out := reflect.ValueOf(result).Elem()
+
+	typed := reflect.New(reflect.SliceOf(typedDocumentType(out.Type().Elem(), entity)))
+	err := repository.ReadAll(collectionName, query, typed.Interface())
+	if err != nil {
+		return err
+	}
+
+	documents := typed.Elem()
+	out.Set(reflect.MakeSlice(out.Type(), documents.Len(), documents.Len()))
+	for i := 0; i < documents.Len(); i++ {
+		copyTypedDocument(out.Index(i), documents.Index(i), entity)
+	}
+
+	return nil
+}
+
+// typedDocumentType returns a struct type encoded like t whose resources hold data of the type of the entity.
+func typedDocumentType(t reflect.Type, entity Entity) reflect.Type {
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		switch {
+		case t == collapsedResourceType && field.Name == "Data":
+			field.Type = reflect.PtrTo(entity.Data)
+		case field.Type == collapsedResourceType:
+			field.Type = typedDocumentType(field.Type, entity)
+		case field.Type == reflect.PtrTo(collapsedResourceType):
+			field.Type = reflect.PtrTo(typedDocumentType(collapsedResourceType, entity))
+		}
+
+		fields = append(fields, field)
+	}
+
+	return reflect.StructOf(fields)
+}
+
+// copyTypedDocument copies a document of the type returned by typedDocumentType into out.
+func copyTypedDocument(out, typed reflect.Value, entity Entity) {
+	for i := 0; i < typed.NumField(); i++ {
+		name := typed.Type().Field(i).Name
+		field := out.FieldByName(name)
+		value := typed.Field(i)
+
+		switch {
+		case field.Type() == collapsedResourceType:
+			copyTypedDocument(field, value, entity)
+			continue
+		case field.Type() == reflect.PtrTo(collapsedResourceType):
+			if !value.IsNil() {
+				field.Set(reflect.New(collapsedResourceType))
+				copyTypedDocument(field.Elem(), value.Elem(), entity)
+			}
+			continue
+		case out.Type() == collapsedResourceType && name == "Data" && value.IsNil():
+			value = reflect.New(entity.Data)
+		}
+
+		field.Set(value)
+	}
+
+	if out.Type() == collapsedResourceType {
+		out.Addr().Interface().(*CollapsedResource).entity = entity
+	}
+}
+
+// Expand replaces the references of the resource recursively by the referenced resources.
+// Besides expanding the resource the principal has to be allowed to read every referenced one.
 func (s *Storage) Expand(collapsedResource CollapsedResource) (Resource, error) {
+	err := s.authorize(collapsedResource.entity.Name, OperationExpand, collapsedResource)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	collapsedResource, err = s.hideFields(collapsedResource.entity, collapsedResource)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	return s.expand(collapsedResource)
+}
+
+func (s *Storage) expand(collapsedResource CollapsedResource) (Resource, error) {
 	resource := Resource{}
 	resource.ID = collapsedResource.ID
 	resource.Data = collapsedResource.Data
+	resource.Meta = collapsedResource.Meta
 	resource.entity = collapsedResource.entity
 	resource.References = make(map[string][]Resource, len(collapsedResource.References))
 
@@ -126,7 +446,7 @@ func (s *Storage) Expand(collapsedResource CollapsedResource) (Resource, error)
 				return Resource{}, err
 			}
 
-			referencedResource, err := s.Expand(result)
+			referencedResource, err := s.expand(result)
 			if err != nil {
 				return Resource{}, err
 			}
@@ -138,7 +458,18 @@ func (s *Storage) Expand(collapsedResource CollapsedResource) (Resource, error)
 	return resource, nil
 }
 
+// GetReferencedBy returns the IDs of all resources referencing the resource by entity and relation.
+// Only resources the principal may list are included.
 func (s *Storage) GetReferencedBy(entityName, id string) (map[string]map[string][]string, error) {
+	err := s.authorizeStored(entityName, id, OperationReferencedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getReferencedBy(entityName, id, true)
+}
+
+func (s *Storage) getReferencedBy(entityName, id string, restricted bool) (map[string]map[string][]string, error) {
 	referencedBy, err := s.entities.CreateReferencedByMap(entityName)
 	if err != nil {
 		return nil, err
@@ -148,8 +479,17 @@ func (s *Storage) GetReferencedBy(entityName, id string) (map[string]map[string]
 		for relationName := range references {
 			query := Query{Q: make(map[string]FieldQuery, len(references))}
 			query.Q["references."+relationName] = FieldQuery{Kind: QueryContains, Values: []interface{}{id}}
-			result := []CollapsedResource{}
-			err = s.repository.ReadAll(referencingEntityName, query, &result)
+
+			var result []CollapsedResource
+			if restricted {
+				result, err = s.ReadAll(referencingEntityName, query)
+				if _, ok := err.(Forbidden); ok {
+					continue
+				}
+			} else {
+				result = []CollapsedResource{}
+				err = s.repository.ReadAll(referencingEntityName, query, &result)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -164,44 +504,252 @@ func (s *Storage) GetReferencedBy(entityName, id string) (map[string]map[string]
 }
 
 // Deletes the resource and all references to it.
+// Removing the references is part of the purge and needs no further permissions.
+// Resources of soft deleting entities are moved to the trash instead.
 func (s *Storage) Purge(entityName, id string) error {
-	referencedBy, err := s.GetReferencedBy(entityName, id)
+	err := s.authorizeStored(entityName, id, OperationPurge)
+	if err != nil {
+		return err
+	}
+
+	return s.transaction(func(tx *Storage) error {
+		return tx.remove(entityName, id, OperationPurge)
+	})
+}
+
+// Delete deletes the resource, or moves it to the trash if its entity soft deletes.
+func (s *Storage) Delete(entityName, id string) error {
+	err := s.authorizeStored(entityName, id, OperationDelete)
 	if err != nil {
 		return err
 	}
 
+	return s.transaction(func(tx *Storage) error {
+		return tx.remove(entityName, id, OperationDelete)
+	})
+}
+
+// remove implements Purge and Delete, of which only purges remove references.
+func (s *Storage) remove(entityName, id string, operation Operation) error {
+	entity := s.entities.entitiesByName[entityName]
+	if entity.SoftDelete {
+		return s.trash(entity, id, operation)
+	}
+
+	removed, err := s.beforeDelete(entity, id)
+	if err != nil {
+		return err
+	}
+
+	if operation == OperationPurge {
+		_, err = s.removeReferences(entityName, id, operation)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.repository.Delete(entityName, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.deleteHistory(entityName, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.mutated(operation, entityName, id, removed, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.afterDelete(entity, removed)
+}
+
+// removeReferences removes all references to the resource and returns them by entity and relation.
+func (s *Storage) removeReferences(entityName, id string, operation Operation) (map[string]map[string][]string, error) {
+	referencedBy, err := s.getReferencedBy(entityName, id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	cause := &AuditCause{Entity: entityName, ResourceID: id, Operation: operation}
 	for referencingEntityName, references := range referencedBy {
 		for relationName, referenceIDs := range references {
 			for _, referenceID := range referenceIDs {
-				reference, err := s.Read(referencingEntityName, referenceID)
-				if err != nil {
-					return err
-				}
-
-				newReferences := []string{}
-				for _, v := range reference.References[relationName] {
-					if v != id {
-						newReferences = append(newReferences, v)
+				err = s.changeReferences(referencingEntityName, referenceID, relationName, cause, func(ids []string) []string {
+					remaining := []string{}
+					for _, v := range ids {
+						if v != id {
+							remaining = append(remaining, v)
+						}
 					}
-				}
 
-				reference.References[relationName] = newReferences
-				err = s.Update(reference)
+					return remaining
+				})
 				if err != nil {
-					return err
+					return nil, err
 				}
 			}
 		}
 	}
 
-	return s.repository.Delete(entityName, id)
+	return referencedBy, nil
 }
 
-func (s *Storage) Delete(entityName, id string) error {
-	return s.repository.Delete(entityName, id)
+// changeReferences changes the references of a single relation of a resource as a consequence of the cause.
+func (s *Storage) changeReferences(entityName, id, relationName string, cause *AuditCause, change func([]string) []string) error {
+	resource, err := s.read(entityName, id)
+	if err != nil {
+		return err
+	}
+
+	updated := resource
+	updated.References = make(map[string][]string, len(resource.References))
+	for k, v := range resource.References {
+		updated.References[k] = v
+	}
+	updated.References[relationName] = change(resource.References[relationName])
+
+	// Removals may leave fewer references than needed, as the referenced resources are gone, additions may not
+	// exceed the maximum.
+	count := len(updated.References[relationName])
+	cardinality := s.entities.entitiesByName[entityName].Cardinalities[relationName]
+	if count > len(resource.References[relationName]) && !cardinality.Allows(count) {
+		return CardinalityViolation{Entity: entityName, Relation: relationName, Count: count, Cardinality: cardinality}
+	}
+
+	err = s.update(&updated)
+	if err != nil {
+		return err
+	}
+
+	return s.mutated(OperationUpdate, entityName, id, &resource, &updated, cause)
 }
 
-func (s *Storage) createCollapsedResourceFromJSON(entityName, jsonDocument string) (CollapsedResource, error) {
+// mutated records a mutation in the audit log and publishes it as event.
+// Audit records stored in the repository are written right away, so that they are part of the transaction.
+// Anything else happens once the transaction is committed.
+func (s *Storage) mutated(operation Operation, entityName, id string, before, after *CollapsedResource, cause *AuditCause) error {
+	m := mutation{operation, entityName, id, before, after, cause}
+	if s.auditsInRepository() {
+		err := s.audit(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.pending != nil {
+		*s.pending = append(*s.pending, m)
+		return nil
+	}
+
+	s.committed(m)
+
+	return nil
+}
+
+// committed records a written mutation in an audit sink outside of the repository and publishes it.
+// As the mutation can't be undone anymore, errors of the sink are logged.
+func (s *Storage) committed(m mutation) {
+	if !s.auditsInRepository() {
+		err := s.audit(m)
+		if err != nil {
+			log.Printf("cannot audit the %s of %s %q: %v", m.operation, m.entityName, m.id, err)
+		}
+	}
+
+	s.publish(m.operation, m.entityName, m.id, m.before, m.after, m.cause)
+}
+
+// generateID generates the ID of a new resource with the generator of its entity or the one of the storage.
+func (s *Storage) generateID(entity Entity, resource CollapsedResource) (string, error) {
+	generator := entity.IDGenerator
+	if generator == nil {
+		generator = s.idGenerator
+	}
+
+	if bound, ok := generator.(RepositoryIDGenerator); ok {
+		generator = bound.WithRepository(s.repository)
+	}
+
+	return generator.Generate(entity, resource)
+}
+
+// newMeta returns the meta section of a resource of the entity created by the principal.
+func (s *Storage) newMeta(entity Entity) Metadata {
+	t := now()
+
+	meta := Metadata{CreatedAt: t, UpdatedAt: t, CreatedBy: s.principal.ID, UpdatedBy: s.principal.ID}
+	if entity.Versioned {
+		meta.Version = 1
+	}
+
+	return meta
+}
+
+// now returns the current time as stored in the meta section.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// snapshot reads the resource for the audit log and events, if there are any.
+func (s *Storage) snapshot(entityName, id string) (*CollapsedResource, error) {
+	if s.auditSink == nil && s.events == nil {
+		return nil, nil
+	}
+
+	resource, err := s.read(entityName, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resource, nil
+}
+
+// authorizeStored authorizes an operation on a stored resource, reading it only if there is a policy.
+func (s *Storage) authorizeStored(entityName, id string, operation Operation) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	resource, err := s.read(entityName, id)
+	if err != nil {
+		return err
+	}
+
+	return s.authorize(entityName, operation, resource)
+}
+
+// existingIDs looks up which of the given IDs exist with a single query.
+func (s *Storage) existingIDs(entityName string, ids []string) (map[string]bool, error) {
+	existing := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	query := Query{Q: map[string]FieldQuery{"ID": {Kind: QueryContains, Values: values}}}
+	result := []CollapsedResource{}
+	err := s.repository.ReadAll(entityName, query, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range result {
+		existing[row.ID] = true
+	}
+
+	return existing, nil
+}
+
+// createCollapsedResourceFromJSON decodes the document keeping fields the principal may not write as stored.
+// The stored resource of an update is the one with the given ID or, if it is empty, the ID of the document.
+func (s *Storage) createCollapsedResourceFromJSON(entityName, jsonDocument string, update bool, id string) (CollapsedResource, error) {
 	entity, ok := s.entities.entitiesByName[entityName]
 	if !ok {
 		return CollapsedResource{}, UndefinedEntity{entityName}
@@ -209,7 +757,16 @@ func (s *Storage) createCollapsedResourceFromJSON(entityName, jsonDocument strin
 
 	resource := entity.New().Collapse()
 
-	err := json.Unmarshal([]byte(jsonDocument), &resource)
+	content := []byte(jsonDocument)
+	if len(entity.Fields) != 0 {
+		var err error
+		content, err = s.protectJSON(entity, content, update, id)
+		if err != nil {
+			return CollapsedResource{}, err
+		}
+	}
+
+	err := json.Unmarshal(content, &resource)
 	if err != nil {
 		return CollapsedResource{}, err
 	}
@@ -0,0 +1,101 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/bolt"
+	"github.com/DanShu93/jsonmancer/id"
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// testData is the data of the resources most storage tests use.
+type testData struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	Notes string `json:"notes,omitempty"`
+}
+
+// nonTransactional hides the transactions of a repository, making it behave like mongo.
+type nonTransactional struct {
+	storage.Repository
+}
+
+// openRepository opens a bolt repository in a temporary directory, which the returned function removes.
+func openRepository(t *testing.T) (bolt.Repository, func()) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := bolt.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository, func() {
+		repository.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newStorage creates a storage of the entities backed by a new bolt repository.
+func newStorage(t *testing.T, entities []storage.Entity, options ...storage.Option) (storage.Storage, func()) {
+	repository, release := openRepository(t)
+
+	return newStorageWith(t, repository, entities, options...), release
+}
+
+// newStorageWith creates a storage of the entities backed by the repository.
+func newStorageWith(t *testing.T, repository storage.Repository, entities []storage.Entity, options ...storage.Option) storage.Storage {
+	s, err := storage.New(entities, repository, id.UUIDv4{}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// readNames lists the names of the resources of the entity by ID.
+func readNames(t *testing.T, s storage.Storage, entityName string) map[string]string {
+	resources, err := s.ReadAll(entityName, storage.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]string{}
+	for _, resource := range resources {
+		names[resource.ID] = dataName(t, resource)
+	}
+
+	return names
+}
+
+// dataName returns the name of the data of the resource, whichever type it is decoded into.
+func dataName(t *testing.T, resource storage.CollapsedResource) string {
+	content, err := json.Marshal(resource.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testData{}
+	err = json.Unmarshal(content, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data.Name
+}
+
+// create creates a resource of the entity with the given name and returns its ID.
+func create(t *testing.T, s storage.Storage, entityName, name string) string {
+	resource, err := s.CreateFromJSON(entityName, `{"data":{"name":"`+name+`"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resource.ID
+}
@@ -0,0 +1,197 @@
+package storagetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/DanShu93/jsonmancer/id"
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// Account is the payload of the resources the storage tests store. Unlike those of Data its json names
+// differ from the bson names the fields default to, as they do for most data types of storages.
+// Its protected fields are omitted from the JSON of the principals who may not read them.
+type Account struct {
+	OwnerID string `json:"ownerId"`
+	Balance int    `json:"balance,omitempty"`
+	PIN     string `json:"pin,omitempty"`
+}
+
+const AccountEntityName = "account"
+
+var accountEntity = storage.Entity{
+	Name: AccountEntityName,
+	Data: reflect.TypeOf(Account{}),
+	Fields: map[string]storage.FieldPermission{
+		"balance": {ReadRoles: []string{"auditor"}},
+		"pin":     {Hidden: true},
+	},
+}
+
+// accountPolicy lets principals read, update and audit their own accounts and auditors list every account.
+var accountPolicy = storage.Policy{
+	AccountEntityName: {
+		storage.OperationCreate: {{}},
+		storage.OperationRead:   {{Where: map[string]string{"data.ownerId": "id"}}},
+		storage.OperationList: {
+			{Where: map[string]string{"data.ownerId": "id"}},
+			{Roles: []string{"auditor"}},
+		},
+		storage.OperationUpdate: {{Where: map[string]string{"data.ownerId": "id"}}},
+		storage.OperationAudit:  {{Where: map[string]string{"data.ownerId": "id"}}},
+	},
+}
+
+func newAccountStorage(t *testing.T, r storage.Repository, options ...storage.Option) storage.Storage {
+	s, err := storage.New([]storage.Entity{accountEntity}, r, id.UUIDv4{}, append(options, storage.WithPolicy(accountPolicy))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// createAccount creates an account of the owner as the owner and returns its ID.
+func createAccount(t *testing.T, s storage.Storage, ownerID string, balance int) string {
+	owner := s.As(storage.Principal{ID: ownerID})
+	resource, err := owner.CreateFromJSON(AccountEntityName, fmt.Sprintf(`{"data":{"ownerId":%q,"balance":%d,"pin":"1234"}}`, ownerID, balance))
+	if err != nil {
+		t.Fatalf("CreateFromJSON: %s", err)
+	}
+
+	return resource.ID
+}
+
+func testFieldPermissions(t *testing.T, r storage.Repository) {
+	s := newAccountStorage(t, r)
+	accountID := createAccount(t, s, "alice", 10)
+
+	tests := []struct {
+		name      string
+		principal storage.Principal
+		expected  map[string]interface{}
+	}{
+		{"owner", storage.Principal{ID: "alice"}, map[string]interface{}{"ownerId": "alice"}},
+		{"auditor", storage.Principal{ID: "alice", Roles: []string{"auditor"}}, map[string]interface{}{"ownerId": "alice", "balance": 10.0}},
+	}
+
+	for _, test := range tests {
+		principal := s.As(test.principal)
+
+		resource, err := principal.Read(AccountEntityName, accountID)
+		if err != nil {
+			t.Fatalf("%s: Read: %s", test.name, err)
+		}
+		expectDocument(t, test.name+": Read", test.expected, resource.Data)
+		expectAccount(t, test.name+": Read", resource.Data)
+
+		resources, err := principal.ReadAll(AccountEntityName, storage.Query{})
+		if err != nil {
+			t.Fatalf("%s: ReadAll: %s", test.name, err)
+		}
+		if len(resources) != 1 {
+			t.Fatalf("%s: expected a single account, got %d", test.name, len(resources))
+		}
+		expectDocument(t, test.name+": ReadAll", test.expected, resources[0].Data)
+		expectAccount(t, test.name+": ReadAll", resources[0].Data)
+	}
+}
+
+func testAuditFieldPermissions(t *testing.T, r storage.Repository) {
+	s := newAccountStorage(t, r, storage.WithAuditSink(storage.RepositoryAuditSink{Repository: r}))
+	accountID := createAccount(t, s, "alice", 10)
+
+	owner := s.As(storage.Principal{ID: "alice"})
+	_, err := owner.UpdateFromJSON(AccountEntityName, fmt.Sprintf(`{"id":%q,"data":{"ownerId":"alice","balance":20,"pin":"4321"}}`, accountID))
+	if err != nil {
+		t.Fatalf("UpdateFromJSON: %s", err)
+	}
+
+	records, err := owner.AuditTrail(AccountEntityName, accountID)
+	if err != nil {
+		t.Fatalf("AuditTrail: %s", err)
+	}
+	if len(records) != 2 || records[0].After == nil || records[1].Before == nil || records[1].After == nil {
+		t.Fatalf("expected the create and the update, got %+v", records)
+	}
+
+	expected := map[string]interface{}{"ownerId": "alice"}
+	expectDocument(t, "created", expected, records[0].After.Data)
+	expectDocument(t, "before the update", expected, records[1].Before.Data)
+	expectDocument(t, "updated", expected, records[1].After.Data)
+
+	other := s.As(storage.Principal{ID: "bob"})
+	_, err = other.AuditTrail(AccountEntityName, accountID)
+	if _, ok := err.(storage.Forbidden); !ok {
+		t.Errorf("expected other principals not to audit the account, got %v", err)
+	}
+}
+
+func testListPolicy(t *testing.T, r storage.Repository) {
+	s := newAccountStorage(t, r)
+	aliceAccounts := []string{createAccount(t, s, "alice", 1), createAccount(t, s, "alice", 2)}
+	bobAccount := createAccount(t, s, "bob", 3)
+
+	tests := []struct {
+		name      string
+		principal storage.Principal
+		query     storage.Query
+		expected  []string
+	}{
+		{"owner", storage.Principal{ID: "alice"}, storage.Query{}, aliceAccounts},
+		{"other owner", storage.Principal{ID: "bob"}, storage.Query{}, []string{bobAccount}},
+		{"without accounts", storage.Principal{ID: "carol"}, storage.Query{}, []string{}},
+		{"colliding query", storage.Principal{ID: "alice"}, and("data.ownerId", "bob"), []string{}},
+		{"auditor", storage.Principal{ID: "carol", Roles: []string{"auditor"}}, storage.Query{}, append([]string{bobAccount}, aliceAccounts...)},
+	}
+
+	for _, test := range tests {
+		principal := s.As(test.principal)
+
+		resources, err := principal.ReadAll(AccountEntityName, test.query)
+		if err != nil {
+			t.Fatalf("%s: ReadAll: %s", test.name, err)
+		}
+
+		ids := []string{}
+		for _, resource := range resources {
+			ids = append(ids, resource.ID)
+		}
+		sort.Strings(ids)
+
+		expected := append([]string{}, test.expected...)
+		sort.Strings(expected)
+
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, ids)
+		}
+	}
+}
+
+// expectAccount checks that the data keeps the type of the entity.
+func expectAccount(t *testing.T, name string, data interface{}) {
+	if _, ok := data.(*Account); !ok {
+		t.Errorf("%s: expected the data to be an account, got %T", name, data)
+	}
+}
+
+// expectDocument compares the JSON encoding of data with the expected document.
+func expectDocument(t *testing.T, name string, expected map[string]interface{}, data interface{}) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := map[string]interface{}{}
+	err = json.Unmarshal(content, &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, actual)
+	}
+}
@@ -0,0 +1,511 @@
+// Package storagetest provides a conformance suite for implementations of storage.Repository,
+// checking them by themselves and backing a storage.
+package storagetest
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// Data is the payload of the resources the suite stores.
+// Its json and bson names agree so that queries look the same for every backend.
+// The hyphenated name of Label checks that field names need not be identifiers.
+type Data struct {
+	Name  string   `json:"name" bson:"name"`
+	Count int      `json:"count" bson:"count"`
+	Tags  []string `json:"tags" bson:"tags"`
+	Label string   `json:"short-label" bson:"short-label"`
+}
+
+const (
+	ReferencingEntityName = "article"
+	ReferencedEntityName  = "author"
+)
+
+var referencedEntity = storage.Entity{
+	Name: ReferencedEntityName,
+	Data: reflect.TypeOf(Data{}),
+}
+
+var referencingEntity = storage.Entity{
+	Name:       ReferencingEntityName,
+	Data:       reflect.TypeOf(Data{}),
+	References: map[string]storage.Entity{"authors": referencedEntity},
+}
+
+// Entities are the entities whose collections the suite uses.
+var Entities = []storage.Entity{referencingEntity, referencedEntity}
+
+// Factory returns an empty repository for a single test and a function releasing it.
+type Factory func(t *testing.T) (repository storage.Repository, release func())
+
+// Run checks the contracts every storage.Repository has to honor.
+func Run(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, r storage.Repository)
+	}{
+		{"ReadMissing", testReadMissing},
+		{"CreateAndRead", testCreateAndRead},
+		{"CreateDuplicate", testCreateDuplicate},
+		{"CreateAll", testCreateAll},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"Delete", testDelete},
+		{"DeleteMissing", testDeleteMissing},
+		{"ReadAllEmpty", testReadAllEmpty},
+		{"ReadAll", testReadAll},
+		{"Query", testQuery},
+		{"QueryMeta", testQueryMeta},
+		{"QueryHyphenatedField", testQueryHyphenatedField},
+		{"Sort", testSort},
+		{"CollectionsAreSeparate", testCollectionsAreSeparate},
+		{"TransactionCommit", testTransactionCommit},
+		{"TransactionRollback", testTransactionRollback},
+		{"FieldPermissions", testFieldPermissions},
+		{"AuditFieldPermissions", testAuditFieldPermissions},
+		{"ListPolicy", testListPolicy},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			repository, release := newRepository(t)
+			defer release()
+
+			test.test(t, repository)
+		})
+	}
+}
+
+func testReadMissing(t *testing.T, r storage.Repository) {
+	result := referencedEntity.New().Collapse()
+	err := r.Read(ReferencedEntityName, "missing", &result)
+	expectNotFound(t, err, ReferencedEntityName, "missing")
+}
+
+func testCreateAndRead(t *testing.T, r storage.Repository) {
+	expected := fixture("1", "a", 1, "10", "11")
+	create(t, r, ReferencingEntityName, expected)
+
+	result := referencingEntity.New().Collapse()
+	err := r.Read(ReferencingEntityName, "1", &result)
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	expectResource(t, expected, result)
+}
+
+func testCreateDuplicate(t *testing.T, r storage.Repository) {
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1))
+
+	err := r.Create(ReferencingEntityName, fixture("1", "b", 2))
+	if err == nil {
+		t.Fatal("Create of a duplicate ID must fail")
+	}
+
+	result := referencingEntity.New().Collapse()
+	err = r.Read(ReferencingEntityName, "1", &result)
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	expectResource(t, fixture("1", "a", 1), result)
+}
+
+func testCreateAll(t *testing.T, r storage.Repository) {
+	err := r.CreateAll(ReferencingEntityName, []interface{}{})
+	if err != nil {
+		t.Fatalf("CreateAll without data: %s", err)
+	}
+
+	expected := []storage.CollapsedResource{fixture("1", "a", 1, "10"), fixture("2", "b", 2, "11")}
+	err = r.CreateAll(ReferencingEntityName, []interface{}{expected[0], expected[1]})
+	if err != nil {
+		t.Fatalf("CreateAll: %s", err)
+	}
+
+	for _, resource := range expected {
+		result := referencingEntity.New().Collapse()
+		err = r.Read(ReferencingEntityName, resource.ID, &result)
+		if err != nil {
+			t.Fatalf("Read: %s", err)
+		}
+
+		expectResource(t, resource, result)
+	}
+}
+
+func testUpdate(t *testing.T, r storage.Repository) {
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1, "10"))
+
+	expected := fixture("1", "b", 2, "11", "12")
+	err := r.Update(ReferencingEntityName, "1", expected)
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	result := referencingEntity.New().Collapse()
+	err = r.Read(ReferencingEntityName, "1", &result)
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	expectResource(t, expected, result)
+}
+
+func testUpdateMissing(t *testing.T, r storage.Repository) {
+	err := r.Update(ReferencingEntityName, "missing", fixture("missing", "a", 1))
+	expectNotFound(t, err, ReferencingEntityName, "missing")
+}
+
+func testDelete(t *testing.T, r storage.Repository) {
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1, "10"))
+	create(t, r, ReferencingEntityName, fixture("2", "b", 2, "10"))
+
+	err := r.Delete(ReferencingEntityName, "1")
+	if err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	result := referencingEntity.New().Collapse()
+	err = r.Read(ReferencingEntityName, "1", &result)
+	expectNotFound(t, err, ReferencingEntityName, "1")
+
+	expectIDs(t, r, contains("references.authors", "10"), "2")
+}
+
+func testDeleteMissing(t *testing.T, r storage.Repository) {
+	err := r.Delete(ReferencingEntityName, "missing")
+	expectNotFound(t, err, ReferencingEntityName, "missing")
+}
+
+func testReadAllEmpty(t *testing.T, r storage.Repository) {
+	result := []storage.CollapsedResource{}
+	err := r.ReadAll(ReferencingEntityName, storage.Query{}, &result)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+
+	if len(result) != 0 {
+		t.Errorf("expected no resources, got %v", result)
+	}
+}
+
+func testReadAll(t *testing.T, r storage.Repository) {
+	expected := []storage.CollapsedResource{fixture("1", "a", 1, "10"), fixture("2", "b", 2)}
+	for _, resource := range expected {
+		create(t, r, ReferencingEntityName, resource)
+	}
+
+	result := []storage.CollapsedResource{}
+	err := r.ReadAll(ReferencingEntityName, storage.Query{}, &result)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d resources, got %d", len(expected), len(result))
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	for i := range expected {
+		expectResource(t, expected[i], result[i])
+	}
+}
+
+func testQuery(t *testing.T, r storage.Repository) {
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1, "10", "11"))
+	create(t, r, ReferencingEntityName, fixture("2", "b", 2, "11"))
+	create(t, r, ReferencingEntityName, fixture("3", "c", 3))
+
+	tests := []struct {
+		name     string
+		query    storage.Query
+		expected []string
+	}{
+		{"ID", and("ID", "2"), []string{"2"}},
+		{"ID contains", contains("ID", "1", "3"), []string{"1", "3"}},
+		{"and", and("data.name", "a"), []string{"1"}},
+		{"and number", and("data.count", 2), []string{"2"}},
+		{"or", or("data.name", "a", "c"), []string{"1", "3"}},
+		{"contains scalar", contains("data.count", 2, 3), []string{"2", "3"}},
+		{"contains reference", contains("references.authors", "11"), []string{"1", "2"}},
+		{"contains any reference", contains("references.authors", "10", "12"), []string{"1"}},
+		{"equal array element", and("data.tags", "b"), []string{"2"}},
+		{"no match", contains("references.authors", "missing"), nil},
+		{"combined", storage.Query{Q: map[string]storage.FieldQuery{
+			"references.authors": {Kind: storage.QueryContains, Values: []interface{}{"11"}},
+			"data.name":          {Kind: storage.QueryAnd, Values: []interface{}{"b"}},
+		}}, []string{"2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectIDs(t, r, test.query, test.expected...)
+		})
+	}
+}
+
+func testQueryMeta(t *testing.T, r storage.Repository) {
+	created := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := fixture("1", "a", 1)
+	first.Meta = storage.Metadata{CreatedAt: created, UpdatedAt: created, CreatedBy: "alice"}
+	create(t, r, ReferencingEntityName, first)
+
+	second := fixture("2", "b", 2)
+	second.Meta = storage.Metadata{CreatedAt: created.Add(time.Hour), UpdatedAt: created.Add(time.Hour), CreatedBy: "bob"}
+	create(t, r, ReferencingEntityName, second)
+
+	expectIDs(t, r, and("meta.createdBy", "bob"), "2")
+	expectIDs(t, r, and("meta.createdAt", created), "1")
+
+	result := referencingEntity.New().Collapse()
+	err := r.Read(ReferencingEntityName, "1", &result)
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+
+	if !result.Meta.CreatedAt.Equal(created) || result.Meta.CreatedBy != "alice" {
+		t.Errorf("expected meta %#v, got %#v", first.Meta, result.Meta)
+	}
+}
+
+func testQueryHyphenatedField(t *testing.T, r storage.Repository) {
+	for _, labeled := range []struct{ id, label string }{{"1", "b"}, {"2", "a"}, {"3", "b"}} {
+		resource := fixture(labeled.id, labeled.id, 1)
+		data := resource.Data.(Data)
+		data.Label = labeled.label
+		resource.Data = data
+		create(t, r, ReferencingEntityName, resource)
+	}
+
+	expectIDs(t, r, and("data.short-label", "b"), "1", "3")
+
+	result := []storage.CollapsedResource{}
+	err := r.ReadAll(ReferencingEntityName, storage.Query{Sort: []string{"data.short-label", "-ID"}}, &result)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+
+	ids := []string{}
+	for _, resource := range result {
+		ids = append(ids, resource.ID)
+	}
+
+	if expected := []string{"2", "3", "1"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+}
+
+func testSort(t *testing.T, r storage.Repository) {
+	create(t, r, ReferencingEntityName, fixture("1", "b", 2))
+	create(t, r, ReferencingEntityName, fixture("2", "a", 3))
+	create(t, r, ReferencingEntityName, fixture("3", "b", 1))
+
+	tests := []struct {
+		name     string
+		query    storage.Query
+		expected []string
+	}{
+		{"ascending", storage.Query{Sort: []string{"data.count"}}, []string{"3", "1", "2"}},
+		{"descending", storage.Query{Sort: []string{"-data.count"}}, []string{"2", "1", "3"}},
+		{"ID", storage.Query{Sort: []string{"-ID"}}, []string{"3", "2", "1"}},
+		{"multiple fields", storage.Query{Sort: []string{"data.name", "-data.count"}}, []string{"2", "1", "3"}},
+		{"filtered", storage.Query{Q: and("data.name", "b").Q, Sort: []string{"data.count"}}, []string{"3", "1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := []storage.CollapsedResource{}
+			err := r.ReadAll(ReferencingEntityName, test.query, &result)
+			if err != nil {
+				t.Fatalf("ReadAll: %s", err)
+			}
+
+			ids := []string{}
+			for _, resource := range result {
+				ids = append(ids, resource.ID)
+			}
+
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func testCollectionsAreSeparate(t *testing.T, r storage.Repository) {
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1))
+
+	result := referencedEntity.New().Collapse()
+	err := r.Read(ReferencedEntityName, "1", &result)
+	expectNotFound(t, err, ReferencedEntityName, "1")
+
+	resources := []storage.CollapsedResource{}
+	err = r.ReadAll(ReferencedEntityName, storage.Query{}, &resources)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+
+	if len(resources) != 0 {
+		t.Errorf("expected no resources in %q, got %v", ReferencedEntityName, resources)
+	}
+}
+
+func testTransactionCommit(t *testing.T, r storage.Repository) {
+	transactional, ok := r.(storage.TransactionalRepository)
+	if !ok {
+		t.Skip("repository is not transactional")
+	}
+
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1))
+
+	err := transactional.Transaction(func(tx storage.Repository) error {
+		create(t, tx, ReferencingEntityName, fixture("2", "b", 2))
+
+		err := tx.Update(ReferencingEntityName, "1", fixture("1", "c", 3))
+		if err != nil {
+			return err
+		}
+
+		result := referencingEntity.New().Collapse()
+		err = tx.Read(ReferencingEntityName, "1", &result)
+		if err != nil {
+			return err
+		}
+
+		expectResource(t, fixture("1", "c", 3), result)
+		expectIDs(t, tx, storage.Query{}, "1", "2")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction: %s", err)
+	}
+
+	expectIDs(t, r, and("data.name", "b"), "2")
+	expectIDs(t, r, and("data.name", "c"), "1")
+}
+
+type rollback struct{}
+
+func (rollback) Error() string {
+	return "rollback"
+}
+
+func testTransactionRollback(t *testing.T, r storage.Repository) {
+	transactional, ok := r.(storage.TransactionalRepository)
+	if !ok {
+		t.Skip("repository is not transactional")
+	}
+
+	create(t, r, ReferencingEntityName, fixture("1", "a", 1))
+
+	err := transactional.Transaction(func(tx storage.Repository) error {
+		create(t, tx, ReferencingEntityName, fixture("2", "b", 2))
+
+		err := tx.Delete(ReferencingEntityName, "1")
+		if err != nil {
+			return err
+		}
+
+		return rollback{}
+	})
+	if _, ok := err.(rollback); !ok {
+		t.Fatalf("expected the error of the function, got %#v", err)
+	}
+
+	expectIDs(t, r, storage.Query{}, "1")
+}
+
+func fixture(id, name string, count int, references ...string) storage.CollapsedResource {
+	resource := referencingEntity.New().Collapse()
+	resource.ID = id
+	resource.Data = Data{Name: name, Count: count, Tags: []string{name}}
+	resource.References["authors"] = append([]string{}, references...)
+
+	return resource
+}
+
+func and(field string, values ...interface{}) storage.Query {
+	return storage.Query{Q: map[string]storage.FieldQuery{field: {Kind: storage.QueryAnd, Values: values}}}
+}
+
+func or(field string, values ...interface{}) storage.Query {
+	return storage.Query{Q: map[string]storage.FieldQuery{field: {Kind: storage.QueryOr, Values: values}}}
+}
+
+func contains(field string, values ...interface{}) storage.Query {
+	return storage.Query{Q: map[string]storage.FieldQuery{field: {Kind: storage.QueryContains, Values: values}}}
+}
+
+func create(t *testing.T, r storage.Repository, collectionName string, resource storage.CollapsedResource) {
+	err := r.Create(collectionName, resource)
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+}
+
+func expectNotFound(t *testing.T, err error, collectionName, id string) {
+	notFound, ok := err.(storage.NotFound)
+	if !ok {
+		t.Fatalf("expected storage.NotFound, got %#v", err)
+	}
+
+	if notFound.Entity != collectionName || notFound.ID != id {
+		t.Errorf("expected %#v, got %#v", storage.NotFound{Entity: collectionName, ID: id}, notFound)
+	}
+}
+
+func expectIDs(t *testing.T, r storage.Repository, q storage.Query, expected ...string) {
+	result := []storage.CollapsedResource{}
+	err := r.ReadAll(ReferencingEntityName, q, &result)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+
+	ids := []string{}
+	for _, resource := range result {
+		ids = append(ids, resource.ID)
+	}
+	sort.Strings(ids)
+
+	if len(ids) == 0 && len(expected) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+}
+
+func expectResource(t *testing.T, expected, actual storage.CollapsedResource) {
+	if actual.ID != expected.ID {
+		t.Errorf("expected ID %q, got %q", expected.ID, actual.ID)
+	}
+
+	data := Data{}
+	content, err := json.Marshal(actual.Data)
+	if err == nil {
+		err = json.Unmarshal(content, &data)
+	}
+	if err != nil {
+		t.Fatalf("cannot decode data %#v: %s", actual.Data, err)
+	}
+
+	if !reflect.DeepEqual(data, expected.Data) {
+		t.Errorf("expected data %#v, got %#v", expected.Data, data)
+	}
+
+	if !reflect.DeepEqual(actual.References, expected.References) {
+		t.Errorf("expected references %v, got %v", expected.References, actual.References)
+	}
+}
@@ -1,29 +1,31 @@
 package storage
 
 import (
+	"encoding"
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"bytes"
+	"regexp"
 	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// CreateSwaggerFile describes the API in Swagger 2.0 as indented JSON with sorted keys.
+// The host is omitted if it is empty, so clients use the one serving the file.
 func CreateSwaggerFile(entities Entities, info Info, host string) (string, error) {
-	paths := map[string]interface{}{
-		fmt.Sprintf("/%s/%s", Meta, MetaActionSwaggerFile): map[string]interface{}{
-			"get": map[string]interface{}{
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "This swagger file",
-					},
-				},
-			},
-		},
-	}
+	paths := swaggerMetaPaths()
 
 	definitions := map[string]interface{}{}
+	err := addServiceDefinitions(definitions)
+	if err != nil {
+		return "", err
+	}
 
-	for entityName, entity := range entities.entitiesByName {
+	for _, entity := range entities.All() {
+		entityName := entity.Name
 		schemaReference := map[string]interface{}{
 			"$ref": "#/definitions/" + entityName,
 		}
@@ -37,38 +39,28 @@ func CreateSwaggerFile(entities Entities, info Info, host string) (string, error
 			"type":        "string",
 		}
 
-		bodyParameter := map[string]interface{}{
-			"name":        "body",
-			"in":          "body",
-			"description": "ID of the " + entityName,
-			"required":    true,
-			"schema":      schemaReference,
+		bodyParameter := func(description string) map[string]interface{} {
+			return map[string]interface{}{
+				"name":        "body",
+				"in":          "body",
+				"description": description,
+				"required":    true,
+				"schema":      schemaReference,
+			}
 		}
 
 		paths["/"+entityName] = map[string]interface{}{
 			"get": map[string]interface{}{
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "All matching " + entityName,
-						"schema": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"$ref": "#/definitions/" + entityName,
-							},
-						},
-					},
-				},
+				"responses": swaggerResponses("200", "All matching "+entityName, map[string]interface{}{
+					"type":  "array",
+					"items": schemaReference,
+				}, http.StatusBadRequest),
 			},
 			"post": map[string]interface{}{
 				"parameters": []interface{}{
-					bodyParameter,
-				},
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "The created " + entityName,
-						"schema":      schemaReference,
-					},
+					bodyParameter("The new " + entityName),
 				},
+				"responses": swaggerResponses("200", "The created "+entityName, schemaReference, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity),
 			},
 		}
 
@@ -77,30 +69,24 @@ func CreateSwaggerFile(entities Entities, info Info, host string) (string, error
 				pathParameter,
 			},
 			"get": map[string]interface{}{
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "A single " + entityName,
-						"schema":      schemaReference,
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "version",
+						"in":          "query",
+						"description": versionDescription,
+						"type":        "integer",
 					},
 				},
+				"responses": swaggerResponses("200", "A single "+entityName, schemaReference, http.StatusBadRequest, http.StatusNotFound),
 			},
 			"put": map[string]interface{}{
 				"parameters": []interface{}{
-					bodyParameter,
-				},
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "The updated " + entityName,
-						"schema":      schemaReference,
-					},
+					bodyParameter("The updated " + entityName),
 				},
+				"responses": swaggerResponses("200", "The updated "+entityName, schemaReference, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
 			},
 			"delete": map[string]interface{}{
-				"responses": map[string]interface{}{
-					"204": map[string]interface{}{
-						"description": "No content",
-					},
-				},
+				"responses": swaggerResponses("204", "No content", nil, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
 			},
 		}
 
@@ -111,14 +97,9 @@ func CreateSwaggerFile(entities Entities, info Info, host string) (string, error
 				"parameters": []interface{}{
 					pathParameter,
 				},
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "The expanded " + entityName,
-						"schema": map[string]interface{}{
-							"$ref": "#/definitions/" + expandedEntityName,
-						},
-					},
-				},
+				"responses": swaggerResponses("200", "The expanded "+entityName, map[string]interface{}{
+					"$ref": "#/definitions/" + expandedEntityName,
+				}, http.StatusNotFound),
 			},
 		}
 
@@ -129,39 +110,135 @@ func CreateSwaggerFile(entities Entities, info Info, host string) (string, error
 				"parameters": []interface{}{
 					pathParameter,
 				},
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-						"description": "The references to " + entityName,
-						"schema": map[string]interface{}{
-							"$ref": "#/definitions/" + referencedByEntityName,
+				"responses": swaggerResponses("200", "The references to "+entityName, map[string]interface{}{
+					"$ref": "#/definitions/" + referencedByEntityName,
+				}, http.StatusNotFound),
+			},
+		}
+
+		paths[fmt.Sprintf("/%s/%s/{%s}", entityName, ActionAudit, pathParameterName)] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": auditDescription,
+				"parameters": []interface{}{
+					pathParameter,
+				},
+				"responses": swaggerResponses("200", "The audit trail of the "+entityName, map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"$ref": "#/definitions/" + entityName + "AuditRecord"},
+				}, http.StatusNotFound),
+			},
+		}
+
+		if entity.Versioned {
+			paths[fmt.Sprintf("/%s/{%s}/%s", entityName, pathParameterName, ActionHistory)] = map[string]interface{}{
+				"parameters": []interface{}{
+					pathParameter,
+				},
+				"get": map[string]interface{}{
+					"responses": swaggerResponses("200", "The versions of the "+entityName, map[string]interface{}{
+						"type":  "array",
+						"items": map[string]interface{}{"$ref": "#/definitions/" + entityName + "Revision"},
+					}, http.StatusNotFound),
+				},
+				"post": map[string]interface{}{
+					"description": restoreVersionDescription,
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "version",
+							"in":       "query",
+							"required": true,
+							"type":     "integer",
 						},
 					},
+					"responses": swaggerResponses("200", "The restored "+entityName, schemaReference, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
 				},
-			},
+			}
 		}
 
-		definition, err := CreateSwaggerDefinition(entity.New().Collapse())
-		if err != nil {
-			return "", err
+		if entity.SoftDelete {
+			trashedReference := map[string]interface{}{"$ref": "#/definitions/" + entityName + "Trashed"}
+
+			paths[fmt.Sprintf("/%s/%s", entityName, ActionTrash)] = map[string]interface{}{
+				"get": map[string]interface{}{
+					"responses": swaggerResponses("200", "The deleted "+entityName, map[string]interface{}{
+						"type":  "array",
+						"items": trashedReference,
+					}),
+				},
+			}
+
+			paths[fmt.Sprintf("/%s/%s/{%s}", entityName, ActionTrash, pathParameterName)] = map[string]interface{}{
+				"parameters": []interface{}{
+					pathParameter,
+				},
+				"get": map[string]interface{}{
+					"responses": swaggerResponses("200", "A deleted "+entityName, trashedReference, http.StatusNotFound),
+				},
+				"post": map[string]interface{}{
+					"description": restoreTrashedDescription,
+					"responses":   swaggerResponses("200", "The restored "+entityName, schemaReference, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity),
+				},
+				"delete": map[string]interface{}{
+					"description": purgeTrashedDescription,
+					"responses":   swaggerResponses("204", "No content", nil, http.StatusNotFound),
+				},
+			}
 		}
-		definitions[entityName] = definition
 
-		expandedDefinition, err := CreateSwaggerDefinitionForResource(entity)
-		if err != nil {
-			return "", err
+		paths[fmt.Sprintf("/%s/%s", entityName, ActionBulk)] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"description": bulkDescription,
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "atomic",
+						"in":          "query",
+						"description": bulkAtomicDescription,
+						"type":        "boolean",
+					},
+					map[string]interface{}{
+						"name":     "body",
+						"in":       "body",
+						"required": true,
+						"schema": map[string]interface{}{
+							"type":  "array",
+							"items": swaggerBulkOperationSchema(schemaReference),
+						},
+					},
+				},
+				"responses": swaggerResponses("200", "The results of the operations", map[string]interface{}{
+					"type":  "array",
+					"items": bulkResultSchema(schemaReference),
+				}, http.StatusBadRequest, http.StatusUnprocessableEntity),
+			},
 		}
-		definitions[expandedEntityName] = expandedDefinition
 
-		referencedByMap, err := entities.CreateReferencedByMap(entityName)
+		err = addEntityDefinitions(definitions, entities, entity)
 		if err != nil {
 			return "", err
 		}
+	}
 
-		referencedByDefinition, err := CreateSwaggerDefinition(referencedByMap)
-		if err != nil {
-			return "", err
+	definitions["Error"] = map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"error"},
+		"properties": map[string]interface{}{
+			"error":   map[string]interface{}{"type": "string"},
+			"type":    map[string]interface{}{"type": "string", "description": "The type of the error if it is one of the storage"},
+			"details": map[string]interface{}{"description": "The fields of the error type"},
+		},
+	}
+
+	responses := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"description": "Unexpected error",
+			"schema":      errorReference,
+		},
+	}
+	for status, description := range errorResponses {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": description,
+			"schema":      errorReference,
 		}
-		definitions[referencedByEntityName] = referencedByDefinition
 	}
 
 	swagger := map[string]interface{}{
@@ -170,12 +247,16 @@ func CreateSwaggerFile(entities Entities, info Info, host string) (string, error
 			"version": info.Version,
 			"title":   info.Title,
 		},
-		"host":        host,
 		"paths":       paths,
 		"definitions": definitions,
+		"responses":   responses,
 	}
 
-	content, err := json.Marshal(swagger)
+	if host != "" {
+		swagger["host"] = host
+	}
+
+	content, err := json.MarshalIndent(swagger, "", "  ")
 	if err != nil {
 		return "", err
 	}
@@ -183,44 +264,486 @@ func CreateSwaggerFile(entities Entities, info Info, host string) (string, error
 	return string(content), nil
 }
 
+// addEntityDefinitions adds the definitions of the collapsed, expanded and referenced by representations of the entity,
+// named by the entity name and the suffixes "Expanded" and "ReferencedBy", and of its audit records, revisions and
+// trashed resources, named by the suffixes "AuditRecord", "Revision" and "Trashed" if the entity has them.
+func addEntityDefinitions(definitions map[string]interface{}, entities Entities, entity Entity) error {
+	definition, err := CreateSwaggerDefinition(entity.New().Collapse())
+	if err != nil {
+		return err
+	}
+	err = liftDefinitions(definitions, definition)
+	if err != nil {
+		return err
+	}
+	properties := definition.(map[string]interface{})["properties"].(map[string]interface{})
+	markFieldPermissions(properties["data"], entity.Fields)
+	properties["meta"].(map[string]interface{})["readOnly"] = true
+	definitions[entity.Name] = definition
+
+	expandedDefinition, err := CreateSwaggerDefinitionForResource(entity)
+	if err != nil {
+		return err
+	}
+	err = liftDefinitions(definitions, expandedDefinition)
+	if err != nil {
+		return err
+	}
+	definitions[entity.Name+"Expanded"] = expandedDefinition
+
+	referencedByMap, err := entities.CreateReferencedByMap(entity.Name)
+	if err != nil {
+		return err
+	}
+
+	referencedByDefinition, err := CreateSwaggerDefinition(referencedByMap)
+	if err != nil {
+		return err
+	}
+	definitions[entity.Name+"ReferencedBy"] = referencedByDefinition
+
+	err = addWrapperDefinition(definitions, entity.Name+"AuditRecord", AuditRecord{}, entity.Name, "before", "after")
+	if err != nil {
+		return err
+	}
+
+	if entity.Versioned {
+		err = addWrapperDefinition(definitions, entity.Name+"Revision", Revision{}, entity.Name, "resource")
+		if err != nil {
+			return err
+		}
+	}
+
+	if entity.SoftDelete {
+		err = addWrapperDefinition(definitions, entity.Name+"Trashed", TrashedResource{}, entity.Name, "resource")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addWrapperDefinition adds the definition of a type holding resources of the entity in the given properties,
+// which refer to the definition of the entity.
+func addWrapperDefinition(definitions map[string]interface{}, name string, wrapper interface{}, entityName string, resourceProperties ...string) error {
+	definition, err := CreateSwaggerDefinition(wrapper)
+	if err != nil {
+		return err
+	}
+
+	properties := definition.(map[string]interface{})["properties"].(map[string]interface{})
+	for _, propertyName := range resourceProperties {
+		property := map[string]interface{}{"$ref": "#/definitions/" + entityName}
+		if properties[propertyName].(map[string]interface{})["x-omitempty"] == true {
+			property["x-omitempty"] = true
+		}
+		properties[propertyName] = property
+	}
+	definitions[name] = definition
+
+	return nil
+}
+
+// addServiceDefinitions adds the definitions of the documents the service describes besides resources,
+// named by their types. Secrets of webhooks are write only and their principals are read only.
+func addServiceDefinitions(definitions map[string]interface{}) error {
+	for name, value := range map[string]interface{}{
+		"EntityDescription": EntityDescription{},
+		"Webhook":           Webhook{},
+		"WebhookDelivery":   WebhookDelivery{},
+		"WebhookDeadLetter": WebhookDeadLetter{},
+	} {
+		definition, err := CreateSwaggerDefinition(value)
+		if err != nil {
+			return err
+		}
+		err = liftDefinitions(definitions, definition)
+		if err != nil {
+			return err
+		}
+		definitions[name] = definition
+	}
+
+	properties := definitions["Webhook"].(map[string]interface{})["properties"].(map[string]interface{})
+	properties["secret"].(map[string]interface{})["x-writeOnly"] = true
+	properties["principal"].(map[string]interface{})["readOnly"] = true
+
+	return nil
+}
+
+// swaggerMetaPaths describes the paths below Meta.
+func swaggerMetaPaths() map[string]interface{} {
+	webhookReference := map[string]interface{}{"$ref": "#/definitions/Webhook"}
+	webhookBody := map[string]interface{}{
+		"name":     "body",
+		"in":       "body",
+		"required": true,
+		"schema":   webhookReference,
+	}
+	webhookIDParameter := map[string]interface{}{
+		"name":        "webhookId",
+		"in":          "path",
+		"description": "ID of the webhook",
+		"required":    true,
+		"type":        "string",
+	}
+
+	return map[string]interface{}{
+		fmt.Sprintf("/%s/%s", Meta, MetaActionSwaggerFile): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "This swagger file",
+					},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionOpenAPIFile): map[string]interface{}{
+			"get": map[string]interface{}{
+				"produces": []interface{}{"application/json", "application/yaml"},
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "format",
+						"in":          "query",
+						"description": openAPIFormatDescription,
+						"type":        "string",
+						"enum":        []interface{}{"json", "yaml"},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "The OpenAPI document"},
+					"406": map[string]interface{}{"description": "Neither JSON nor YAML is accepted"},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionTypeScript): map[string]interface{}{
+			"get": map[string]interface{}{
+				"produces": []interface{}{"application/typescript"},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "The TypeScript client"},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionDocs): map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": docsDescription,
+				"produces":    []interface{}{"text/html"},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "The API explorer"},
+				},
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionEntities): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": swaggerResponses("200", "The descriptions of all entities", map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"$ref": "#/definitions/EntityDescription"},
+				}),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{entityName}", Meta, MetaActionEntities): map[string]interface{}{
+			"get": map[string]interface{}{
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":     "entityName",
+						"in":       "path",
+						"required": true,
+						"type":     "string",
+					},
+				},
+				"responses": swaggerResponses("200", "The description of the entity", map[string]interface{}{
+					"$ref": "#/definitions/EntityDescription",
+				}, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionEvents): map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": eventsDescription,
+				"produces":    []interface{}{"text/event-stream"},
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "since",
+						"in":          "query",
+						"description": eventsSinceDescription,
+						"type":        "integer",
+					},
+					map[string]interface{}{
+						"name":             "entity",
+						"in":               "query",
+						"description":      eventsEntityDescription,
+						"type":             "array",
+						"items":            map[string]interface{}{"type": "string"},
+						"collectionFormat": "multi",
+					},
+				},
+				"responses": swaggerResponses("200", "The events", nil, http.StatusBadRequest, http.StatusNotFound, http.StatusGone),
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionWebhooks): map[string]interface{}{
+			"get": map[string]interface{}{
+				"description": webhooksDescription,
+				"responses": swaggerResponses("200", "All webhooks", map[string]interface{}{
+					"type":  "array",
+					"items": webhookReference,
+				}, http.StatusNotFound),
+			},
+			"post": map[string]interface{}{
+				"description": webhooksDescription,
+				"parameters":  []interface{}{webhookBody},
+				"responses":   swaggerResponses("200", "The created webhook", webhookReference, http.StatusBadRequest, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{webhookId}", Meta, MetaActionWebhooks): map[string]interface{}{
+			"parameters": []interface{}{webhookIDParameter},
+			"get": map[string]interface{}{
+				"responses": swaggerResponses("200", "A single webhook", webhookReference, http.StatusNotFound),
+			},
+			"put": map[string]interface{}{
+				"description": webhookUpdateDescription,
+				"parameters":  []interface{}{webhookBody},
+				"responses":   swaggerResponses("200", "The updated webhook", webhookReference, http.StatusBadRequest, http.StatusNotFound),
+			},
+			"delete": map[string]interface{}{
+				"responses": swaggerResponses("204", "No content", nil, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{webhookId}", Meta, MetaActionWebhookDeliveries): map[string]interface{}{
+			"get": map[string]interface{}{
+				"parameters": []interface{}{webhookIDParameter},
+				"responses": swaggerResponses("200", "The delivery attempts of the webhook in chronological order", map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"$ref": "#/definitions/WebhookDelivery"},
+				}, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s", Meta, MetaActionWebhookDeadLetters): map[string]interface{}{
+			"get": map[string]interface{}{
+				"responses": swaggerResponses("200", "The events no attempt delivered", map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"$ref": "#/definitions/WebhookDeadLetter"},
+				}, http.StatusNotFound),
+			},
+		},
+		fmt.Sprintf("/%s/%s/{deadLetterId}", Meta, MetaActionWebhookDeadLetters): map[string]interface{}{
+			"post": map[string]interface{}{
+				"description": redeliverDescription,
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "deadLetterId",
+						"in":          "path",
+						"description": "ID of the dead letter",
+						"required":    true,
+						"type":        "string",
+					},
+				},
+				"responses": swaggerResponses("202", "Accepted", nil, http.StatusNotFound),
+			},
+		},
+	}
+}
+
+// CreateSwaggerDefinitionForResource describes the expanded resources of the entity.
+// The referenced resources refer to the expanded definitions of their entities, e.g. "#/definitions/authorExpanded",
+// as entities may reference themselves.
 func CreateSwaggerDefinitionForResource(in Entity) (interface{}, error) {
 	data, err := CreateSwaggerDefinition(reflect.New(in.Data).Interface())
 	if err != nil {
 		return nil, err
 	}
+	definitions := map[string]interface{}{}
+	err = liftDefinitions(definitions, data)
+	if err != nil {
+		return nil, err
+	}
+	markFieldPermissions(data, in.Fields)
 
 	references := map[string]interface{}{}
 	for relationName, reference := range in.References {
-		referenceSwagger, err := CreateSwaggerDefinitionForResource(reference)
-		if err != nil {
-			return nil, err
+		references[relationName] = map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"$ref": "#/definitions/" + reference.Name + "Expanded"},
 		}
+	}
 
-		references[toLowerFirstLetters(relationName)] = referenceSwagger
+	meta, err := CreateSwaggerDefinition(Metadata{})
+	if err != nil {
+		return nil, err
 	}
+	meta.(map[string]interface{})["readOnly"] = true
 
 	properties := map[string]interface{}{
 		"id":         map[string]interface{}{"type": "string"},
 		"data":       data,
 		"references": map[string]interface{}{"type": "object", "properties": references},
+		"meta":       meta,
 	}
 
-	return map[string]interface{}{"type": "object", "properties": properties}, nil
+	definition := map[string]interface{}{"type": "object", "properties": properties}
+	if len(definitions) != 0 {
+		definition["definitions"] = definitions
+	}
+
+	return definition, nil
+}
+
+// liftDefinitions moves the definitions of the recursive types described by the schema into the given definitions.
+// It fails if a definition of the same name differs, which happens for types of the same name declared within functions.
+func liftDefinitions(definitions map[string]interface{}, schema interface{}) error {
+	object, _ := schema.(map[string]interface{})
+	nested, ok := object["definitions"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	delete(object, "definitions")
+	for name, definition := range nested {
+		if existing, ok := definitions[name]; ok && !reflect.DeepEqual(existing, definition) {
+			return fmt.Errorf("cannot create swagger definitions for different types named %s", name)
+		}
+
+		definitions[name] = definition
+	}
+
+	return nil
 }
 
+// SwaggerSchemaMarshaler is implemented by types describing their own schema,
+// e.g. because they marshal themselves to JSON.
+type SwaggerSchemaMarshaler interface {
+	MarshalSwaggerSchema() (map[string]interface{}, error)
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	schemaMarshalerType = reflect.TypeOf((*SwaggerSchemaMarshaler)(nil)).Elem()
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+var errorReference = map[string]interface{}{"$ref": "#/definitions/Error"}
+
+// CreateSwaggerDefinition describes in as it is serialized by encoding/json.
+// Struct fields are named by their json tags, fields which may be omitted are marked by x-omitempty
+// and embedded structs are promoted.
+// Interfaces and maps holding values are described by their content, otherwise by their types.
+// Other types marshaling themselves to JSON are described as any value unless they implement SwaggerSchemaMarshaler.
+// Types containing themselves are referred to by "$ref" within themselves and defined in the "definitions" of the result.
 func CreateSwaggerDefinition(in interface{}) (interface{}, error) {
 	if in == nil {
 		return nil, errors.New("cannot create swagger definition for nil")
 	}
 
-	t := reflect.TypeOf(in)
-	for t.Kind() == reflect.Ptr {
-		t = t.Elem()
+	builder := schemaBuilder{
+		path:        map[reflect.Type]bool{},
+		recursive:   map[reflect.Type]bool{},
+		names:       map[string]reflect.Type{},
+		definitions: map[string]interface{}{},
+	}
+	schema, err := builder.createSchema(reflect.ValueOf(in))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(builder.definitions) != 0 {
+		schema["definitions"] = builder.definitions
+	}
+
+	return schema, nil
+}
+
+// schemaBuilder describes values. Named types containing themselves are described at their outermost occurrence
+// and referred to by "$ref" within themselves, as they were described infinitely otherwise.
+type schemaBuilder struct {
+	// path holds the named types being described.
+	path map[reflect.Type]bool
+	// recursive holds the named types which were referred to within themselves.
+	recursive map[reflect.Type]bool
+	// names holds the types of the definition names, which are unique unless types are declared within functions.
+	names       map[string]reflect.Type
+	definitions map[string]interface{}
+}
+
+var definitionNameRegex = regexp.MustCompile("[^A-Za-z0-9._-]+")
+
+// definitionName names the definition of a type by its package path and name, as types of different packages
+// may have the same name. Characters which names of OpenAPI components can't contain are replaced by "_".
+func definitionName(t reflect.Type) string {
+	name := t.Name()
+	if t.PkgPath() != "" {
+		name = t.PkgPath() + "." + name
+	}
+
+	return definitionNameRegex.ReplaceAllString(name, "_")
+}
+
+func (b schemaBuilder) createSchema(v reflect.Value) (map[string]interface{}, error) {
+	t := v.Type()
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b.createSchema(reflect.Zero(t.Elem()))
+		}
+		return b.createSchema(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return map[string]interface{}{}, nil
+		}
+		return b.createSchema(v.Elem())
 	}
 
-	v := reflect.ValueOf(in)
-	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
-		v = v.Elem()
+	if t.Name() == "" {
+		return b.createTypeSchema(v)
+	}
+
+	if b.path[t] {
+		name := definitionName(t)
+		if other, ok := b.names[name]; ok && other != t {
+			return nil, fmt.Errorf("cannot create swagger definition for %s and another type named %s", t, name)
+		}
+
+		b.names[name] = t
+		b.recursive[t] = true
+		return map[string]interface{}{"$ref": "#/definitions/" + name}, nil
+	}
+
+	b.path[t] = true
+	schema, err := b.createTypeSchema(v)
+	delete(b.path, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.recursive[t] {
+		b.definitions[definitionName(t)] = copySchemaDeep(schema)
+	}
+
+	return schema, nil
+}
+
+func (b schemaBuilder) createTypeSchema(v reflect.Value) (map[string]interface{}, error) {
+	t := v.Type()
+
+	if reflect.PtrTo(t).Implements(schemaMarshalerType) {
+		schema, err := reflect.New(t).Interface().(SwaggerSchemaMarshaler).MarshalSwaggerSchema()
+		if err != nil {
+			return nil, err
+		}
+
+		return copySchema(schema), nil
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	}
+
+	if reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return map[string]interface{}{}, nil
+	}
+
+	if reflect.PtrTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}, nil
 	}
 
 	switch t.Kind() {
@@ -233,67 +756,295 @@ func CreateSwaggerDefinition(in interface{}) (interface{}, error) {
 		return map[string]interface{}{"type": "boolean"}, nil
 	case reflect.String:
 		return map[string]interface{}{"type": "string"}, nil
-	case reflect.Slice:
-		items, err := CreateSwaggerDefinition(reflect.New(t.Elem()).Interface())
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(t.Elem()).Implements(jsonMarshalerType) {
+			return map[string]interface{}{"type": "string", "format": "byte"}, nil
+		}
+
+		items, err := b.createSchema(reflect.Zero(t.Elem()))
 		if err != nil {
 			return nil, err
 		}
-		return map[string]interface{}{"type": "array", "items": items}, nil
+
+		schema := map[string]interface{}{"type": "array", "items": items}
+		if t.Kind() == reflect.Array {
+			schema["minItems"] = t.Len()
+			schema["maxItems"] = t.Len()
+		}
+
+		return schema, nil
 	case reflect.Struct:
 		properties := map[string]interface{}{}
-		for i := 0; i < t.NumField(); i++ {
-			fieldName := t.Field(i).Name
-			if startsWithCapitalLetter(fieldName) {
-				field, err := CreateSwaggerDefinition(v.FieldByName(fieldName).Interface())
-				if err != nil {
-					return nil, fmt.Errorf("struct field %q: %q", fieldName, err.Error())
-				}
-
-				properties[toLowerFirstLetters(fieldName)] = field
-			}
+		err := b.addStructFields(properties, v, map[string]bool{})
+		if err != nil {
+			return nil, err
 		}
 
 		return map[string]interface{}{"type": "object", "properties": properties}, nil
 	case reflect.Map:
-		properties := map[string]interface{}{}
+		return b.createMapSchema(v)
+	}
+
+	return nil, fmt.Errorf("unsupported data type %q", t.Kind())
+}
+
+// addStructFields adds the fields of the struct to properties unless they are shadowed by the given fields.
+// Fields of embedded structs are shadowed by those of the embedding struct.
+func (b schemaBuilder) addStructFields(properties map[string]interface{}, v reflect.Value, shadowed map[string]bool) error {
+	t := v.Type()
+
+	embedded := []reflect.Value{}
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
 
-		if t.Key().Kind() != reflect.String {
-			return nil, fmt.Errorf("unsupported map key type %q", t.Kind())
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
 		}
 
-		for _, k := range v.MapKeys() {
-			field, err := CreateSwaggerDefinition(v.MapIndex(k).Interface())
-			if err != nil {
-				return nil, fmt.Errorf("map key %q: %q", k.String(), err.Error())
+		options := strings.Split(tag, ",")
+		name := options[0]
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			fieldValue := v.Field(i)
+			if fieldValue.Kind() == reflect.Ptr {
+				if fieldValue.IsNil() {
+					fieldValue = reflect.Zero(fieldType)
+				} else {
+					fieldValue = fieldValue.Elem()
+				}
 			}
 
-			properties[toLowerFirstLetters(k.String())] = field
+			embedded = append(embedded, fieldValue)
+			continue
 		}
 
-		return map[string]interface{}{"type": "object", "properties": properties}, nil
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		names[name] = true
+		if shadowed[name] {
+			continue
+		}
+
+		property, err := b.createSchema(v.Field(i))
+		if err != nil {
+			return fmt.Errorf("struct field %q: %s", field.Name, err.Error())
+		}
+
+		omitEmpty := false
+		for _, option := range options[1:] {
+			switch option {
+			case "omitempty":
+				omitEmpty = true
+			case "string":
+				switch fieldType.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+					reflect.Float32, reflect.Float64, reflect.Bool:
+					property = map[string]interface{}{"type": "string"}
+				}
+			}
+		}
+
+		// Omitted nil pointers are never null.
+		if field.Type.Kind() == reflect.Ptr && !omitEmpty {
+			property["x-nullable"] = true
+		}
+
+		if omitEmpty {
+			property["x-omitempty"] = true
+		}
+
+		properties[name] = property
 	}
 
-	return nil, fmt.Errorf("unsupported data type %q", t.Kind())
+	for name := range shadowed {
+		names[name] = true
+	}
+
+	for _, fieldValue := range embedded {
+		err := b.addStructFields(properties, fieldValue, names)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func startsWithCapitalLetter(s string) bool {
-	return s != toLowerFirstLetters(s)
+// createMapSchema describes maps holding values by their keys and others by their value type.
+// Keys have to be strings, integers or text marshalers.
+func (b schemaBuilder) createMapSchema(v reflect.Value) (map[string]interface{}, error) {
+	t := v.Type()
+
+	switch t.Key().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		if !t.Key().Implements(textMarshalerType) {
+			return nil, fmt.Errorf("unsupported map key type %q", t.Key().Kind())
+		}
+	}
+
+	if v.Len() == 0 {
+		values, err := b.createSchema(reflect.Zero(t.Elem()))
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	}
+
+	properties := map[string]interface{}{}
+	for _, k := range v.MapKeys() {
+		key, err := mapKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		property, err := b.createSchema(v.MapIndex(k))
+		if err != nil {
+			return nil, fmt.Errorf("map key %q: %s", key, err.Error())
+		}
+
+		properties[key] = property
+	}
+
+	return map[string]interface{}{"type": "object", "properties": properties}, nil
+}
+
+// mapKey returns the JSON object key of a map key like encoding/json does.
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+
+	if k.Type().Implements(textMarshalerType) {
+		if !k.CanInterface() {
+			return "", fmt.Errorf("unexported map key type %q", k.Type())
+		}
+
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	}
+
+	return strconv.FormatUint(k.Uint(), 10), nil
 }
 
-func toLowerFirstLetters(in string) string {
-	if in == "" {
-		return in
+func copySchema(schema map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(schema))
+	for k, v := range schema {
+		result[k] = v
 	}
 
-	out := []byte(in)
-	for i := range out {
-		lowerCased := bytes.ToLower([]byte{out[i]})[0]
-		if out[i] == lowerCased {
-			break
+	return result
+}
+
+// copySchemaDeep copies the schema together with the schemas it contains.
+func copySchemaDeep(schema interface{}) map[string]interface{} {
+	return copySchemaValue(schema).(map[string]interface{})
+}
+
+func copySchemaValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[k] = copySchemaValue(v)
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, v := range value {
+			result[i] = copySchemaValue(v)
 		}
 
-		out[i] = lowerCased
+		return result
 	}
 
-	return string(out)
+	return value
+}
+
+// swaggerBulkOperationSchema describes the operations of a bulk as one object, since Swagger 2.0 has no oneOf.
+func swaggerBulkOperationSchema(resource interface{}) interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"op"},
+		"properties": map[string]interface{}{
+			"op":       map[string]interface{}{"type": "string", "enum": []interface{}{BulkCreate, BulkUpdate, BulkDelete}},
+			"id":       map[string]interface{}{"type": "string", "description": "The ID of updates and deletes"},
+			"resource": resource,
+		},
+	}
+}
+
+// swaggerResponses describes a successful response with an optional schema and the given error responses.
+func swaggerResponses(status, description string, schema interface{}, errorStatuses ...int) map[string]interface{} {
+	success := map[string]interface{}{"description": description}
+	if schema != nil {
+		success["schema"] = schema
+	}
+
+	responses := map[string]interface{}{
+		status:    success,
+		"401":     swaggerResponseReference("401"),
+		"403":     swaggerResponseReference("403"),
+		"default": swaggerResponseReference("Error"),
+	}
+	for _, errorStatus := range errorStatuses {
+		responses[strconv.Itoa(errorStatus)] = swaggerResponseReference(strconv.Itoa(errorStatus))
+	}
+
+	return responses
+}
+
+func swaggerResponseReference(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/responses/" + name}
+}
+
+// markFieldPermissions marks read only fields as such and hidden ones as write only.
+func markFieldPermissions(definition interface{}, fields map[string]FieldPermission) {
+	for path, permission := range fields {
+		if !permission.ReadOnly && !permission.Hidden {
+			continue
+		}
+
+		field := definition
+		for _, segment := range strings.Split(path, ".") {
+			object, _ := field.(map[string]interface{})
+			properties, _ := object["properties"].(map[string]interface{})
+			field = properties[segment]
+		}
+
+		property, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if permission.ReadOnly {
+			property["readOnly"] = true
+		}
+		if permission.Hidden {
+			property["x-writeOnly"] = true
+		}
+	}
 }
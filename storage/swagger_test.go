@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+type swaggerEmbedded struct {
+	Shadowed string `json:"name"`
+	Promoted int    `json:"promoted"`
+}
+
+type SwaggerPointerEmbedded struct {
+	Deep bool
+}
+
+type swaggerVersion struct {
+	Major, Minor int
+}
+
+func (v swaggerVersion) MarshalText() ([]byte, error) {
+	return []byte("1.0"), nil
+}
+
+type swaggerColor string
+
+func (c swaggerColor) MarshalSwaggerSchema() (map[string]interface{}, error) {
+	return map[string]interface{}{"type": "string", "enum": []interface{}{"red", "green"}}, nil
+}
+
+type swaggerFixture struct {
+	swaggerEmbedded
+	*SwaggerPointerEmbedded
+	Name       string `json:"name"`
+	Untagged   string
+	Omitted    string                  `json:"omitted,omitempty"`
+	Ignored    string                  `json:"-"`
+	Quoted     int64                   `json:"quoted,string"`
+	Optional   *string                 `json:"optional"`
+	Omittable  *string                 `json:"omittable,omitempty"`
+	Time       time.Time               `json:"time"`
+	Raw        json.RawMessage         `json:"raw"`
+	Any        interface{}             `json:"any"`
+	Filled     interface{}             `json:"filled"`
+	Bytes      []byte                  `json:"bytes"`
+	Array      [2]float64              `json:"array"`
+	Counts     map[int]string          `json:"counts"`
+	Versions   map[swaggerVersion]bool `json:"versions"`
+	Color      swaggerColor            `json:"color"`
+	Version    swaggerVersion          `json:"version"`
+	unexported string
+}
+
+func TestCreateSwaggerDefinition(t *testing.T) {
+	definition, err := CreateSwaggerDefinition(swaggerFixture{Filled: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "definition.json", definition)
+}
+
+type swaggerNode struct {
+	Name     string        `json:"name"`
+	Parent   *swaggerNode  `json:"parent"`
+	Children []swaggerNode `json:"children"`
+	Labels   swaggerTree   `json:"labels"`
+}
+
+type swaggerTree map[string]swaggerTree
+
+func TestCreateSwaggerDefinitionRecursive(t *testing.T) {
+	definition, err := CreateSwaggerDefinition(swaggerNode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "recursive.json", definition)
+	assertGolden(t, "recursive.openapi.json", toOpenAPISchema(definition, "#/components/schemas/"))
+}
+
+func TestCreateSwaggerDefinitionRecursiveNameCollision(t *testing.T) {
+	type node struct {
+		Children []node `json:"children"`
+	}
+	other := func() interface{} {
+		type node struct {
+			Children []node `json:"children"`
+		}
+
+		return node{}
+	}()
+
+	_, err := CreateSwaggerDefinition(struct {
+		First  node        `json:"first"`
+		Second interface{} `json:"second"`
+	}{Second: other})
+	if err == nil {
+		t.Error("expected recursive types of the same name to be rejected")
+	}
+}
+
+func TestCreateSwaggerFileRecursiveNameCollision(t *testing.T) {
+	type node struct {
+		Children []node `json:"children"`
+	}
+	other := func() reflect.Type {
+		type node struct {
+			Name     string `json:"name"`
+			Children []node `json:"children"`
+		}
+
+		return reflect.TypeOf(node{})
+	}()
+
+	entities, err := NewEntities([]Entity{{Name: "first", Data: reflect.TypeOf(node{})}, {Name: "second", Data: other}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CreateSwaggerFile(entities, FixtureInfo, "")
+	if err == nil {
+		t.Error("expected recursive types of the same name to be rejected")
+	}
+}
+
+func TestCreateSwaggerDefinitionUnsupported(t *testing.T) {
+	for _, in := range []interface{}{
+		map[float64]string{},
+		struct{ Channel chan int }{},
+		struct{ Func func() }{},
+	} {
+		_, err := CreateSwaggerDefinition(in)
+		if err == nil {
+			t.Errorf("expected an error for %T", in)
+		}
+	}
+}
+
+// specEntities returns the fixture entities with a versioned and a soft deleting one,
+// so that the API descriptions cover every path.
+func specEntities(t *testing.T) Entities {
+	fixtures := append([]Entity{}, FixtureEntities...)
+	fixtures[0].Versioned = true
+	fixtures[1].SoftDelete = true
+
+	entities, err := NewEntities(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entities
+}
+
+func TestCreateSwaggerFile(t *testing.T) {
+	entities := specEntities(t)
+
+	content, err := CreateSwaggerFile(entities, FixtureInfo, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var swagger interface{}
+	err = json.Unmarshal([]byte(content), &swagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "swagger.json", swagger)
+}
+
+func TestCreateOpenAPIDocument(t *testing.T) {
+	entities := specEntities(t)
+
+	document, err := CreateOpenAPIDocument(entities, FixtureInfo, "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "openapi.json", document)
+}
+
+func TestGetOpenAPIFile(t *testing.T) {
+	s, err := New(FixtureEntities, dummyRepository{}, dummyUUIDGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := NewService(s, FixtureInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, path, accept string
+		status             int
+		contentType        string
+	}{
+		{"default", "/meta/openapi", "", http.StatusOK, "application/json"},
+		{"json", "/meta/openapi", "application/json", http.StatusOK, "application/json"},
+		{"yaml", "/meta/openapi", "application/yaml", http.StatusOK, "application/yaml"},
+		{"legacy yaml", "/meta/openapi", "text/x-yaml", http.StatusOK, "application/yaml"},
+		{"any", "/meta/openapi", "*/*", http.StatusOK, "application/json"},
+		{"preferred yaml", "/meta/openapi", "application/json;q=0.5, application/yaml", http.StatusOK, "application/yaml"},
+		{"yaml before any", "/meta/openapi", "text/html, application/yaml, */*;q=0.8", http.StatusOK, "application/yaml"},
+		{"unsupported", "/meta/openapi", "text/html", http.StatusNotAcceptable, ""},
+		{"excluded json", "/meta/openapi", "application/json;q=0", http.StatusNotAcceptable, ""},
+		{"yaml parameter", "/meta/openapi?format=yaml", "application/json", http.StatusOK, "application/yaml"},
+		{"json parameter", "/meta/openapi?format=json", "text/html", http.StatusOK, "application/json"},
+		{"unsupported parameter", "/meta/openapi?format=xml", "", http.StatusNotAcceptable, ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+
+		rw := httptest.NewRecorder()
+		service.ServeHTTP(rw, r)
+
+		if rw.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, rw.Code)
+			continue
+		}
+
+		if rw.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: expected to vary by Accept, got %q", test.name, rw.Header().Get("Vary"))
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		if contentType := rw.Header().Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+			t.Errorf("%s: expected the content type %q, got %q", test.name, test.contentType, contentType)
+		}
+
+		body := rw.Body.String()
+		if test.contentType == "application/json" && !json.Valid(rw.Body.Bytes()) || test.contentType == "application/yaml" && !strings.Contains(body, "\nopenapi: ") {
+			t.Errorf("%s: unexpected document %.40q", test.name, body)
+		}
+	}
+}
+
+// assertGolden compares the indented JSON of actual with the golden file of the given name in testdata.
+func assertGolden(t *testing.T, name string, actual interface{}) {
+	content, err := json.MarshalIndent(actual, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGoldenFile(t, name, append(content, '\n'))
+}
+
+// assertGoldenFile compares content with the golden file of the given name in testdata,
+// which is rewritten instead if the tests run with -update.
+func assertGoldenFile(t *testing.T, name string, content []byte) {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		err := ioutil.WriteFile(path, content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(content, expected) {
+		t.Errorf("%s differs from the golden file %s, run the tests with -update to accept it:\n%s", name, path, content)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	for _, err := range errorTypes {
+		status := statusCode(err)
+		if _, ok := err.(BulkAborted); ok || status == http.StatusInternalServerError {
+			// Aborted operations are only reported within bulk results, unexpected errors by the default response.
+			continue
+		}
+
+		if errorResponses[status] == "" {
+			t.Errorf("the status %d of %T is not described", status, err)
+		}
+	}
+}
+
+// servedRoutes are the routes the service serves. {entity} stands for an entity both versioned and soft deleting
+// and {id} for the ID of its resources. Query are the query parameters of the route.
+var servedRoutes = []struct {
+	method, path string
+	query        []string
+}{
+	{http.MethodGet, "/meta/swagger", nil},
+	{http.MethodGet, "/meta/openapi", []string{"format"}},
+	{http.MethodGet, "/meta/typescript", nil},
+	{http.MethodGet, "/meta/docs", nil},
+	{http.MethodGet, "/meta/entities", nil},
+	{http.MethodGet, "/meta/entities/{entityName}", nil},
+	{http.MethodGet, "/meta/events", []string{"since", "entity"}},
+	{http.MethodGet, "/meta/webhooks", nil},
+	{http.MethodPost, "/meta/webhooks", nil},
+	{http.MethodGet, "/meta/webhooks/{webhookId}", nil},
+	{http.MethodPut, "/meta/webhooks/{webhookId}", nil},
+	{http.MethodDelete, "/meta/webhooks/{webhookId}", nil},
+	{http.MethodGet, "/meta/webhook-deliveries/{webhookId}", nil},
+	{http.MethodGet, "/meta/webhook-dead-letters", nil},
+	{http.MethodPost, "/meta/webhook-dead-letters/{deadLetterId}", nil},
+	{http.MethodGet, "/{entity}", nil},
+	{http.MethodPost, "/{entity}", nil},
+	{http.MethodGet, "/{entity}/{id}", []string{"version"}},
+	{http.MethodPut, "/{entity}/{id}", nil},
+	{http.MethodDelete, "/{entity}/{id}", nil},
+	{http.MethodGet, "/{entity}/expand/{id}", nil},
+	{http.MethodGet, "/{entity}/referenced-by/{id}", nil},
+	{http.MethodGet, "/{entity}/audit/{id}", nil},
+	{http.MethodPost, "/{entity}/_bulk", []string{"atomic"}},
+	{http.MethodGet, "/{entity}/{id}/history", nil},
+	{http.MethodPost, "/{entity}/{id}/history", []string{"version"}},
+	{http.MethodGet, "/{entity}/trash", nil},
+	{http.MethodGet, "/{entity}/trash/{id}", nil},
+	{http.MethodPost, "/{entity}/trash/{id}", nil},
+	{http.MethodDelete, "/{entity}/trash/{id}", nil},
+}
+
+// TestServedRoutesAreKnown fails if the package declares an action no served route has,
+// so that new routes of the service get added to servedRoutes and thereby documented.
+func TestServedRoutesAreKnown(t *testing.T) {
+	packages, err := parser.ParseDir(token.NewFileSet(), ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments := map[string]bool{}
+	for _, route := range servedRoutes {
+		for _, segment := range strings.Split(route.path, "/") {
+			segments[segment] = true
+		}
+	}
+
+	for _, file := range packages["storage"].Files {
+		for _, declaration := range file.Decls {
+			general, ok := declaration.(*ast.GenDecl)
+			if !ok || general.Tok != token.CONST {
+				continue
+			}
+
+			for _, spec := range general.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if !strings.HasPrefix(name.Name, "Action") && !strings.HasPrefix(name.Name, "MetaAction") {
+						continue
+					}
+
+					literal, ok := value.Values[i].(*ast.BasicLit)
+					if !ok {
+						t.Errorf("cannot read the value of %s", name.Name)
+						continue
+					}
+
+					action, err := strconv.Unquote(literal.Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !segments[action] {
+						t.Errorf("no served route has the action %s %q", name.Name, action)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDocumentedRoutes(t *testing.T) {
+	entities, err := NewEntities([]Entity{{Name: "item", Data: reflect.TypeOf(FixtureDataType{}), Versioned: true, SoftDelete: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := CreateSwaggerFile(entities, FixtureInfo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swagger := map[string]interface{}{}
+	err = json.Unmarshal([]byte(content), &swagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	openAPI, err := CreateOpenAPIDocument(entities, FixtureInfo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, document := range map[string]map[string]interface{}{"swagger file": swagger, "OpenAPI document": openAPI} {
+		paths := document["paths"].(map[string]interface{})
+
+		for _, route := range servedRoutes {
+			path := strings.NewReplacer("{entity}", "item", "{id}", "{itemId}").Replace(route.path)
+
+			item, _ := paths[path].(map[string]interface{})
+			operation, ok := item[strings.ToLower(route.method)].(map[string]interface{})
+			if !ok {
+				t.Errorf("the %s does not document %s %s", name, route.method, path)
+				continue
+			}
+
+			parameters := map[string]bool{}
+			for _, declared := range []interface{}{item["parameters"], operation["parameters"]} {
+				list, _ := declared.([]interface{})
+				for _, parameter := range list {
+					parameters[parameter.(map[string]interface{})["name"].(string)] = true
+				}
+			}
+			for _, query := range route.query {
+				if !parameters[query] {
+					t.Errorf("the %s does not document the query parameter %q of %s %s", name, query, route.method, path)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	OperationTrash   Operation = "trash"
+	OperationRestore Operation = "restore"
+)
+
+// TrashSuffix is appended to the name of a soft deleting entity to get the collection of its deleted resources.
+const TrashSuffix = ".trash"
+
+// TrashedResource is a soft deleted resource.
+type TrashedResource struct {
+	ID        string            `json:"id" bson:"_id"`
+	DeletedAt time.Time         `json:"deletedAt" bson:"deletedAt"`
+	DeletedBy string            `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+	Resource  CollapsedResource `json:"resource" bson:"resource"`
+	// ReferencedBy are the references removed from other resources by entity and relation.
+	// Restoring the resource adds them again to the resources still existing.
+	ReferencedBy map[string]map[string][]string `json:"referencedBy" bson:"referencedBy"`
+}
+
+// Trash lists the deleted resources of an entity the principal may see.
+func (s *Storage) Trash(entityName string) ([]TrashedResource, error) {
+	entity, err := s.softDeletingEntity(entityName)
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := []TrashedResource{}
+	err = s.readAll(entity, entity.Name+TrashSuffix, Query{}, &trashed)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := []TrashedResource{}
+	for _, t := range trashed {
+		err = s.authorize(entityName, OperationTrash, t.Resource)
+		if _, ok := err.(Forbidden); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t.Resource, err = s.hideFields(entity, t.Resource)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed = append(allowed, t)
+	}
+
+	return allowed, nil
+}
+
+func (s *Storage) ReadTrashed(entityName, id string) (TrashedResource, error) {
+	entity, err := s.softDeletingEntity(entityName)
+	if err != nil {
+		return TrashedResource{}, err
+	}
+
+	trashed, err := s.readTrashed(entity, id)
+	if err != nil {
+		return TrashedResource{}, err
+	}
+
+	err = s.authorize(entityName, OperationTrash, trashed.Resource)
+	if err != nil {
+		return TrashedResource{}, err
+	}
+
+	trashed.Resource, err = s.hideFields(entity, trashed.Resource)
+	if err != nil {
+		return TrashedResource{}, err
+	}
+
+	return trashed, nil
+}
+
+// RestoreTrashed moves a deleted resource back together with the references to it.
+// It fails if the resource references resources purged in the meantime.
+func (s *Storage) RestoreTrashed(entityName, id string) (CollapsedResource, error) {
+	entity, err := s.softDeletingEntity(entityName)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	trashed, err := s.readTrashed(entity, id)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	err = s.authorize(entityName, OperationRestore, trashed.Resource)
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	// The history is kept in the trash, so that the restored resource is its next version.
+	if entity.Versioned {
+		trashed.Resource.Meta.Version++
+	}
+	resource := trashed.Resource
+
+	err = s.transaction(func(tx *Storage) error {
+		return tx.restoreTrashed(trashed)
+	})
+	if err != nil {
+		return CollapsedResource{}, err
+	}
+
+	return s.hideFields(entity, resource)
+}
+
+// restoreTrashed implements RestoreTrashed.
+func (s *Storage) restoreTrashed(trashed TrashedResource) error {
+	resource := trashed.Resource
+	entityName := resource.entity.Name
+	id := trashed.ID
+
+	err := s.ValidateReferences(resource)
+	if err != nil {
+		return err
+	}
+
+	// Client supplied IDs may have been reused in the meantime.
+	existing, err := s.existingIDs(entityName, []string{id})
+	if err != nil {
+		return err
+	}
+	if existing[id] {
+		return Conflict{Entity: entityName, ID: id}
+	}
+
+	err = s.repository.Create(entityName, resource)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordRevision(resource)
+	if err != nil {
+		return err
+	}
+
+	err = s.mutated(OperationRestore, entityName, id, nil, &resource, nil)
+	if err != nil {
+		return err
+	}
+
+	cause := &AuditCause{Entity: entityName, ResourceID: id, Operation: OperationRestore}
+	for referencingEntityName, references := range trashed.ReferencedBy {
+		for relationName, referenceIDs := range references {
+			for _, referenceID := range referenceIDs {
+				err = s.changeReferences(referencingEntityName, referenceID, relationName, cause, func(ids []string) []string {
+					return append(ids, id)
+				})
+				if _, ok := err.(NotFound); ok {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return s.repository.Delete(entityName+TrashSuffix, id)
+}
+
+// PurgeTrashed deletes a deleted resource and its history for good.
+func (s *Storage) PurgeTrashed(entityName, id string) error {
+	entity, err := s.softDeletingEntity(entityName)
+	if err != nil {
+		return err
+	}
+
+	trashed, err := s.readTrashed(entity, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.authorize(entityName, OperationPurge, trashed.Resource)
+	if err != nil {
+		return err
+	}
+
+	return s.purgeTrashed(trashed)
+}
+
+// PurgeExpiredTrash purges all deleted resources which outlived the retention of their entity
+// and returns how many there were.
+func (s *Storage) PurgeExpiredTrash(now time.Time) (int, error) {
+	purged := 0
+	for _, entity := range s.entities.All() {
+		if !entity.SoftDelete || entity.Retention == 0 {
+			continue
+		}
+
+		trashed := []TrashedResource{}
+		err := s.readAll(entity, entity.Name+TrashSuffix, Query{}, &trashed)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, t := range trashed {
+			if now.Sub(t.DeletedAt) < entity.Retention {
+				continue
+			}
+
+			err = s.purgeTrashed(t)
+			if err != nil {
+				return purged, err
+			}
+
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// StartTrashRetention purges expired deleted resources periodically until stop is called.
+// Errors are passed to onError, if it is set.
+func (s Storage) StartTrashRetention(interval time.Duration, onError func(error)) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				_, err := s.PurgeExpiredTrash(now)
+				if err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func (s *Storage) purgeTrashed(trashed TrashedResource) error {
+	entityName := trashed.Resource.entity.Name
+
+	return s.transaction(func(tx *Storage) error {
+		err := tx.repository.Delete(entityName+TrashSuffix, trashed.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.deleteHistory(entityName, trashed.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.mutated(OperationPurge, entityName, trashed.ID, &trashed.Resource, nil, nil)
+	})
+}
+
+// trash moves the resource into the trash and removes all references to it.
+func (s *Storage) trash(entity Entity, id string, operation Operation) error {
+	return s.transaction(func(tx *Storage) error {
+		return tx.moveToTrash(entity, id, operation)
+	})
+}
+
+// moveToTrash implements trash.
+func (s *Storage) moveToTrash(entity Entity, id string, operation Operation) error {
+	entityName := entity.Name
+	resource, err := s.read(entityName, id)
+	if err != nil {
+		return err
+	}
+
+	// Client supplied IDs may have been reused while the former resource is still in the trash.
+	_, err = s.readTrashed(entity, id)
+	if err == nil {
+		return Conflict{Entity: entityName, ID: id}
+	}
+	if _, ok := err.(NotFound); !ok {
+		return err
+	}
+
+	if entity.Hooks.BeforeDelete != nil {
+		err = entity.Hooks.BeforeDelete(s, resource)
+		if err != nil {
+			return err
+		}
+	}
+
+	referencedBy, err := s.removeReferences(entityName, id, operation)
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.Create(entityName+TrashSuffix, TrashedResource{
+		ID:           id,
+		DeletedAt:    time.Now().UTC(),
+		DeletedBy:    s.principal.ID,
+		Resource:     resource,
+		ReferencedBy: referencedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.Delete(entityName, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.mutated(operation, entityName, id, &resource, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.afterDelete(entity, &resource)
+}
+
+func (s *Storage) readTrashed(entity Entity, id string) (TrashedResource, error) {
+	trashed := TrashedResource{Resource: entity.New().Collapse()}
+	err := s.repository.Read(entity.Name+TrashSuffix, id, &trashed)
+	if _, ok := err.(NotFound); ok {
+		return TrashedResource{}, NotFound{Entity: entity.Name, ID: id}
+	}
+	if err != nil {
+		return TrashedResource{}, err
+	}
+
+	trashed.Resource.entity = entity
+
+	return trashed, nil
+}
+
+func (s *Storage) softDeletingEntity(entityName string) (Entity, error) {
+	entity, ok := s.entities.entitiesByName[entityName]
+	if !ok {
+		return Entity{}, UndefinedEntity{entityName}
+	}
+
+	if !entity.SoftDelete {
+		return Entity{}, InvalidInput{fmt.Sprintf("entity %q does not soft delete", entityName)}
+	}
+
+	return entity, nil
+}
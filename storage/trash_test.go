@@ -0,0 +1,345 @@
+package storage_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+// trashEntities returns soft deleting authors and articles referencing them. Authors are kept for a day.
+func trashEntities(hooks storage.Hooks) []storage.Entity {
+	author := storage.Entity{Name: "author", Data: reflect.TypeOf(testData{}), SoftDelete: true, Retention: 24 * time.Hour, Hooks: hooks}
+	article := storage.Entity{
+		Name:       "article",
+		Data:       reflect.TypeOf(testData{}),
+		References: map[string]storage.Entity{"authors": author},
+		SoftDelete: true,
+	}
+
+	return []storage.Entity{author, article}
+}
+
+func createArticle(t *testing.T, s storage.Storage, name, authorID string) string {
+	resource, err := s.CreateFromJSON("article", `{"data":{"name":"`+name+`"},"references":{"authors":["`+authorID+`"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resource.ID
+}
+
+func expectAuthors(t *testing.T, s storage.Storage, articleID string, expected ...string) {
+	article, err := s.Read("article", articleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authors := article.References["authors"]
+	if len(authors) != len(expected) || len(expected) != 0 && !reflect.DeepEqual(authors, expected) {
+		t.Errorf("expected the authors %v, got %v", expected, authors)
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	s, release := newStorage(t, trashEntities(storage.Hooks{}))
+	defer release()
+	s = s.As(storage.Principal{ID: "alice"})
+
+	authorID := create(t, s, "author", "Ada")
+	articleID := createArticle(t, s, "Notes", authorID)
+
+	err := s.Delete("author", authorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Read("author", authorID)
+	if err != (storage.NotFound{Entity: "author", ID: authorID}) {
+		t.Errorf("expected the author to be gone, got %#v", err)
+	}
+	expectAuthors(t, s, articleID)
+
+	trash, err := s.Trash("author")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != authorID || trash[0].DeletedBy != "alice" || trash[0].Resource.Data.(*testData).Name != "Ada" {
+		t.Fatalf("unexpected trash %+v", trash)
+	}
+	if !reflect.DeepEqual(trash[0].ReferencedBy, map[string]map[string][]string{"article": {"authors": {articleID}}}) {
+		t.Errorf("unexpected removed references %v", trash[0].ReferencedBy)
+	}
+
+	restored, err := s.RestoreTrashed("author", authorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != authorID {
+		t.Errorf("expected %q to be restored, got %+v", authorID, restored)
+	}
+
+	if names := readNames(t, s, "author"); !reflect.DeepEqual(names, map[string]string{authorID: "Ada"}) {
+		t.Errorf("unexpected authors %v", names)
+	}
+	expectAuthors(t, s, articleID, authorID)
+
+	_, err = s.ReadTrashed("author", authorID)
+	if err != (storage.NotFound{Entity: "author", ID: authorID}) {
+		t.Errorf("expected the trash to be empty, got %#v", err)
+	}
+}
+
+func TestRestoreWithPurgedReference(t *testing.T) {
+	s, release := newStorage(t, trashEntities(storage.Hooks{}))
+	defer release()
+
+	authorID := create(t, s, "author", "Ada")
+	articleID := createArticle(t, s, "Notes", authorID)
+
+	for _, step := range []func() error{
+		func() error { return s.Delete("article", articleID) },
+		func() error { return s.Delete("author", authorID) },
+		func() error { return s.PurgeTrashed("author", authorID) },
+	} {
+		err := step()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := s.RestoreTrashed("article", articleID)
+	if _, ok := err.(storage.DanglingReferences); !ok {
+		t.Fatalf("expected dangling references, got %#v", err)
+	}
+
+	_, err = s.Read("article", articleID)
+	if err != (storage.NotFound{Entity: "article", ID: articleID}) {
+		t.Errorf("expected the article not to be restored, got %#v", err)
+	}
+
+	_, err = s.ReadTrashed("article", articleID)
+	if err != nil {
+		t.Errorf("expected the article to stay in the trash, got %#v", err)
+	}
+
+	_, err = s.ReadTrashed("author", authorID)
+	if err != (storage.NotFound{Entity: "author", ID: authorID}) {
+		t.Errorf("expected the author to be purged, got %#v", err)
+	}
+}
+
+func TestRestoreExceedingCardinality(t *testing.T) {
+	entities := trashEntities(storage.Hooks{})
+	entities[1].Cardinalities = map[string]storage.Cardinality{"authors": {Max: 1}}
+	s, release := newStorage(t, entities)
+	defer release()
+
+	ada := create(t, s, "author", "Ada")
+	grace := create(t, s, "author", "Grace")
+	articleID := createArticle(t, s, "Notes", ada)
+
+	err := s.Delete("author", ada)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UpdateFromJSON("article", `{"id":"`+articleID+`","data":{"name":"Notes"},"references":{"authors":["`+grace+`"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RestoreTrashed("author", ada)
+	expected := storage.CardinalityViolation{Entity: "article", Relation: "authors", Count: 2, Cardinality: storage.Cardinality{Max: 1}}
+	if err != expected {
+		t.Errorf("expected %#v, got %#v", expected, err)
+	}
+
+	expectAuthors(t, s, articleID, grace)
+
+	_, err = s.ReadTrashed("author", ada)
+	if err != nil {
+		t.Errorf("expected the author to stay in the trash, got %#v", err)
+	}
+}
+
+// fixedID generates the same ID every time, like clients supplying the IDs they know.
+type fixedID string
+
+func (id fixedID) Generate(entity storage.Entity, resource storage.CollapsedResource) (string, error) {
+	return string(id), nil
+}
+
+func TestRestoreReusedID(t *testing.T) {
+	entities := trashEntities(storage.Hooks{})
+	entities[0].IDGenerator = fixedID("ada")
+	s, release := newStorage(t, entities)
+	defer release()
+
+	create(t, s, "author", "Ada")
+	err := s.Delete("author", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(t, s, "author", "Ada Lovelace")
+
+	_, err = s.RestoreTrashed("author", "ada")
+	if err != (storage.Conflict{Entity: "author", ID: "ada"}) {
+		t.Errorf("expected the reused ID to conflict, got %#v", err)
+	}
+
+	if names := readNames(t, s, "author"); names["ada"] != "Ada Lovelace" {
+		t.Errorf("expected the new author to be kept, got %v", names)
+	}
+}
+
+func TestTrashReusedID(t *testing.T) {
+	entities := trashEntities(storage.Hooks{})
+	entities[0].IDGenerator = fixedID("ada")
+	s, release := newStorage(t, entities)
+	defer release()
+
+	create(t, s, "author", "Ada")
+	err := s.Delete("author", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(t, s, "author", "Ada Lovelace")
+
+	err = s.Delete("author", "ada")
+	if err != (storage.Conflict{Entity: "author", ID: "ada"}) {
+		t.Fatalf("expected the reused ID to conflict, got %#v", err)
+	}
+
+	if names := readNames(t, s, "author"); names["ada"] != "Ada Lovelace" {
+		t.Errorf("expected the new author to be kept, got %v", names)
+	}
+
+	trashed, err := s.ReadTrashed("author", "ada")
+	if err != nil || dataName(t, trashed.Resource) != "Ada" {
+		t.Errorf("expected the former author to stay in the trash, got %+v %v", trashed, err)
+	}
+
+	err = s.PurgeTrashed("author", "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Delete("author", "ada")
+	if err != nil {
+		t.Errorf("expected the author to be deleted once the trash is purged, got %v", err)
+	}
+}
+
+func TestTrashRollback(t *testing.T) {
+	failure := errors.New("failure")
+	s, release := newStorage(t, trashEntities(storage.Hooks{
+		AfterDelete: func(s *storage.Storage, resource storage.CollapsedResource) error {
+			return failure
+		},
+	}))
+	defer release()
+
+	authorID := create(t, s, "author", "Ada")
+	articleID := createArticle(t, s, "Notes", authorID)
+
+	err := s.Delete("author", authorID)
+	if err != failure {
+		t.Fatalf("expected the hook to fail, got %#v", err)
+	}
+
+	if names := readNames(t, s, "author"); !reflect.DeepEqual(names, map[string]string{authorID: "Ada"}) {
+		t.Errorf("expected the author to be kept, got %v", names)
+	}
+	expectAuthors(t, s, articleID, authorID)
+
+	trash, err := s.Trash("author")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 0 {
+		t.Errorf("expected the trash to be empty, got %+v", trash)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	s, release := newStorage(t, trashEntities(storage.Hooks{}))
+	defer release()
+
+	authorID := create(t, s, "author", "Ada")
+	articleID := create(t, s, "article", "Notes")
+	for _, deleted := range []struct{ entityName, id string }{{"author", authorID}, {"article", articleID}} {
+		err := s.Delete(deleted.entityName, deleted.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := s.PurgeExpiredTrash(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 0 {
+		t.Errorf("expected nothing to expire within an hour, got %d", purged)
+	}
+
+	purged, err = s.PurgeExpiredTrash(time.Now().Add(25 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("expected the author to expire, got %d purged", purged)
+	}
+
+	_, err = s.ReadTrashed("author", authorID)
+	if err != (storage.NotFound{Entity: "author", ID: authorID}) {
+		t.Errorf("expected the author to be purged, got %#v", err)
+	}
+
+	_, err = s.ReadTrashed("article", articleID)
+	if err != nil {
+		t.Errorf("expected articles to be kept forever, got %#v", err)
+	}
+}
+
+func TestStartTrashRetention(t *testing.T) {
+	entity := storage.Entity{Name: "item", Data: reflect.TypeOf(testData{}), SoftDelete: true, Retention: time.Nanosecond}
+	s, release := newStorage(t, []storage.Entity{entity})
+	defer release()
+
+	id := create(t, s, "item", "first")
+	err := s.Delete("item", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	stop := s.StartTrashRetention(time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err = s.ReadTrashed("item", id)
+		if _, ok := err.(storage.NotFound); ok {
+			break
+		}
+
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		default:
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the item to be purged, got %#v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
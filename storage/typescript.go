@@ -0,0 +1,448 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const MetaActionTypeScript = "typescript"
+
+var typeScriptIdentifierRegex = regexp.MustCompile("^[A-Za-z_$][A-Za-z0-9_$]*$")
+
+// CreateTypeScript generates TypeScript interfaces of the data, collapsed, expanded and referenced by representations
+// of the entities and of the other documents of the service from their swagger definitions, together with a fetch based
+// client of the routes of the service.
+// The interfaces of an entity are named by its name in Pascal case, e.g. Article, ArticleData, ArticleExpanded
+// and ArticleReferencedBy. Data types containing themselves are declared by their Go type names in Pascal case.
+func CreateTypeScript(entities Entities, info Info) (string, error) {
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "// Code generated by jsonmancer for %s %s. DO NOT EDIT.\n\n", info.Title, info.Version)
+
+	meta, err := CreateSwaggerDefinition(Metadata{})
+	if err != nil {
+		return "", err
+	}
+	writeTypeScriptType(out, "Metadata", meta)
+	out.WriteString(typeScriptPreamble)
+
+	definitions := map[string]interface{}{}
+
+	for _, entity := range entities.All() {
+		name := typeScriptTypeName(entity.Name)
+
+		data, err := CreateSwaggerDefinition(reflect.New(entity.Data).Interface())
+		if err != nil {
+			return "", err
+		}
+		err = liftDefinitions(definitions, data)
+		if err != nil {
+			return "", err
+		}
+		markFieldPermissions(data, entity.Fields)
+		writeTypeScriptType(out, name+"Data", data)
+
+		relationNames := make([]string, 0, len(entity.References))
+		for relationName := range entity.References {
+			relationNames = append(relationNames, relationName)
+		}
+		sort.Strings(relationNames)
+
+		collapsed := "{}"
+		expanded := "{}"
+		if len(relationNames) != 0 {
+			collapsed = "{\n"
+			expanded = "{\n"
+			for _, relationName := range relationNames {
+				collapsed += fmt.Sprintf("    %s: string[];\n", typeScriptPropertyName(relationName))
+				expanded += fmt.Sprintf("    %s: %sExpanded[];\n", typeScriptPropertyName(relationName), typeScriptTypeName(entity.References[relationName].Name))
+			}
+			collapsed += "  }"
+			expanded += "  }"
+		}
+
+		fmt.Fprintf(out, "export interface %s {\n  id: string;\n  data: %sData;\n  references: %s;\n  readonly meta: Metadata;\n}\n\n", name, name, collapsed)
+		fmt.Fprintf(out, "export interface %sExpanded {\n  id: string;\n  data: %sData;\n  references: %s;\n  readonly meta: Metadata;\n}\n\n", name, name, expanded)
+
+		referencedByMap, err := entities.CreateReferencedByMap(entity.Name)
+		if err != nil {
+			return "", err
+		}
+
+		referencedBy, err := CreateSwaggerDefinition(referencedByMap)
+		if err != nil {
+			return "", err
+		}
+		writeTypeScriptType(out, name+"ReferencedBy", referencedBy)
+	}
+
+	definitionNames := make([]string, 0, len(definitions))
+	for definitionName := range definitions {
+		definitionNames = append(definitionNames, definitionName)
+	}
+	sort.Strings(definitionNames)
+
+	typeNames := map[string]string{}
+	for _, definitionName := range definitionNames {
+		typeName := typeScriptDefinitionName(definitionName)
+		if other, ok := typeNames[typeName]; ok {
+			return "", fmt.Errorf("cannot name both %s and %s %s in TypeScript", other, definitionName, typeName)
+		}
+		typeNames[typeName] = definitionName
+
+		writeTypeScriptType(out, typeName, definitions[definitionName])
+	}
+
+	err = writeTypeScriptWrapper(out, "AuditRecord", AuditRecord{}, "before", "after")
+	if err != nil {
+		return "", err
+	}
+	err = writeTypeScriptWrapper(out, "Revision", Revision{}, "resource")
+	if err != nil {
+		return "", err
+	}
+	err = writeTypeScriptWrapper(out, "TrashedResource", TrashedResource{}, "resource")
+	if err != nil {
+		return "", err
+	}
+
+	event, err := CreateSwaggerDefinition(Event{})
+	if err != nil {
+		return "", err
+	}
+	writeTypeScriptType(out, "ResourceEvent", event)
+
+	serviceDefinitions := map[string]interface{}{}
+	err = addServiceDefinitions(serviceDefinitions)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"Webhook", "WebhookDelivery", "WebhookDeadLetter"} {
+		definition := serviceDefinitions[name].(map[string]interface{})
+		if properties := definition["properties"].(map[string]interface{}); properties["event"] != nil {
+			properties["event"] = map[string]interface{}{"$ref": "#/definitions/ResourceEvent"}
+		}
+		writeTypeScriptType(out, name, definition)
+	}
+
+	out.WriteString(typeScriptClient)
+
+	for _, entity := range entities.All() {
+		name := typeScriptTypeName(entity.Name)
+		collection := typeScriptString("/" + entity.Name)
+		resource := typeScriptString("/" + entity.Name + "/")
+		expand := typeScriptString(fmt.Sprintf("/%s/%s/", entity.Name, ActionExpand))
+		referencedBy := typeScriptString(fmt.Sprintf("/%s/%s/", entity.Name, ActionReferencedBy))
+		bulk := typeScriptString(fmt.Sprintf("/%s/%s", entity.Name, ActionBulk))
+		audit := typeScriptString(fmt.Sprintf("/%s/%s/", entity.Name, ActionAudit))
+		history := typeScriptString("/" + ActionHistory)
+		trash := typeScriptString(fmt.Sprintf("/%s/%s", entity.Name, ActionTrash))
+		trashed := typeScriptString(fmt.Sprintf("/%s/%s/", entity.Name, ActionTrash))
+
+		fmt.Fprintf(out, "\n  readonly %s = {\n", typeScriptPropertyName(entity.Name))
+		fmt.Fprintf(out, "    list: () => this.request<%s[]>(\"GET\", %s),\n", name, collection)
+		fmt.Fprintf(out, "    create: (resource: Input<%s>) => this.request<%s>(\"POST\", %s, resource),\n", name, name, collection)
+		fmt.Fprintf(out, "    read: (id: string) => this.request<%s>(\"GET\", %s + encodeURIComponent(id)),\n", name, resource)
+		fmt.Fprintf(out, "    update: (resource: Input<%s> & { id: string }) =>\n      this.request<%s>(\"PUT\", %s + encodeURIComponent(resource.id), resource),\n", name, name, resource)
+		fmt.Fprintf(out, "    delete: (id: string) => this.request<void>(\"DELETE\", %s + encodeURIComponent(id)),\n", resource)
+		fmt.Fprintf(out, "    expand: (id: string) => this.request<%sExpanded>(\"GET\", %s + encodeURIComponent(id)),\n", name, expand)
+		fmt.Fprintf(out, "    referencedBy: (id: string) => this.request<%sReferencedBy>(\"GET\", %s + encodeURIComponent(id)),\n", name, referencedBy)
+		fmt.Fprintf(out, "    bulk: (operations: BulkOperation<Input<%s>>[], atomic = false) =>\n", name)
+		fmt.Fprintf(out, "      this.request<BulkResult<%s>[]>(\"POST\", %s + (atomic ? \"?atomic=true\" : \"\"), operations),\n", name, bulk)
+		fmt.Fprintf(out, "    audit: (id: string) => this.request<AuditRecord<%s>[]>(\"GET\", %s + encodeURIComponent(id)),\n", name, audit)
+		if entity.Versioned {
+			fmt.Fprintf(out, "    readVersion: (id: string, version: number) =>\n      this.request<%s>(\"GET\", %s + encodeURIComponent(id) + \"?version=\" + version),\n", name, resource)
+			fmt.Fprintf(out, "    history: (id: string) => this.request<Revision<%s>[]>(\"GET\", %s + encodeURIComponent(id) + %s),\n", name, resource, history)
+			fmt.Fprintf(out, "    restoreVersion: (id: string, version: number) =>\n      this.request<%s>(\"POST\", %s + encodeURIComponent(id) + %s + \"?version=\" + version),\n", name, resource, history)
+		}
+		if entity.SoftDelete {
+			fmt.Fprintf(out, "    trash: () => this.request<TrashedResource<%s>[]>(\"GET\", %s),\n", name, trash)
+			fmt.Fprintf(out, "    readTrashed: (id: string) => this.request<TrashedResource<%s>>(\"GET\", %s + encodeURIComponent(id)),\n", name, trashed)
+			fmt.Fprintf(out, "    restore: (id: string) => this.request<%s>(\"POST\", %s + encodeURIComponent(id)),\n", name, trashed)
+			fmt.Fprintf(out, "    purge: (id: string) => this.request<void>(\"DELETE\", %s + encodeURIComponent(id)),\n", trashed)
+		}
+		out.WriteString("  };\n")
+	}
+
+	out.WriteString("}\n")
+
+	return out.String(), nil
+}
+
+// writeTypeScriptType declares an interface of an object schema with properties and a type alias otherwise.
+func writeTypeScriptType(out *bytes.Buffer, name string, schema interface{}) {
+	definition := typeScriptType(schema, "")
+
+	object, _ := schema.(map[string]interface{})
+	if properties, ok := object["properties"].(map[string]interface{}); ok && len(properties) != 0 && object["x-nullable"] != true {
+		fmt.Fprintf(out, "export interface %s %s\n\n", name, definition)
+		return
+	}
+
+	fmt.Fprintf(out, "export type %s = %s;\n\n", name, definition)
+}
+
+// typeScriptType returns the type of a schema. Read only properties are read only, write only and omitted ones optional.
+func typeScriptType(schema interface{}, indent string) string {
+	object, _ := schema.(map[string]interface{})
+
+	var result string
+	switch {
+	case object["$ref"] != nil:
+		result = typeScriptDefinitionName(strings.TrimPrefix(object["$ref"].(string), "#/definitions/"))
+	case object["enum"] != nil:
+		values := []string{}
+		for _, value := range object["enum"].([]interface{}) {
+			literal, _ := json.Marshal(value)
+			values = append(values, string(literal))
+		}
+		result = strings.Join(values, " | ")
+	case object["type"] == "string":
+		result = "string"
+	case object["type"] == "integer", object["type"] == "number":
+		result = "number"
+	case object["type"] == "boolean":
+		result = "boolean"
+	case object["type"] == "array":
+		result = typeScriptType(object["items"], indent)
+		if strings.Contains(result, " | ") {
+			result = "(" + result + ")"
+		}
+		result += "[]"
+	case object["type"] == "object" && object["properties"] != nil && len(object["properties"].(map[string]interface{})) == 0:
+		result = "{}"
+	case object["type"] == "object" && object["properties"] != nil:
+		properties := object["properties"].(map[string]interface{})
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		lines := []string{"{"}
+		for _, name := range names {
+			property, _ := properties[name].(map[string]interface{})
+
+			modifier := ""
+			if property["readOnly"] == true {
+				modifier = "readonly "
+			}
+
+			optional := ""
+			if property["x-omitempty"] == true || property["x-writeOnly"] == true {
+				optional = "?"
+			}
+
+			lines = append(lines, fmt.Sprintf("%s  %s%s%s: %s;", indent, modifier, typeScriptPropertyName(name), optional, typeScriptType(property, indent+"  ")))
+		}
+		lines = append(lines, indent+"}")
+		result = strings.Join(lines, "\n")
+	case object["type"] == "object" && object["additionalProperties"] != nil:
+		result = "{ [key: string]: " + typeScriptType(object["additionalProperties"], indent) + " }"
+	case object["type"] == "object":
+		result = "{ [key: string]: unknown }"
+	default:
+		result = "unknown"
+	}
+
+	if object["x-nullable"] == true {
+		result += " | null"
+	}
+
+	return result
+}
+
+// writeTypeScriptWrapper declares a generic interface of a type holding resources of the type parameter T
+// in the given properties.
+func writeTypeScriptWrapper(out *bytes.Buffer, name string, wrapper interface{}, resourceProperties ...string) error {
+	definitions := map[string]interface{}{}
+	err := addWrapperDefinition(definitions, name, wrapper, "T", resourceProperties...)
+	if err != nil {
+		return err
+	}
+
+	writeTypeScriptType(out, name+"<T>", definitions[name])
+
+	return nil
+}
+
+// typeScriptDefinitionName names the type of a definition by the name of its Go type without the package path.
+func typeScriptDefinitionName(definitionName string) string {
+	return typeScriptTypeName(definitionName[strings.LastIndex(definitionName, ".")+1:])
+}
+
+// typeScriptTypeName converts an entity name into Pascal case, dropping characters which are invalid in identifiers.
+func typeScriptTypeName(entityName string) string {
+	name := []rune{}
+	upper := true
+	for _, r := range entityName {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		name = append(name, r)
+	}
+
+	if len(name) == 0 || unicode.IsDigit(name[0]) {
+		name = append([]rune("Entity"), name...)
+	}
+
+	return string(name)
+}
+
+func typeScriptString(s string) string {
+	quoted, _ := json.Marshal(s)
+
+	return string(quoted)
+}
+
+// typeScriptPropertyName quotes names which are no identifiers.
+func typeScriptPropertyName(name string) string {
+	if typeScriptIdentifierRegex.MatchString(name) {
+		return name
+	}
+
+	return typeScriptString(name)
+}
+
+const typeScriptPreamble = `export interface ApiError {
+  error: string;
+  type?: string;
+  details?: unknown;
+}
+
+// Input is a resource as it is created or updated. The ID is generated unless the entity lets clients supply it.
+export type Input<T extends { data: unknown; references: unknown }> = {
+  id?: string;
+  data: T["data"];
+  references?: Partial<T["references"]>;
+};
+
+export type BulkOperation<T> =
+  | { op: "create"; resource: T }
+  | { op: "update"; id?: string; resource: T }
+  | { op: "delete"; id: string };
+
+export interface BulkResult<T> {
+  index: number;
+  op: "create" | "update" | "delete";
+  id?: string;
+  status: number;
+  error?: string;
+  resource?: T;
+}
+
+`
+
+const typeScriptClient = `// ClientError is thrown for error responses, describing the error by its type and details if it is one of the storage.
+export class ClientError extends Error {
+  constructor(readonly status: number, readonly response: ApiError) {
+    super(response.error);
+  }
+}
+
+// Client calls the API at baseUrl, sending every request with init, e.g. to authenticate.
+export class Client {
+  constructor(private readonly baseUrl: string = "", private readonly init: RequestInit = {}) {}
+
+  private async fetch(method: string, path: string, accept: string, body?: unknown): Promise<Response> {
+    const headers = new Headers(this.init.headers);
+    headers.set("Accept", accept);
+    if (body !== undefined) {
+      headers.set("Content-Type", "application/json");
+    }
+
+    const response = await fetch(this.baseUrl.replace(/\/$/, "") + path, {
+      ...this.init,
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+    if (!response.ok) {
+      let error: ApiError;
+      try {
+        error = await response.json();
+      } catch {
+        error = { error: response.statusText };
+      }
+      throw new ClientError(response.status, error);
+    }
+
+    return response;
+  }
+
+  private async request<T>(method: string, path: string, body?: unknown): Promise<T> {
+    const response = await this.fetch(method, path, "application/json", body);
+    if (response.status === 202 || response.status === 204) {
+      return undefined as T;
+    }
+
+    return response.json();
+  }
+
+  // events yields the events of the given entities, all if there are none, resuming after the sequence number since.
+  // Events of resources the principal may not read are left out.
+  async *events(entities: string[] = [], since?: number): AsyncGenerator<ResourceEvent> {
+    const query = new URLSearchParams();
+    for (const entity of entities) {
+      query.append("entity", entity);
+    }
+    if (since !== undefined) {
+      query.set("since", String(since));
+    }
+
+    const response = await this.fetch("GET", "/meta/events" + (query.toString() ? "?" + query : ""), "text/event-stream");
+    if (!response.body) {
+      return;
+    }
+
+    const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffer = "";
+    for (;;) {
+      const { done, value } = await reader.read();
+      if (done) {
+        return;
+      }
+
+      buffer += value;
+      let end: number;
+      while ((end = buffer.indexOf("\n\n")) !== -1) {
+        const data = buffer
+          .slice(0, end)
+          .split("\n")
+          .filter((line) => line.startsWith("data: "))
+          .map((line) => line.slice("data: ".length))
+          .join("\n");
+        buffer = buffer.slice(end + 2);
+
+        if (data) {
+          yield JSON.parse(data);
+        }
+      }
+    }
+  }
+
+  readonly webhooks = {
+    list: () => this.request<Webhook[]>("GET", "/meta/webhooks"),
+    create: (webhook: Omit<Webhook, "id" | "principal">) => this.request<Webhook>("POST", "/meta/webhooks", webhook),
+    read: (id: string) => this.request<Webhook>("GET", "/meta/webhooks/" + encodeURIComponent(id)),
+    update: (webhook: Omit<Webhook, "principal">) =>
+      this.request<Webhook>("PUT", "/meta/webhooks/" + encodeURIComponent(webhook.id), webhook),
+    delete: (id: string) => this.request<void>("DELETE", "/meta/webhooks/" + encodeURIComponent(id)),
+    deliveries: (id: string) => this.request<WebhookDelivery[]>("GET", "/meta/webhook-deliveries/" + encodeURIComponent(id)),
+    deadLetters: () => this.request<WebhookDeadLetter[]>("GET", "/meta/webhook-dead-letters"),
+    redeliver: (deadLetterId: string) =>
+      this.request<void>("POST", "/meta/webhook-dead-letters/" + encodeURIComponent(deadLetterId)),
+  };
+`
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateTypeScript(t *testing.T) {
+	typeScript, err := CreateTypeScript(specEntities(t), FixtureInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGoldenFile(t, "typescript.ts", []byte(typeScript))
+}
+
+func TestCreateTypeScriptRecursive(t *testing.T) {
+	entities, err := NewEntities([]Entity{{Name: "node", Data: reflect.TypeOf(swaggerNode{})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typeScript, err := CreateTypeScript(entities, FixtureInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"export interface SwaggerNode {\n  children: SwaggerNode[];\n  labels: { [key: string]: SwaggerTree };\n  name: string;\n  parent: SwaggerNode | null;\n}\n",
+		"export type SwaggerTree = { [key: string]: SwaggerTree };\n",
+		"export interface NodeData {\n  children: SwaggerNode[];",
+	} {
+		if !strings.Contains(typeScript, expected) {
+			t.Errorf("expected the TypeScript to contain %q:\n%s", expected, typeScript)
+		}
+	}
+}
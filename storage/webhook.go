@@ -0,0 +1,448 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookCollection           = "webhooks"
+	WebhookDeliveryCollection   = "webhooks.deliveries"
+	WebhookDeadLetterCollection = "webhooks.dead-letters"
+)
+
+const (
+	WebhookSignatureHeader = "X-Jsonmancer-Signature"
+	WebhookTimestampHeader = "X-Jsonmancer-Timestamp"
+	WebhookEventHeader     = "X-Jsonmancer-Event"
+	WebhookDeliveryHeader  = "X-Jsonmancer-Delivery"
+)
+
+// Webhook posts the events of the given entities and types, all if there are none, to its URL.
+// Deliveries are signed with the secret: the signature header is "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the timestamp header, a dot and the body.
+// Events are delivered as the principal who created or last updated the webhook would see them:
+// those of resources it may not read are skipped and the fields it may not read are hidden.
+type Webhook struct {
+	ID        string      `json:"id" bson:"_id"`
+	URL       string      `json:"url" bson:"url"`
+	Secret    string      `json:"secret,omitempty" bson:"secret"`
+	Entities  []string    `json:"entities" bson:"entities"`
+	Events    []EventType `json:"events" bson:"events"`
+	Principal Principal   `json:"principal" bson:"principal"`
+}
+
+func (w Webhook) matches(event Event) bool {
+	if len(w.Entities) != 0 && !containsString(w.Entities, event.Entity) {
+		return false
+	}
+
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, eventType := range w.Events {
+		if eventType == event.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery is an attempt to deliver an event.
+type WebhookDelivery struct {
+	ID         string    `json:"id" bson:"_id"`
+	WebhookID  string    `json:"webhookId" bson:"webhookId"`
+	Event      Event     `json:"event" bson:"event"`
+	Attempt    int       `json:"attempt" bson:"attempt"`
+	Time       time.Time `json:"time" bson:"time"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	Delivered  bool      `json:"delivered" bson:"delivered"`
+}
+
+// WebhookDeadLetter is an event which couldn't be delivered in any attempt.
+type WebhookDeadLetter struct {
+	ID        string    `json:"id" bson:"_id"`
+	WebhookID string    `json:"webhookId" bson:"webhookId"`
+	Event     Event     `json:"event" bson:"event"`
+	Attempts  int       `json:"attempts" bson:"attempts"`
+	Time      time.Time `json:"time" bson:"time"`
+	Error     string    `json:"error" bson:"error"`
+}
+
+// Webhooks manages webhooks and delivers the events of an event bus to them.
+// Webhooks, deliveries and dead letters are stored in the repository.
+type Webhooks struct {
+	// Client defaults to one with a timeout of 10 seconds.
+	Client *http.Client
+	// MaxAttempts defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every further one up to MaxBackoff.
+	// They default to one second and five minutes.
+	Backoff, MaxBackoff time.Duration
+
+	storage     Storage
+	repository  Repository
+	idGenerator IDGenerator
+
+	mutex  sync.Mutex
+	queue  []Event
+	signal chan struct{}
+	done   chan struct{}
+	wait   sync.WaitGroup
+}
+
+// NewWebhooks stores the webhooks in the repository of the storage, which also decides what their principals may read.
+func NewWebhooks(storage Storage, idGenerator IDGenerator) *Webhooks {
+	return &Webhooks{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  5 * time.Minute,
+		storage:     storage,
+		repository:  storage.repository,
+		idGenerator: idGenerator,
+	}
+}
+
+// Start delivers the events published to the bus until stop is called.
+// Stopping abandons pending retries.
+func (w *Webhooks) Start(bus *EventBus) (stop func()) {
+	w.signal = make(chan struct{}, 1)
+	w.done = make(chan struct{})
+
+	unsubscribe := bus.Subscribe(w.enqueue)
+
+	w.wait.Add(1)
+	go w.dispatch()
+
+	return func() {
+		unsubscribe()
+		close(w.done)
+		w.wait.Wait()
+	}
+}
+
+func (w *Webhooks) List() ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := w.repository.ReadAll(WebhookCollection, Query{}, &webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+func (w *Webhooks) Get(id string) (Webhook, error) {
+	webhook := Webhook{}
+	err := w.repository.Read(WebhookCollection, id, &webhook)
+
+	return webhook, err
+}
+
+func (w *Webhooks) Create(webhook Webhook) (Webhook, error) {
+	err := webhook.validate()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	webhook.ID, err = w.generateID(WebhookCollection)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return webhook, w.repository.Create(WebhookCollection, webhook)
+}
+
+// Update replaces the webhook. Its secret is kept unless a new one is given, since clients never get it back.
+func (w *Webhooks) Update(webhook Webhook) error {
+	err := webhook.validate()
+	if err != nil {
+		return err
+	}
+
+	if webhook.Secret == "" {
+		stored, err := w.Get(webhook.ID)
+		if err != nil {
+			return err
+		}
+
+		webhook.Secret = stored.Secret
+	}
+
+	return w.repository.Update(WebhookCollection, webhook.ID, webhook)
+}
+
+func (w *Webhooks) Delete(id string) error {
+	return w.repository.Delete(WebhookCollection, id)
+}
+
+// Deliveries returns the delivery log of a webhook in chronological order.
+func (w *Webhooks) Deliveries(webhookID string) ([]WebhookDelivery, error) {
+	query := Query{Q: map[string]FieldQuery{"webhookId": {Kind: QueryAnd, Values: []interface{}{webhookID}}}}
+
+	deliveries := []WebhookDelivery{}
+	err := w.repository.ReadAll(WebhookDeliveryCollection, query, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Time.Before(deliveries[j].Time)
+	})
+
+	return deliveries, nil
+}
+
+func (w *Webhooks) DeadLetters() ([]WebhookDeadLetter, error) {
+	deadLetters := []WebhookDeadLetter{}
+	err := w.repository.ReadAll(WebhookDeadLetterCollection, Query{}, &deadLetters)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(deadLetters, func(i, j int) bool {
+		return deadLetters[i].Time.Before(deadLetters[j].Time)
+	})
+
+	return deadLetters, nil
+}
+
+// Redeliver removes a dead letter and delivers its event again with fresh attempts.
+func (w *Webhooks) Redeliver(deadLetterID string) error {
+	deadLetter := WebhookDeadLetter{}
+	err := w.repository.Read(WebhookDeadLetterCollection, deadLetterID, &deadLetter)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := w.Get(deadLetter.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	err = w.repository.Delete(WebhookDeadLetterCollection, deadLetterID)
+	if err != nil {
+		return err
+	}
+
+	w.wait.Add(1)
+	go w.deliver(webhook, deadLetter.Event)
+
+	return nil
+}
+
+func (w *Webhooks) enqueue(event Event) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, event)
+	w.mutex.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Webhooks) dispatch() {
+	defer w.wait.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.signal:
+		}
+
+		w.mutex.Lock()
+		events := w.queue
+		w.queue = nil
+		w.mutex.Unlock()
+
+		// Without the webhooks the events can't be delivered to anyone.
+		webhooks, err := w.List()
+		if err != nil {
+			continue
+		}
+
+		for _, event := range events {
+			for _, webhook := range webhooks {
+				if !webhook.matches(event) {
+					continue
+				}
+
+				principal := w.storage.As(webhook.Principal)
+				visible, ok, err := principal.visibleEvent(event)
+				if err != nil {
+					log.Printf("cannot deliver event %d to webhook %q: %v", event.Sequence, webhook.ID, err)
+					continue
+				}
+				if !ok {
+					continue
+				}
+
+				w.wait.Add(1)
+				go w.deliver(webhook, visible)
+			}
+		}
+	}
+}
+
+// deliver tries to deliver the event until it succeeds or runs out of attempts.
+func (w *Webhooks) deliver(webhook Webhook, event Event) {
+	defer w.wait.Done()
+
+	backoff := w.Backoff
+	for attempt := 1; ; attempt++ {
+		delivery := w.attempt(webhook, event, attempt)
+		err := w.repository.Create(WebhookDeliveryCollection, delivery)
+		if err != nil {
+			log.Printf("cannot log attempt %d to deliver event %d to webhook %q: %v", attempt, event.Sequence, webhook.ID, err)
+		}
+
+		if delivery.Delivered {
+			return
+		}
+
+		if attempt >= w.MaxAttempts {
+			id, err := w.generateID(WebhookDeadLetterCollection)
+			if err == nil {
+				err = w.repository.Create(WebhookDeadLetterCollection, WebhookDeadLetter{
+					ID:        id,
+					WebhookID: webhook.ID,
+					Event:     event,
+					Attempts:  attempt,
+					Time:      time.Now().UTC(),
+					Error:     delivery.Error,
+				})
+			}
+			if err != nil {
+				log.Printf("cannot store the dead letter of event %d for webhook %q: %v", event.Sequence, webhook.ID, err)
+			}
+
+			return
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > w.MaxBackoff {
+			backoff = w.MaxBackoff
+		}
+	}
+}
+
+func (w *Webhooks) attempt(webhook Webhook, event Event, attempt int) WebhookDelivery {
+	delivery := WebhookDelivery{
+		WebhookID: webhook.ID,
+		Event:     event,
+		Attempt:   attempt,
+		Time:      time.Now().UTC(),
+	}
+
+	id, err := w.generateID(WebhookDeliveryCollection)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	delivery.ID = id
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := strconv.FormatInt(delivery.Time.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, string(event.Type))
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, body))
+
+	response, err := w.Client.Do(request)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	response.Body.Close()
+
+	delivery.StatusCode = response.StatusCode
+	delivery.Delivered = response.StatusCode >= 200 && response.StatusCode < 300
+	if !delivery.Delivered {
+		delivery.Error = response.Status
+	}
+
+	return delivery
+}
+
+// SignWebhook returns the signature header of a delivery, which receivers compare to their own.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w Webhook) validate() error {
+	target, err := url.Parse(w.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return InvalidInput{fmt.Sprintf("webhook URL %q is no absolute HTTP URL", w.URL)}
+	}
+
+	for _, eventType := range w.Events {
+		switch eventType {
+		case EventCreated, EventUpdated, EventDeleted:
+		default:
+			return InvalidInput{fmt.Sprintf("unknown event type %q", eventType)}
+		}
+	}
+
+	return nil
+}
+
+func (w *Webhooks) generateID(collectionName string) (string, error) {
+	generator := w.idGenerator
+	if bound, ok := generator.(RepositoryIDGenerator); ok {
+		generator = bound.WithRepository(w.repository)
+	}
+
+	return generator.Generate(Entity{Name: collectionName}, CollapsedResource{})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
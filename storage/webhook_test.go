@@ -0,0 +1,307 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanShu93/jsonmancer/bolt"
+	"github.com/DanShu93/jsonmancer/id"
+	"github.com/DanShu93/jsonmancer/storage"
+)
+
+type webhookData struct {
+	Name   string `json:"name"`
+	Owner  string `json:"owner,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+const webhookSecret = "secret"
+
+// receiver is a webhook stub failing the first requests.
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	events   []storage.Event
+	errors   []string
+}
+
+func (r *receiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	signature := storage.SignWebhook(webhookSecret, req.Header.Get(storage.WebhookTimestampHeader), body)
+	if req.Header.Get(storage.WebhookSignatureHeader) != signature {
+		r.errors = append(r.errors, "invalid signature")
+	}
+
+	if r.failures > 0 {
+		r.failures--
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	event := storage.Event{}
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		r.errors = append(r.errors, err.Error())
+	}
+
+	r.events = append(r.events, event)
+}
+
+func (r *receiver) received() ([]storage.Event, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]storage.Event{}, r.events...), append([]string{}, r.errors...)
+}
+
+// setUpWebhooks starts delivering the creations of items to a webhook of the principal.
+func setUpWebhooks(t *testing.T, handler http.Handler, principal storage.Principal, options ...storage.Option) (storage.Storage, *storage.Webhooks, string, func()) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := bolt.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := storage.NewEventBus(16)
+	s, err := storage.New(
+		[]storage.Entity{{
+			Name:   "item",
+			Data:   reflect.TypeOf(webhookData{}),
+			Fields: map[string]storage.FieldPermission{"secret": {Hidden: true}},
+		}},
+		repository,
+		id.UUIDv4{},
+		append(options, storage.WithEventBus(bus))...,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks := storage.NewWebhooks(s, id.UUIDv4{})
+	webhooks.MaxAttempts = 3
+	webhooks.Backoff = time.Millisecond
+	webhooks.MaxBackoff = 2 * time.Millisecond
+
+	server := httptest.NewServer(handler)
+	webhook, err := webhooks.Create(storage.Webhook{
+		URL:       server.URL,
+		Secret:    webhookSecret,
+		Entities:  []string{"item"},
+		Events:    []storage.EventType{storage.EventCreated},
+		Principal: principal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := webhooks.Start(bus)
+
+	return s, webhooks, webhook.ID, func() {
+		stop()
+		server.Close()
+		repository.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func waitForDeliveries(t *testing.T, webhooks *storage.Webhooks, webhookID string, count int) []storage.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := webhooks.Deliveries(webhookID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(deliveries) >= count || time.Now().After(deadline) {
+			return deliveries
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	stub := &receiver{failures: 1}
+	s, webhooks, webhookID, tearDown := setUpWebhooks(t, stub, storage.Principal{})
+	defer tearDown()
+
+	created, err := s.CreateFromJSON("item", `{"data":{"name":"first"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UpdateFromJSON("item", `{"id":"`+created.ID+`","data":{"name":"second"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := waitForDeliveries(t, webhooks, webhookID, 2)
+	if len(deliveries) != 2 || deliveries[0].Delivered || !deliveries[1].Delivered || deliveries[1].Attempt != 2 {
+		t.Fatalf("expected a failed and a successful attempt, got %+v", deliveries)
+	}
+
+	events, errors := stub.received()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors %q", errors)
+	}
+
+	if len(events) != 1 || events[0].Type != storage.EventCreated || events[0].ID != created.ID {
+		t.Errorf("expected the creation of %q only, got %+v", created.ID, events)
+	}
+}
+
+func TestWebhookVisibility(t *testing.T) {
+	policy := storage.Policy{"item": {
+		storage.OperationCreate: {{}},
+		storage.OperationRead:   {{Where: map[string]string{"data.owner": "id"}}},
+	}}
+	stub := &receiver{}
+	s, webhooks, webhookID, tearDown := setUpWebhooks(t, stub, storage.Principal{ID: "alice"}, storage.WithPolicy(policy))
+	defer tearDown()
+
+	_, err := s.CreateFromJSON("item", `{"data":{"name":"other","owner":"bob"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := s.CreateFromJSON("item", `{"data":{"name":"own","owner":"alice","secret":"hidden"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := waitForDeliveries(t, webhooks, webhookID, 1)
+	if len(deliveries) != 1 || !deliveries[0].Delivered {
+		t.Fatalf("expected a single delivery, got %+v", deliveries)
+	}
+
+	events, _ := stub.received()
+	if len(events) != 1 || events[0].ID != created.ID {
+		t.Fatalf("expected the creation of the readable item only, got %+v", events)
+	}
+
+	data, err := json.Marshal(events[0].Resource.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hidden") {
+		t.Errorf("the delivery reveals the hidden field: %s", data)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	stub := &receiver{failures: 4}
+	s, webhooks, webhookID, tearDown := setUpWebhooks(t, stub, storage.Principal{})
+	defer tearDown()
+
+	_, err := s.CreateFromJSON("item", `{"data":{"name":"first"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := waitForDeliveries(t, webhooks, webhookID, 3)
+	if len(deliveries) != 3 {
+		t.Fatalf("expected 3 attempts, got %+v", deliveries)
+	}
+
+	var deadLetters []storage.WebhookDeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(deadLetters) == 0 && time.Now().Before(deadline) {
+		deadLetters, err = webhooks.DeadLetters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 3 || deadLetters[0].WebhookID != webhookID {
+		t.Fatalf("expected a dead letter after 3 attempts, got %+v", deadLetters)
+	}
+
+	err = webhooks.Redeliver(deadLetters[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForDeliveries(t, webhooks, webhookID, 5)
+	events, _ := stub.received()
+	if len(events) != 1 {
+		t.Errorf("expected the redelivered event, got %+v", events)
+	}
+}
+
+func TestWebhookUpdateKeepsSecret(t *testing.T) {
+	s, webhooks, webhookID, tearDown := setUpWebhooks(t, &receiver{}, storage.Principal{})
+	defer tearDown()
+
+	server := httptest.NewServer(storage.Service{Storage: s, Webhooks: webhooks})
+	defer server.Close()
+
+	put := func(id, body string) (int, map[string]interface{}) {
+		request, err := http.NewRequest(http.MethodPut, server.URL+"/meta/webhooks/"+id, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		document := map[string]interface{}{}
+		json.NewDecoder(response.Body).Decode(&document)
+
+		return response.StatusCode, document
+	}
+
+	status, document := put(webhookID, `{"url":"http://localhost/hook","events":["created","updated"]}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected the webhook to be updated, got %d %v", status, document)
+	}
+	if _, ok := document["secret"]; ok {
+		t.Errorf("the response reveals the secret: %v", document)
+	}
+
+	webhook, err := webhooks.Get(webhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Secret != webhookSecret || webhook.URL != "http://localhost/hook" || len(webhook.Events) != 2 {
+		t.Errorf("expected the secret to be kept while the rest is replaced, got %+v", webhook)
+	}
+
+	status, _ = put(webhookID, `{"url":"http://localhost/hook","secret":"rotated"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected the webhook to be updated, got %d", status)
+	}
+
+	webhook, err = webhooks.Get(webhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Secret != "rotated" {
+		t.Errorf("expected the secret to be replaced, got %q", webhook.Secret)
+	}
+
+	status, _ = put("missing", `{"url":"http://localhost/hook"}`)
+	if status != http.StatusNotFound {
+		t.Errorf("expected missing webhooks not to be found, got %d", status)
+	}
+}
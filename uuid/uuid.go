@@ -0,0 +1,148 @@
+// Package uuid implements the UUIDs of RFC 4122 and the time-ordered version 7 of its successor RFC 9562.
+package uuid
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// UUID is a 128 bit universally unique identifier.
+type UUID [16]byte
+
+// Nil is the UUID with all bits set to zero.
+var Nil UUID
+
+// Namespaces for name-based UUIDs.
+var (
+	NamespaceDNS  = MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	NamespaceURL  = MustParse("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	NamespaceOID  = MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	NamespaceX500 = MustParse("6ba7b814-9dad-11d1-80b4-00c04fd430c8")
+)
+
+type InvalidUUID struct {
+	Input string
+}
+
+func (e InvalidUUID) Error() string {
+	return fmt.Sprintf("invalid UUID %q", e.Input)
+}
+
+// Parse parses a UUID in its canonical form, optionally enclosed in braces or prefixed by "urn:uuid:".
+// Hex digits may be upper case.
+func Parse(s string) (UUID, error) {
+	text := s
+	switch {
+	case strings.HasPrefix(strings.ToLower(text), "urn:uuid:"):
+		text = text[len("urn:uuid:"):]
+	case strings.HasPrefix(text, "{") && strings.HasSuffix(text, "}"):
+		text = text[1 : len(text)-1]
+	}
+
+	if len(text) != 36 || text[8] != '-' || text[13] != '-' || text[18] != '-' || text[23] != '-' {
+		return Nil, InvalidUUID{s}
+	}
+
+	u := UUID{}
+	_, err := hex.Decode(u[:], []byte(text[0:8]+text[9:13]+text[14:18]+text[19:23]+text[24:]))
+	if err != nil {
+		return Nil, InvalidUUID{s}
+	}
+
+	return u, nil
+}
+
+// MustParse parses a UUID and panics if it is invalid.
+func MustParse(s string) UUID {
+	u, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return u
+}
+
+// Validate checks that s is a UUID in canonical form with the variant of RFC 4122 and a known version.
+func Validate(s string) error {
+	u, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	if s != u.String() || u[8]&0xc0 != 0x80 || u.Version() < 1 || u.Version() > 8 {
+		return InvalidUUID{s}
+	}
+
+	return nil
+}
+
+// String returns the canonical form, e.g. "6ba7b810-9dad-11d1-80b4-00c04fd430c8".
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText parses the UUID, treating an empty text as Nil.
+func (u *UUID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*u = Nil
+		return nil
+	}
+
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	*u = parsed
+
+	return nil
+}
+
+// GetBSON stores the UUID in its canonical form like the IDs of resources.
+func (u UUID) GetBSON() (interface{}, error) {
+	return u.String(), nil
+}
+
+// SetBSON reads a UUID stored as string or as binary of subtype 4.
+func (u *UUID) SetBSON(raw bson.Raw) error {
+	if raw.Kind == 0x05 {
+		binary := bson.Binary{}
+		err := raw.Unmarshal(&binary)
+		if err != nil {
+			return err
+		}
+
+		if binary.Kind != 0x04 || len(binary.Data) != len(u) {
+			return InvalidUUID{hex.EncodeToString(binary.Data)}
+		}
+
+		copy(u[:], binary.Data)
+
+		return nil
+	}
+
+	var text string
+	err := raw.Unmarshal(&text)
+	if err != nil {
+		return err
+	}
+
+	return u.UnmarshalText([]byte(text))
+}
+
+// setVersion sets the version and the variant of RFC 4122.
+func (u *UUID) setVersion(version byte) {
+	u[6] = u[6]&0x0f | version<<4
+	u[8] = u[8]&0x3f | 0x80
+}
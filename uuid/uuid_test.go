@@ -0,0 +1,182 @@
+package uuid
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseString(t *testing.T) {
+	roundTrip := func(u UUID) bool {
+		parsed, err := Parse(u.String())
+		return err == nil && parsed == u
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseForms(t *testing.T) {
+	forms := func(u UUID) bool {
+		for _, s := range []string{strings.ToUpper(u.String()), "{" + u.String() + "}", "urn:uuid:" + u.String()} {
+			parsed, err := Parse(s)
+			if err != nil || parsed != u {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if err := quick.Check(forms, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8a",
+		"6ba7b8109dad11d180b400c04fd430c8",
+		"6ba7b810-9dad-11d1-80b4_00c04fd430c8",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430cg",
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+	} {
+		_, err := Parse(s)
+		if _, ok := err.(InvalidUUID); !ok {
+			t.Errorf("expected %q to be invalid, got %v", s, err)
+		}
+	}
+}
+
+func TestGenerated(t *testing.T) {
+	generators := map[int]func() (UUID, error){
+		4: NewV4,
+		7: NewV7,
+	}
+
+	for version, generate := range generators {
+		valid := func() bool {
+			u, err := generate()
+			return err == nil && u.Version() == version && Validate(u.String()) == nil
+		}
+
+		if err := quick.Check(valid, nil); err != nil {
+			t.Errorf("version %d: %s", version, err)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	variants := map[byte]bool{}
+	for i := 0; i < 1000 && len(variants) < 4; i++ {
+		u, err := NewV4()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		variants[u.String()[19]] = true
+	}
+
+	for _, variant := range []byte("89ab") {
+		if !variants[variant] {
+			t.Errorf("variant %q was never generated", variant)
+		}
+	}
+}
+
+func TestV5(t *testing.T) {
+	if u := NewV5(NamespaceDNS, "www.example.com"); u.String() != "2ed6657d-e927-568b-95e1-2665a8aea6a2" {
+		t.Errorf("expected the UUID of www.example.com, got %s", u)
+	}
+
+	deterministic := func(name string) bool {
+		u := NewV5(NamespaceURL, name)
+		return u == NewV5(NamespaceURL, name) && u != NewV5(NamespaceDNS, name) && Validate(u.String()) == nil
+	}
+
+	if err := quick.Check(deterministic, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestV7Ordered(t *testing.T) {
+	previous, _ := NewV7()
+	for i := 0; i < 1000; i++ {
+		u, err := NewV7()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the timestamps are ordered.
+		if u.String()[:13] < previous.String()[:13] {
+			t.Fatalf("%s was generated after %s", u, previous)
+		}
+		previous = u
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(strings.ToUpper(NamespaceDNS.String())); err == nil {
+		t.Error("upper case UUIDs are not canonical")
+	}
+
+	if err := Validate(Nil.String()); err == nil {
+		t.Error("the nil UUID has no version")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	roundTrip := func(u UUID) bool {
+		content, err := json.Marshal(map[string]UUID{"id": u})
+		if err != nil || string(content) != `{"id":"`+u.String()+`"}` {
+			return false
+		}
+
+		result := map[string]UUID{}
+		err = json.Unmarshal(content, &result)
+		return err == nil && result["id"] == u
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	u := UUID{}
+	if err := json.Unmarshal([]byte(`"x"`), &u); err == nil {
+		t.Error("expected invalid JSON UUIDs to fail")
+	}
+}
+
+func TestBSON(t *testing.T) {
+	type document struct {
+		ID UUID `bson:"id"`
+	}
+
+	roundTrip := func(u UUID) bool {
+		content, err := bson.Marshal(document{u})
+		if err != nil {
+			return false
+		}
+
+		stored := bson.M{}
+		result := document{}
+		return bson.Unmarshal(content, &stored) == nil && stored["id"] == u.String() &&
+			bson.Unmarshal(content, &result) == nil && result.ID == u
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	content, _ := bson.Marshal(bson.M{"id": bson.Binary{Kind: 0x04, Data: NamespaceOID[:]}})
+	result := document{}
+	if err := bson.Unmarshal(content, &result); err != nil || result.ID != NamespaceOID {
+		t.Errorf("expected binary UUIDs to be read, got %s and %v", result.ID, err)
+	}
+}
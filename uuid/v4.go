@@ -2,19 +2,29 @@ package uuid
 
 import (
 	"crypto/rand"
-	"fmt"
 )
 
-type V4 struct {
+// NewV4 returns a random UUID.
+func NewV4() (UUID, error) {
+	u := UUID{}
+	_, err := rand.Read(u[:])
+	if err != nil {
+		return Nil, err
+	}
+
+	u.setVersion(4)
+
+	return u, nil
 }
 
-func (g V4) Generate() string {
-	b := make([]byte, 16)
-	rand.Read(b)
+type V4 struct {
+}
 
-	newUUID := []byte(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
-	newUUID[14] = '4'
-	newUUID[19] = 'a'
+func (g V4) Generate() (string, error) {
+	u, err := NewV4()
+	if err != nil {
+		return "", err
+	}
 
-	return string(newUUID)
+	return u.String(), nil
 }
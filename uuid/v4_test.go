@@ -6,7 +6,10 @@ import (
 )
 
 func TestGenerate(t *testing.T) {
-	uuid := V4{}.Generate()
+	uuid, err := V4{}.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	uuidRegex := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
 
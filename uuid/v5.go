@@ -0,0 +1,18 @@
+package uuid
+
+import (
+	"crypto/sha1"
+)
+
+// NewV5 returns the UUID of the name within the namespace, which is always the same for both.
+func NewV5(namespace UUID, name string) UUID {
+	hash := sha1.New()
+	hash.Write(namespace[:])
+	hash.Write([]byte(name))
+
+	u := UUID{}
+	copy(u[:], hash.Sum(nil))
+	u.setVersion(5)
+
+	return u
+}
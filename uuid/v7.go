@@ -0,0 +1,38 @@
+package uuid
+
+import (
+	"crypto/rand"
+	"time"
+)
+
+// NewV7 returns a UUID starting with the Unix time in milliseconds followed by random bits.
+// UUIDs of different milliseconds sort by their creation time.
+func NewV7() (UUID, error) {
+	u := UUID{}
+	_, err := rand.Read(u[6:])
+	if err != nil {
+		return Nil, err
+	}
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		u[i] = byte(ms >> uint(40-8*i))
+	}
+
+	u.setVersion(7)
+
+	return u, nil
+}
+
+// V7 generates time-ordered UUIDs.
+type V7 struct {
+}
+
+func (g V7) Generate() (string, error) {
+	u, err := NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}